@@ -0,0 +1,244 @@
+#!/usr/bin/env node
+// Host for modules built with `lyca build --target=wasm32 file.lyca`.
+//
+//     node runtime/wasm/host.js file.wasm [function] [arguments...]
+//
+// Calls main, or the named export, with numeric arguments and exits with
+// its result. The module's only import is env.printf, implemented here.
+'use strict';
+
+const fs = require('fs');
+
+class Host {
+    constructor() {
+        this.memory = null;
+        this.imports = {
+            env: {
+                printf: (format, args) => this.printf(format, args),
+            },
+        };
+    }
+
+    bytes() {
+        return new Uint8Array(this.memory.buffer);
+    }
+
+    cstring(ptr) {
+        const bytes = this.bytes();
+        let end = ptr;
+        while (bytes[end] !== 0) {
+            end++;
+        }
+        return Buffer.from(bytes.subarray(ptr, end)).toString('latin1');
+    }
+
+    printf(format, args) {
+        const out = Buffer.from(sprintf(this.cstring(format), new VarArgs(this, args)), 'latin1');
+        process.stdout.write(out);
+        return out.length;
+    }
+}
+
+// VarArgs reads variadic arguments the way the wasm32 C ABI lays them out:
+// a buffer in linear memory with each value at its natural alignment.
+class VarArgs {
+    constructor(host, ptr) {
+        this.host = host;
+        this.ptr = ptr;
+    }
+
+    view(size) {
+        this.ptr = (this.ptr + size - 1) & ~(size - 1);
+        const view = new DataView(this.host.memory.buffer, this.ptr, size);
+        this.ptr += size;
+        return view;
+    }
+
+    int() {
+        return this.view(4).getInt32(0, true);
+    }
+
+    uint() {
+        return this.view(4).getUint32(0, true);
+    }
+
+    double() {
+        return this.view(8).getFloat64(0, true);
+    }
+
+    string() {
+        return this.host.cstring(this.uint());
+    }
+}
+
+const VERB = /%([-+ #0]*)(\d+|\*)?(?:\.(\d+|\*))?(hh|h|ll|l|z)?([diouxXcsfFeEgGp%])/g;
+
+function sprintf(format, args) {
+    return format.replace(VERB, (match, flags, width, precision, length, verb) => {
+        if (verb === '%') {
+            return '%';
+        }
+        if (width === '*') {
+            width = args.int();
+        }
+        if (precision === '*') {
+            precision = args.int();
+        }
+
+        width = width === undefined ? 0 : Number(width);
+        precision = precision === undefined ? undefined : Number(precision);
+        return pad(formatVerb(verb, flags, precision, args), flags, width, 'diouxXfFeEgG'.includes(verb));
+    });
+}
+
+function formatVerb(verb, flags, precision, args) {
+    switch (verb) {
+    case 'd':
+    case 'i': {
+        const n = args.int();
+        return sign(n, flags, String(Math.abs(n)));
+    }
+    case 'u':
+        return String(args.uint());
+    case 'o': {
+        const s = args.uint().toString(8);
+        return flags.includes('#') && s !== '0' ? '0' + s : s;
+    }
+    case 'x':
+    case 'X': {
+        const n = args.uint();
+        const s = (flags.includes('#') && n !== 0 ? '0x' : '') + n.toString(16);
+        return verb === 'X' ? s.toUpperCase() : s;
+    }
+    case 'p': {
+        const ptr = args.uint();
+        return ptr === 0 ? '(nil)' : '0x' + ptr.toString(16);
+    }
+    case 'c':
+        return String.fromCharCode(args.int() & 0xff);
+    case 's': {
+        const s = args.string();
+        return precision === undefined ? s : s.slice(0, precision);
+    }
+    }
+
+    const f = args.double();
+    precision = precision === undefined ? 6 : precision;
+    if (!isFinite(f)) {
+        const s = isNaN(f) ? 'nan' : 'inf';
+        return sign(f, flags, verb === verb.toUpperCase() ? s.toUpperCase() : s);
+    }
+
+    let s;
+    switch (verb.toLowerCase()) {
+    case 'f':
+        s = Math.abs(f).toFixed(precision);
+        break;
+    case 'e':
+        s = exponent(Math.abs(f).toExponential(precision));
+        break;
+    case 'g':
+        s = general(Math.abs(f), precision, flags.includes('#'));
+        break;
+    }
+
+    return sign(f, flags, verb === verb.toUpperCase() ? s.toUpperCase() : s);
+}
+
+// sign prefixes an absolute value's digits with the sign of n.
+function sign(n, flags, digits) {
+    if (n < 0 || Object.is(n, -0)) {
+        return '-' + digits;
+    }
+    if (flags.includes('+')) {
+        return '+' + digits;
+    }
+    if (flags.includes(' ')) {
+        return ' ' + digits;
+    }
+    return digits;
+}
+
+// exponent rewrites JavaScript's 1.5e+2 as C's 1.5e+02.
+function exponent(s) {
+    return s.replace(/e([+-])(\d)$/, 'e$10$2');
+}
+
+function general(f, precision, alternate) {
+    if (precision === 0) {
+        precision = 1;
+    }
+
+    const exp = f === 0 ? 0 : Math.floor(Math.log10(Number(f.toExponential(precision - 1))));
+    let s;
+    if (exp < -4 || exp >= precision) {
+        s = f.toExponential(precision - 1);
+        if (!alternate) {
+            s = s.replace(/\.?0+e/, 'e');
+        }
+        return exponent(s);
+    }
+
+    s = f.toFixed(precision - 1 - exp);
+    if (!alternate && s.includes('.')) {
+        s = s.replace(/\.?0+$/, '');
+    }
+    return s;
+}
+
+function pad(s, flags, width, numeric) {
+    if (s.length >= width) {
+        return s;
+    }
+    if (flags.includes('-')) {
+        return s + ' '.repeat(width - s.length);
+    }
+    if (flags.includes('0') && numeric) {
+        const prefix = /^[-+ ]?(0[xX])?/.exec(s)[0].length;
+        return s.slice(0, prefix) + '0'.repeat(width - s.length) + s.slice(prefix);
+    }
+    return ' '.repeat(width - s.length) + s;
+}
+
+async function main(argv) {
+    if (argv.length < 1) {
+        console.error('usage: host.js file.wasm [function] [arguments...]');
+        return 2;
+    }
+
+    const host = new Host();
+    const module = await WebAssembly.compile(fs.readFileSync(argv[0]));
+    const instance = await WebAssembly.instantiate(module, host.imports);
+    host.memory = instance.exports.memory;
+
+    let name = 'main';
+    let args = argv.slice(1);
+    if (args.length > 0 && typeof instance.exports[args[0]] === 'function') {
+        name = args.shift();
+    }
+
+    const fn = instance.exports[name];
+    if (typeof fn !== 'function') {
+        console.error(`${argv[0]} does not export ${name}`);
+        return 2;
+    }
+
+    const result = fn(...args.map(Number));
+    if (name !== 'main' && result !== undefined) {
+        console.log(result);
+        return 0;
+    }
+
+    return typeof result === 'number' ? result : 0;
+}
+
+if (require.main === module) {
+    main(process.argv.slice(2)).then(code => {
+        process.exitCode = code;
+    }, err => {
+        console.error(err.message);
+        process.exitCode = 1;
+    });
+}
+
+module.exports = { Host, sprintf };
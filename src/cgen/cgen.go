@@ -0,0 +1,339 @@
+package cgen
+
+import (
+    "strconv"
+    "strings"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/sema"
+)
+
+var RESERVED = map[string]bool{
+    "auto": true, "break": true, "case": true, "char": true, "const": true,
+    "continue": true, "default": true, "do": true, "double": true, "else": true,
+    "enum": true, "extern": true, "float": true, "for": true, "goto": true,
+    "if": true, "inline": true, "int": true, "long": true, "register": true,
+    "restrict": true, "return": true, "short": true, "signed": true,
+    "sizeof": true, "static": true, "struct": true, "switch": true,
+    "typedef": true, "union": true, "unsigned": true, "void": true,
+    "volatile": true, "while": true, "bool": true, "true": true, "false": true,
+    "NULL": true, "main": true, "argc": true, "argv": true,
+}
+
+type generator struct {
+    tree *parser.AST
+    info *sema.Info
+
+    out    strings.Builder
+    indent int
+}
+
+// Generate translates a program into a single self-contained C99 file.
+// Function values and arrays are not supported; the first use of one is
+// an error.
+func Generate(tree *parser.AST) (src string, err error) {
+    info, err := sema.Check(tree)
+    if err != nil {
+        return "", err
+    }
+
+    g := &generator{tree: tree, info: info}
+    defer lexer.Recover(&err)
+
+    g.generate()
+    return g.out.String(), nil
+}
+
+func (g *generator) fail(node parser.Node, message string) {
+    lexer.Fail(node.Loc(), message)
+}
+
+func (g *generator) line(parts ...string) {
+    g.out.WriteString(strings.Repeat("    ", g.indent))
+    for _, part := range parts {
+        g.out.WriteString(part)
+    }
+    g.out.WriteString("\n")
+}
+
+func (g *generator) generate() {
+    g.out.WriteString(RUNTIME)
+    g.line()
+
+    templates := g.templates()
+    for _, tmpl := range templates {
+        g.line("typedef struct ", g.templateName(tmpl), " ", g.templateName(tmpl), ";")
+    }
+    g.line()
+
+    for _, tmpl := range templates {
+        g.line("struct ", g.templateName(tmpl), " {")
+        g.indent++
+        for _, field := range tmpl.Fields {
+            g.line(g.declaration(field.Type, localName(field.Name), field.Node), ";")
+        }
+        if len(tmpl.Fields) == 0 {
+            g.line("char unused;")
+        }
+        g.indent--
+        g.line("};")
+        g.line()
+    }
+
+    for _, node := range g.tree.Nodes {
+        switch n := node.(type) {
+        case *parser.TemplateNode:
+            tmpl := g.info.Templates[n.Name.Value]
+            g.line(g.constructorPrototype(tmpl), ";")
+            if tmpl.Constructor != nil {
+                g.line(g.prototype(tmpl.Constructor, g.templateName(tmpl) + "_init", tmpl), ";")
+            }
+            for _, meth := range n.Methods {
+                fn := tmpl.Methods[meth.Function.Signature.Name.Value]
+                g.line(g.prototype(fn, g.methodName(tmpl, fn.Name), tmpl), ";")
+            }
+        case *parser.FuncDeclNode:
+            fn := g.info.Functions[n.Function.Signature.Name.Value]
+            g.line(g.prototype(fn, globalName(fn.Name), nil), ";")
+        }
+    }
+    g.line()
+
+    g.generateGlobals()
+
+    for _, node := range g.tree.Nodes {
+        switch n := node.(type) {
+        case *parser.TemplateNode:
+            g.generateTemplate(g.info.Templates[n.Name.Value])
+        case *parser.FuncDeclNode:
+            fn := g.info.Functions[n.Function.Signature.Name.Value]
+            g.generateFunc(fn, globalName(fn.Name), nil)
+        }
+    }
+
+    g.generateMain()
+}
+
+func (g *generator) templates() (res []*sema.Template) {
+    for _, node := range g.tree.Nodes {
+        if n, ok := node.(*parser.TemplateNode); ok {
+            res = append(res, g.info.Templates[n.Name.Value])
+        }
+    }
+
+    return
+}
+
+func globalName(name string) string {
+    return "ly_" + name
+}
+
+func localName(name string) string {
+    if RESERVED[name] || strings.HasPrefix(name, "ly_") || strings.HasPrefix(name, "lyca_") {
+        return name + "_"
+    }
+
+    return name
+}
+
+func (g *generator) templateName(tmpl *sema.Template) string {
+    return globalName(tmpl.Name)
+}
+
+func (g *generator) methodName(tmpl *sema.Template, name string) string {
+    return g.templateName(tmpl) + "_" + name
+}
+
+func (g *generator) typeName(t sema.Type, node parser.Node) string {
+    switch t {
+    case sema.Int:
+        return "int32_t"
+    case sema.Float:
+        return "float"
+    case sema.Char:
+        return "char"
+    case sema.Boolean:
+        return "bool"
+    case sema.Void:
+        return "void"
+    case sema.String:
+        return "lyca_string *"
+    }
+
+    if tmpl, ok := t.(*sema.Template); ok {
+        return g.templateName(tmpl) + " *"
+    }
+
+    switch t.(type) {
+    case *sema.Func:
+        g.unsupported(node, "function values")
+    case *sema.Array:
+        g.unsupported(node, "arrays")
+    }
+    g.unsupported(node, "values of type " + t.String())
+    return ""
+}
+
+// unsupported reports a feature of the language the C backend lacks.
+func (g *generator) unsupported(node parser.Node, what string) {
+    g.fail(node, "The C backend does not support " + what + ", use the LLVM backend")
+}
+
+func (g *generator) declaration(t sema.Type, name string, node parser.Node) string {
+    typ := g.typeName(t, node)
+    if strings.HasSuffix(typ, "*") {
+        return typ + name
+    }
+
+    return typ + " " + name
+}
+
+func (g *generator) zero(t sema.Type) string {
+    switch t {
+    case sema.Int, sema.Char:
+        return "0"
+    case sema.Float:
+        return "0.0f"
+    case sema.Boolean:
+        return "false"
+    }
+
+    return "NULL"
+}
+
+func (g *generator) prototype(fn *sema.Func, name string, this *sema.Template) string {
+    var params []string
+    if this != nil {
+        params = append(params, g.typeName(this, fn.Node) + "this")
+    }
+
+    for i, param := range fn.Node.Signature.Parameters {
+        params = append(params, g.declaration(fn.Params[i], localName(param.Name.Value), param))
+    }
+
+    if len(params) == 0 {
+        params = append(params, "void")
+    }
+
+    return "static " + g.declaration(fn.Return, name, fn.Node) + "(" + strings.Join(params, ", ") + ")"
+}
+
+func (g *generator) constructorPrototype(tmpl *sema.Template) string {
+    var params []string
+    if tmpl.Constructor != nil {
+        for i, param := range tmpl.Constructor.Node.Signature.Parameters {
+            params = append(params, g.declaration(tmpl.Constructor.Params[i], localName(param.Name.Value), param))
+        }
+    }
+
+    if len(params) == 0 {
+        params = append(params, "void")
+    }
+
+    return "static " + g.typeName(tmpl, tmpl.Node) + g.templateName(tmpl) + "_new(" + strings.Join(params, ", ") + ")"
+}
+
+func (g *generator) generateGlobals() {
+    for _, node := range g.tree.Nodes {
+        if n, ok := node.(*parser.VarDeclNode); ok {
+            g.line("static ", g.declaration(g.info.TypeOf(n), globalName(n.Name.Value), n), ";")
+        }
+    }
+    g.line()
+
+    g.line("static void lyca_init(void) {")
+    g.indent++
+    for _, node := range g.tree.Nodes {
+        if n, ok := node.(*parser.VarDeclNode); ok && n.Value != nil {
+            g.line(globalName(n.Name.Value), " = ", g.expr(n.Value), ";")
+        }
+    }
+    g.indent--
+    g.line("}")
+    g.line()
+}
+
+func (g *generator) generateTemplate(tmpl *sema.Template) {
+    var args []string
+    if tmpl.Constructor != nil {
+        for _, param := range tmpl.Constructor.Node.Signature.Parameters {
+            args = append(args, localName(param.Name.Value))
+        }
+    }
+
+    name := g.templateName(tmpl)
+    g.line(g.constructorPrototype(tmpl), " {")
+    g.indent++
+    g.line(name, " *this = lyca_alloc(sizeof(", name, "));")
+    if tmpl.Constructor != nil {
+        g.line(name, "_init(", strings.Join(append([]string{"this"}, args...), ", "), ");")
+    }
+    g.line("return this;")
+    g.indent--
+    g.line("}")
+    g.line()
+
+    if tmpl.Constructor != nil {
+        g.generateFunc(tmpl.Constructor, name + "_init", tmpl)
+    }
+
+    for _, meth := range tmpl.Node.Methods {
+        fn := tmpl.Methods[meth.Function.Signature.Name.Value]
+        g.generateFunc(fn, g.methodName(tmpl, fn.Name), tmpl)
+    }
+}
+
+func (g *generator) generateFunc(fn *sema.Func, name string, this *sema.Template) {
+    g.line(g.prototype(fn, name, this), " {")
+    g.indent++
+    g.generateStmts(fn.Node.Body.Nodes)
+    g.indent--
+    g.line("}")
+    g.line()
+}
+
+func (g *generator) generateMain() {
+    main, ok := g.info.Functions["main"]
+    if !ok {
+        return
+    }
+
+    params := main.Node.Signature.Parameters
+    g.line("int main(int argc, char **argv) {")
+    g.indent++
+    g.line("lyca_init();")
+    if len(params) > 0 {
+        g.line("if (argc < ", strconv.Itoa(len(params) + 1), ") {")
+        g.indent++
+        g.line("fputs(\"main expects more arguments than were given\\n\", stderr);")
+        g.line("return 1;")
+        g.indent--
+        g.line("}")
+    }
+
+    var args []string
+    for i, param := range params {
+        arg := "argv[" + strconv.Itoa(i + 1) + "]"
+        switch main.Params[i] {
+        case sema.Int:
+            args = append(args, "(int32_t)atoi(" + arg + ")")
+        case sema.Float:
+            args = append(args, "(float)atof(" + arg + ")")
+        case sema.String:
+            args = append(args, "lyca_string_from(" + arg + ")")
+        default:
+            g.fail(param, "main parameters must be int, float or string")
+        }
+    }
+
+    call := globalName("main") + "(" + strings.Join(args, ", ") + ")"
+    if main.Return == sema.Int {
+        g.line("return ", call, ";")
+    } else {
+        g.line(call, ";")
+        g.line("return 0;")
+    }
+    g.indent--
+    g.line("}")
+}
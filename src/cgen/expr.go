@@ -0,0 +1,157 @@
+package cgen
+
+import (
+    "strconv"
+    "strings"
+
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/sema"
+)
+
+func (g *generator) expr(node parser.Node) string {
+    switch n := node.(type) {
+    case *parser.NumLitNode:
+        if n.IsFloat {
+            f := strconv.FormatFloat(n.FloatValue, 'g', -1, 32)
+            if !strings.ContainsAny(f, ".e") {
+                f += ".0"
+            }
+            return f + "f"
+        }
+        return strconv.Itoa(n.IntValue)
+    case *parser.BoolLitNode:
+        return strconv.FormatBool(n.Value)
+    case *parser.CharLitNode:
+        return "((char)" + strconv.Itoa(int(n.Value)) + ")"
+    case *parser.StringLitNode:
+        return "lyca_string_new(" + quote(n.Value) + ", " + strconv.Itoa(len(n.Value)) + ")"
+    case *parser.VarAccessNode:
+        return g.varAccess(n)
+    case *parser.ObjectAccessNode:
+        if _, ok := g.info.TypeOf(n).(*sema.Func); ok {
+            g.fail(n, "Methods can only be called")
+        }
+        return "(" + g.expr(n.Object) + ")->" + localName(n.Member.Value)
+    case *parser.CallExprNode:
+        return g.call(n)
+    case *parser.MakeExprNode:
+        var args []string
+        for _, arg := range n.Arguments {
+            args = append(args, g.expr(arg))
+        }
+        return globalName(n.Template.Value) + "_new(" + strings.Join(args, ", ") + ")"
+    case *parser.UnaryExprNode:
+        return "(" + n.Operator + g.expr(n.Value) + ")"
+    case *parser.BinaryExprNode:
+        return g.binary(n)
+    }
+
+    g.unsupported(node, "this expression")
+    return ""
+}
+
+func (g *generator) varAccess(n *parser.VarAccessNode) string {
+    name := n.Name.Value
+    decl, ok := g.info.Defs[n].(*parser.VarDeclNode)
+    if !ok {
+        switch {
+        case name == "this":
+            return "this"
+        case name == "null":
+            return "NULL"
+        }
+        g.fail(n, "Functions can only be called")
+    }
+
+    if g.info.Globals[name] == decl {
+        return globalName(name)
+    }
+
+    return localName(name)
+}
+
+func (g *generator) call(n *parser.CallExprNode) string {
+    var args []string
+    for _, arg := range n.Arguments {
+        args = append(args, g.expr(arg))
+    }
+
+    switch fn := n.Function.(type) {
+    case *parser.VarAccessNode:
+        if g.info.TypeOf(fn) == sema.Printf {
+            return g.printf(n, args)
+        }
+
+        if _, ok := g.info.Defs[fn].(*parser.FuncNode); ok {
+            return globalName(fn.Name.Value) + "(" + strings.Join(args, ", ") + ")"
+        }
+    case *parser.ObjectAccessNode:
+        tmpl := g.info.TypeOf(fn.Object).(*sema.Template)
+        recv := g.expr(fn.Object)
+        if tmpl == sema.String {
+            return "lyca_string_len(" + recv + ")"
+        }
+
+        if _, ok := g.info.Defs[fn].(*parser.FuncNode); ok {
+            args = append([]string{recv}, args...)
+            return g.methodName(tmpl, fn.Member.Value) + "(" + strings.Join(args, ", ") + ")"
+        }
+    }
+
+    g.unsupported(n.Function, "function values")
+    return ""
+}
+
+// printf passes strings as C strings and promotes floats the way C's
+// variadic calls expect.
+func (g *generator) printf(n *parser.CallExprNode, args []string) string {
+    for i, arg := range n.Arguments {
+        switch g.info.TypeOf(arg) {
+        case sema.String:
+            args[i] = "lyca_cstr(" + args[i] + ")"
+        case sema.Float:
+            args[i] = "(double)" + args[i]
+        case sema.Boolean, sema.Char:
+            args[i] = "(int)" + args[i]
+        }
+    }
+
+    return "printf(" + strings.Join(args, ", ") + ")"
+}
+
+func (g *generator) binary(n *parser.BinaryExprNode) string {
+    left, right := g.expr(n.Left), g.expr(n.Right)
+    op := n.Operator.Value
+
+    switch {
+    case op == "+" && g.info.TypeOf(n) == sema.String:
+        return "lyca_string_concat(" + left + ", " + right + ")"
+    case op == "%" && g.info.TypeOf(n) == sema.Float:
+        return "fmodf(" + left + ", " + right + ")"
+    }
+
+    return "(" + left + " " + op + " " + right + ")"
+}
+
+func quote(str string) string {
+    var out strings.Builder
+    out.WriteByte('"')
+    for i := 0; i < len(str); i++ {
+        c := str[i]
+        switch {
+        case c == '"' || c == '\\':
+            out.WriteByte('\\')
+            out.WriteByte(c)
+        case c == '?':
+            // Avoid accidentally forming trigraphs.
+            out.WriteString("\\?")
+        case c >= 0x20 && c < 0x7f:
+            out.WriteByte(c)
+        default:
+            out.WriteString("\\" + strconv.FormatInt(int64(c) | 0x200, 8)[1:])
+        }
+    }
+    out.WriteByte('"')
+
+    return out.String()
+}
@@ -0,0 +1,60 @@
+package cgen
+
+// RUNTIME is emitted at the top of every generated file so the output
+// builds on its own with any C99 compiler. Strings keep the layout the
+// LLVM backend uses: the length includes the terminating NUL.
+const RUNTIME = `#include <math.h>
+#include <stdbool.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+typedef struct lyca_string {
+    char *chars;
+    int32_t len;
+    int32_t cap;
+} lyca_string;
+
+static void *lyca_alloc(size_t size) {
+    void *mem = calloc(1, size);
+    if (mem == NULL) {
+        fputs("out of memory\n", stderr);
+        exit(1);
+    }
+    return mem;
+}
+
+static lyca_string *lyca_string_new(const char *chars, int32_t len) {
+    lyca_string *str = lyca_alloc(sizeof(lyca_string));
+    str->chars = lyca_alloc((size_t)len + 1);
+    memcpy(str->chars, chars, (size_t)len);
+    str->len = len + 1;
+    str->cap = len + 1;
+    return str;
+}
+
+static lyca_string *lyca_string_from(const char *chars) {
+    return lyca_string_new(chars, (int32_t)strlen(chars));
+}
+
+static int32_t lyca_string_len(lyca_string *str) {
+    return str->len - 1;
+}
+
+static lyca_string *lyca_string_concat(lyca_string *a, lyca_string *b) {
+    int32_t alen = lyca_string_len(a);
+    int32_t blen = lyca_string_len(b);
+    lyca_string *str = lyca_alloc(sizeof(lyca_string));
+    str->chars = lyca_alloc((size_t)alen + blen + 1);
+    memcpy(str->chars, a->chars, (size_t)alen);
+    memcpy(str->chars + alen, b->chars, (size_t)blen);
+    str->len = alen + blen + 1;
+    str->cap = str->len;
+    return str;
+}
+
+static const char *lyca_cstr(lyca_string *str) {
+    return str == NULL ? "(null)" : str->chars;
+}
+`
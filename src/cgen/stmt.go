@@ -0,0 +1,90 @@
+package cgen
+
+import (
+    "github.com/k3v/lyca/src/parser"
+)
+
+func (g *generator) generateStmts(nodes []parser.Node) {
+    for _, node := range nodes {
+        g.generateStmt(node)
+    }
+}
+
+func (g *generator) generateStmt(node parser.Node) {
+    switch n := node.(type) {
+    case *parser.VarDeclNode, *parser.AssignStmtNode, *parser.CallStmtNode:
+        g.line(g.simpleStmt(n), ";")
+    case *parser.ReturnStmtNode:
+        if n.Value == nil {
+            g.line("return;")
+        } else {
+            g.line("return ", g.expr(n.Value), ";")
+        }
+    case *parser.IfStmtNode:
+        g.line("if (", g.expr(n.Condition), ") {")
+        g.generateIf(n)
+    case *parser.LoopStmtNode:
+        init, cond, post := "", "", ""
+        if n.Init != nil {
+            init = g.simpleStmt(n.Init)
+        }
+        if n.Cond != nil {
+            cond = g.expr(n.Cond)
+        }
+        if n.Post != nil {
+            post = g.simpleStmt(n.Post)
+        }
+
+        g.line("for (", init, "; ", cond, "; ", post, ") {")
+        g.block(n.Body)
+        g.line("}")
+    case *parser.BlockNode:
+        g.line("{")
+        g.block(n)
+        g.line("}")
+    default:
+        g.fail(node, "Unsupported statement")
+    }
+}
+
+func (g *generator) generateIf(n *parser.IfStmtNode) {
+    g.block(n.Body)
+
+    switch e := n.Else.(type) {
+    case *parser.IfStmtNode:
+        g.line("} else if (", g.expr(e.Condition), ") {")
+        g.generateIf(e)
+        return
+    case *parser.BlockNode:
+        g.line("} else {")
+        g.block(e)
+    }
+
+    g.line("}")
+}
+
+func (g *generator) block(block *parser.BlockNode) {
+    g.indent++
+    g.generateStmts(block.Nodes)
+    g.indent--
+}
+
+// simpleStmt renders statements that may appear in a for clause.
+func (g *generator) simpleStmt(node parser.Node) string {
+    switch n := node.(type) {
+    case *parser.VarDeclNode:
+        t := g.info.TypeOf(n)
+        value := g.zero(t)
+        if n.Value != nil {
+            value = g.expr(n.Value)
+        }
+        return g.declaration(t, localName(n.Name.Value), n) + " = " + value
+    case *parser.AssignStmtNode:
+        return g.expr(n.Target) + " = " + g.expr(n.Value)
+    case *parser.CallStmtNode:
+        return g.expr(n.Call)
+    }
+
+    g.fail(node, "Unsupported statement")
+    return ""
+}
@@ -1,9 +1,10 @@
 package codegen
 
 import (
-    "log"
+    "fmt"
 
     "llvm.org/llvm/bindings/go/llvm"
+    "github.com/k3v/lyca/src/lexer"
     "github.com/k3v/lyca/src/parser"
 )
 
@@ -14,42 +15,104 @@ type Template struct {
     HasConstructor bool
 }
 
+// Codegen owns its LLVM context and everything created in it, so separate
+// Codegens can run in parallel goroutines. Call Dispose when done.
 type Codegen struct {
     tree *parser.AST
     scope *Scope
+    options Options
 
+    ctx llvm.Context
+    primitives map[string]llvm.Type
     module llvm.Module
     builder llvm.Builder
 
+    machine llvm.TargetMachine
+    targetData llvm.TargetData
+    intPtrType llvm.Type
+
     templates map[string]*Template
     functions map[string]llvm.BasicBlock
 
+    // unit holds the top level nodes this Codegen generates; the rest
+    // of the tree is only declared. A nil unit owns the whole tree.
+    unit map[parser.Node]bool
+
     currFunc string
 }
 
-func Construct(tree *parser.AST) *Codegen {
-    return &Codegen{
+func Construct(tree *parser.AST, options Options) (*Codegen, error) {
+    ctx := llvm.NewContext()
+    c := &Codegen{
         tree: tree,
         scope: &Scope{variables: map[string]llvm.Value{}},
+        options: options,
 
-        module: llvm.NewModule("main"),
-        builder: llvm.NewBuilder(),
+        ctx: ctx,
+        primitives: primitiveTypes(ctx),
+        module: ctx.NewModule("main"),
+        builder: ctx.NewBuilder(),
 
         templates: map[string]*Template{},
         functions: map[string]llvm.BasicBlock{},
     }
+
+    if err := c.configureTarget(); err != nil {
+        c.Dispose()
+        return nil, err
+    }
+
+    return c, nil
+}
+
+// Dispose frees the module, builder, target machine and context. A
+// module handed to an execution engine is freed with the engine instead.
+func (c *Codegen) Dispose() {
+    c.builder.Dispose()
+    if c.machine != (llvm.TargetMachine{}) {
+        c.targetData.Dispose()
+        c.machine.Dispose()
+    }
+    if c.module != (llvm.Module{}) {
+        c.module.Dispose()
+    }
+    c.ctx.Dispose()
+}
+
+// Module is the module c generates into. It is freed with c.
+func (c *Codegen) Module() llvm.Module {
+    return c.module
+}
+
+// Generate builds, verifies and optimizes the module and returns its IR.
+func (c *Codegen) Generate() (string, error) {
+    if err := c.build(); err != nil {
+        return "", err
+    }
+
+    if err := llvm.VerifyModule(c.module, llvm.ReturnStatusAction); err != nil {
+        return "", err
+    }
+    c.optimize()
+
+    return c.module.String(), nil
 }
 
-func (c *Codegen) Generate() string {
+func (c *Codegen) build() (err error) {
+    defer lexer.Recover(&err)
+
     c.injectStdLib()
     c.declareTopLevelNodes()
     c.generateTopLevelNodes()
+    return
+}
 
-    if ok := llvm.VerifyModule(c.module, llvm.ReturnStatusAction); ok != nil {
-        log.Println(ok.Error())
-    }
+func (c *Codegen) fail(node parser.Node, message string) {
+    lexer.Fail(node.Loc(), message)
+}
 
-    return c.module.String()
+func (c *Codegen) owns(node parser.Node) bool {
+    return c.unit == nil || c.unit[node]
 }
 
 func (c *Codegen) enterScope() {
@@ -72,34 +135,69 @@ func (c *Codegen) declareTopLevelNodes() {
     for _, node := range c.tree.Nodes {
         switch n := node.(type) {
         case *parser.FuncDeclNode:
-            c.declareFunc(n.Function, llvm.VoidType())
+            name := n.Function.Signature.Name.Value
+            c.declareFunc(n.Function, c.ctx.VoidType(), c.mangle(name), c.owns(n))
+            if c.owns(n) && c.IsWasm() && (n.Export || name == "main") {
+                c.exportFunc(name)
+            }
         case *parser.TemplateNode:
             c.declareTemplate(n)
+        case *parser.VarDeclNode:
+            if !c.owns(n) {
+                c.declareGlobal(n)
+            }
         }
     }
 }
 
 func (c *Codegen) presetTemplate(n *parser.TemplateNode) {
     c.templates[n.Name.Value] = &Template{
-        Type: llvm.GlobalContext().StructCreateNamed(n.Name.Value),
+        Type: c.namedStruct(n.Name.Value),
         Variables: map[string]int{},
     }
     c.templates[n.Name.Value].Values = n.Variables
 }
 
-func (c *Codegen) declareFunc(n *parser.FuncNode, obj llvm.Type) {
+func (c *Codegen) namedStruct(name string) llvm.Type {
+    if t := c.module.GetTypeByName(name); !t.IsNil() {
+        return t
+    }
+
+    return c.ctx.StructCreateNamed(name)
+}
+
+// declareFunc adds the function to the module. Only defined functions
+// get an entry block, the rest are external declarations.
+func (c *Codegen) declareFunc(n *parser.FuncNode, obj llvm.Type, name string, define bool) {
     sig := n.Signature
-    name := c.mangle(sig.Name.Value)
     f := c.getLLVMFuncType(sig.Return, sig.Parameters, obj)
     llvmf := llvm.AddFunction(c.module, name, f)
 
-    if obj != llvm.VoidType() {
+    if obj != c.ctx.VoidType() {
         llvmf.Param(0).SetName("this")
     }
 
-    block := llvm.AddBasicBlock(c.module.NamedFunction(name),"entry")
+    if define {
+        c.functions[name] = c.ctx.AddBasicBlock(llvmf, blockName(n, "entry"))
+    }
+}
+
+// blockName prefixes the name of a basic block with the source line of
+// node, as in L12.if.then, so IR and control flow graphs lead back to the
+// source. The line goes first since LLVM makes names unique by appending
+// digits.
+func blockName(node parser.Node, name string) string {
+    return fmt.Sprintf("L%d.%s", node.Loc().Start.Line, name)
+}
+
+// declareGlobal declares a global variable that another unit defines.
+func (c *Codegen) declareGlobal(n *parser.VarDeclNode) {
+    global := llvm.AddGlobal(c.module, c.getLLVMType(n.Type), n.Name.Value)
+    c.scope.AddVariable(n.Name.Value, global)
+}
 
-    c.functions[name] = block
+func methodName(tmpl *parser.TemplateNode, meth *parser.FuncNode) string {
+    return "-" + tmpl.Name.Value + "-" + meth.Signature.Name.Value
 }
 
 func (c *Codegen) declareTemplate(n *parser.TemplateNode) {
@@ -120,14 +218,13 @@ func (c *Codegen) declareTemplate(n *parser.TemplateNode) {
                 Parameters: n.Constructor.Parameters,
             },
         }
-        c.declareFunc(f, pointer)
+        f.SetLoc(n.Constructor.Loc())
+        c.declareFunc(f, pointer, "-" + name, c.owns(n))
         c.templates[name].HasConstructor = true
     }
 
     for _, meth := range n.Methods {
-        name := "-" + n.Name.Value + "-" + meth.Function.Signature.Name.Value
-        meth.Function.Signature.Name = parser.Identifier{Value: name}
-        c.declareFunc(meth.Function, pointer)
+        c.declareFunc(meth.Function, pointer, methodName(n, meth.Function), c.owns(n))
     }
 }
 
@@ -135,14 +232,23 @@ func (c *Codegen) getFunction(node parser.Node) (llvm.Value, []llvm.Value) {
     switch t := node.(type) {
     case *parser.VarAccessNode:
         name := c.mangle(t.Name.Value)
-        return c.module.NamedFunction(name), []llvm.Value{}
+        fn := c.module.NamedFunction(name)
+        if fn.IsNil() {
+            c.fail(t, "Undefined function " + t.Name.Value)
+        }
+        return fn, []llvm.Value{}
     case *parser.ObjectAccessNode:
         tmpl := c.getStructFromPointer(c.getLLVMType(t.Object))
         obj := c.generateAccess(t.Object, true)
 
-        return c.module.NamedFunction("-" + tmpl + "-" + t.Member.Value), []llvm.Value{obj}
+        fn := c.module.NamedFunction("-" + tmpl + "-" + t.Member.Value)
+        if fn.IsNil() {
+            c.fail(t, "Undefined method " + t.Member.Value)
+        }
+        return fn, []llvm.Value{obj}
     }
 
+    c.fail(node, "Expression is not callable")
     return null, []llvm.Value{}
 }
 
@@ -158,21 +264,30 @@ func (c *Codegen) getCurrParam(name string) llvm.Value {
 }
 
 func (c *Codegen) generateTopLevelNodes() {
+    // globals first, functions may use those declared after them
+    for _, node := range c.tree.Nodes {
+        if n, ok := node.(*parser.VarDeclNode); ok && c.owns(n) {
+            c.generateVarDecl(n, true)
+        }
+    }
+
     for _, node := range c.tree.Nodes {
+        if !c.owns(node) {
+            continue
+        }
+
         switch n := node.(type) {
         case *parser.TemplateNode:
             c.generateTemplateDecl(n)
         case *parser.FuncDeclNode:
-            c.generateFunc(n.Function)
-        case *parser.VarDeclNode:
-            c.generateVarDecl(n, true)
+            c.generateFunc(n.Function, c.mangle(n.Function.Signature.Name.Value))
         }
     }
 }
 
-func (c *Codegen) generateFunc(node *parser.FuncNode) {
+func (c *Codegen) generateFunc(node *parser.FuncNode, name string) {
     c.enterScope()
-    c.currFunc = c.mangle(node.Signature.Name.Value)
+    c.currFunc = name
     block := c.functions[c.currFunc]
     c.builder.SetInsertPoint(block, block.LastInstruction())
     llvmf := c.module.NamedFunction(c.currFunc)
@@ -234,11 +349,11 @@ func (c *Codegen) generateTemplateDecl(node *parser.TemplateNode) {
             },
             Body: node.Constructor.Body,
         }
-        c.generateFunc(f)
+        c.generateFunc(f, "-" + name)
     }
 
     for _, meth := range node.Methods {
-        c.generateFunc(meth.Function)
+        c.generateFunc(meth.Function, methodName(node, meth.Function))
     }
 }
 
@@ -267,6 +382,10 @@ func (c *Codegen) generateCall(node *parser.CallExprNode, obj llvm.Value) llvm.V
             expr = c.unbox(expr)
         }
 
+        if fn.Type().ElementType().IsFunctionVarArg() && i >= fn.Type().ElementType().ParamTypesCount() {
+            expr = c.promote(expr)
+        }
+
         args = append(args, expr)
     }
 
@@ -274,8 +393,11 @@ func (c *Codegen) generateCall(node *parser.CallExprNode, obj llvm.Value) llvm.V
 }
 
 func (c *Codegen) generateMake(node *parser.MakeExprNode) llvm.Value {
-    t := c.templates[node.Template.Value]
-    alloc := c.builder.CreateMalloc(t.Type, "")
+    t, ok := c.templates[node.Template.Value]
+    if !ok {
+        c.fail(node, "Undefined template " + node.Template.Value)
+    }
+    alloc := c.createMalloc(t.Type)
 
     for i, el := range t.Type.StructElementTypes() {
         if el.TypeKind() == llvm.PointerTypeKind {
@@ -307,12 +429,12 @@ func (c *Codegen) generateReturn(node *parser.ReturnStmtNode) {
 func (c *Codegen) generateControl(node *parser.IfStmtNode) (ret bool) {
     currFunc := c.module.NamedFunction(c.currFunc)
 
-    tru  := llvm.AddBasicBlock(currFunc, "")
+    tru  := c.ctx.AddBasicBlock(currFunc, blockName(node, "if.then"))
     var els llvm.BasicBlock
     if node.Else != nil {
-        els = llvm.AddBasicBlock(currFunc, "")
+        els = c.ctx.AddBasicBlock(currFunc, blockName(node.Else, "if.else"))
     }
-    exit := llvm.AddBasicBlock(currFunc, "")
+    exit := c.ctx.AddBasicBlock(currFunc, blockName(node, "if.end"))
 
     cond := c.generateExpression(node.Condition)
     if node.Else != nil {
@@ -354,9 +476,9 @@ func (c *Codegen) generateLoop(node *parser.LoopStmtNode) (ret bool) {
     if node.Init != nil {
         c.generateVarDecl(node.Init, false)
     }
-    entry := llvm.AddBasicBlock(currFunc, "")
-    body := llvm.AddBasicBlock(currFunc, "")
-    exit  := llvm.AddBasicBlock(currFunc, "")
+    entry := c.ctx.AddBasicBlock(currFunc, blockName(node, "for.cond"))
+    body := c.ctx.AddBasicBlock(currFunc, blockName(node, "for.body"))
+    exit  := c.ctx.AddBasicBlock(currFunc, blockName(node, "for.end"))
     c.builder.CreateBr(entry)
 
     c.builder.SetInsertPoint(entry, entry.LastInstruction())
@@ -418,6 +540,9 @@ func (c *Codegen) generateAccess(node parser.Node, val bool) (v llvm.Value) {
             return param
         } else {
             v = c.scope.GetValue(name);
+            if v.IsNil() {
+                c.fail(t, "Undefined variable " + name)
+            }
 
             if name == "null" {
                 return
@@ -425,7 +550,15 @@ func (c *Codegen) generateAccess(node parser.Node, val bool) (v llvm.Value) {
         }
     case *parser.ObjectAccessNode:
         obj := c.generateAccess(t.Object, true)
-        index := c.templates[obj.Type().ElementType().StructName()].Variables[t.Member.Value]
+        tmpl, ok := c.templates[c.getStructFromPointer(obj.Type())]
+        if !ok {
+            c.fail(t.Object, "Expression is not an object")
+        }
+
+        index, ok := tmpl.Variables[t.Member.Value]
+        if !ok {
+            c.fail(t, "Undefined member " + t.Member.Value)
+        }
         v = c.builder.CreateStructGEP(obj, index, "")
     case *parser.StringLitNode:
         return c.generateStringLiteral(t)
@@ -449,9 +582,9 @@ func (c *Codegen) generateExpression(node parser.Node) llvm.Value {
         return c.generateBinaryExpression(n)
     case *parser.NumLitNode:
         if n.IsFloat {
-            return llvm.ConstFloat(PRIMITIVE_TYPES["float"], n.FloatValue)
+            return llvm.ConstFloat(c.primitives["float"], n.FloatValue)
         } else {
-            return llvm.ConstInt(PRIMITIVE_TYPES["int"], uint64(n.IntValue), false)
+            return llvm.ConstInt(c.primitives["int"], uint64(n.IntValue), false)
         }
     case *parser.BoolLitNode:
         i := 0
@@ -459,9 +592,9 @@ func (c *Codegen) generateExpression(node parser.Node) llvm.Value {
             i = 1
         }
 
-        return llvm.ConstInt(PRIMITIVE_TYPES["boolean"], uint64(i), false)
+        return llvm.ConstInt(c.primitives["boolean"], uint64(i), false)
     case *parser.CharLitNode:
-        return llvm.ConstInt(PRIMITIVE_TYPES["char"], uint64(n.Value), false)
+        return llvm.ConstInt(c.primitives["char"], uint64(n.Value), false)
     case *parser.VarAccessNode, *parser.ObjectAccessNode, *parser.ArrayAccessNode, *parser.CallExprNode, *parser.StringLitNode, *parser.MakeExprNode:
         return c.generateAccess(n, true)
     }
@@ -472,10 +605,10 @@ func (c *Codegen) generateExpression(node parser.Node) llvm.Value {
 func (c *Codegen) generateBinaryExpression(node *parser.BinaryExprNode) llvm.Value {
     left := c.generateExpression(node.Left)
     right := c.generateExpression(node.Right)
-    if left.Type() == PRIMITIVE_TYPES["float"] && right.Type() == PRIMITIVE_TYPES["int"] {
-        right = c.convert(right, PRIMITIVE_TYPES["float"])
-    } else if left.Type() == PRIMITIVE_TYPES["int"] && right.Type() == PRIMITIVE_TYPES["float"] {
-        left = c.convert(left, PRIMITIVE_TYPES["float"])
+    if left.Type() == c.primitives["float"] && right.Type() == c.primitives["int"] {
+        right = c.convert(right, c.primitives["float"])
+    } else if left.Type() == c.primitives["int"] && right.Type() == c.primitives["float"] {
+        left = c.convert(left, c.primitives["float"])
     } else if left == c.scope.GetValue("null") {
         left = c.convert(left, right.Type())
     } else if right == c.scope.GetValue("null") {
@@ -498,29 +631,29 @@ func (c *Codegen) generateArithmeticBinaryExpr(left, right llvm.Value, op string
     t := c.getUnderlyingType(left.Type())
     switch op {
     case "+":
-        if t == PRIMITIVE_TYPES["float"] {
+        if t == c.primitives["float"] {
             return c.builder.CreateFAdd(left, right, "")
-        } else if t == PRIMITIVE_TYPES["int"] {
+        } else if t == c.primitives["int"] {
             return c.builder.CreateAdd(left, right, "")
         } else if t == c.templates["string"].Type {
             return c.generateStringConcat(left, right)
         }
     case "-":
-        if t == PRIMITIVE_TYPES["float"] {
+        if t == c.primitives["float"] {
             return c.builder.CreateFSub(left, right, "")
-        } else if t == PRIMITIVE_TYPES["int"] {
+        } else if t == c.primitives["int"] {
             return c.builder.CreateSub(left, right, "")
         }
     case "*":
-        if t == PRIMITIVE_TYPES["float"] {
+        if t == c.primitives["float"] {
             return c.builder.CreateFMul(left, right, "")
-        } else if t == PRIMITIVE_TYPES["int"] {
+        } else if t == c.primitives["int"] {
             return c.builder.CreateMul(left, right, "")
         }
     case "/":
-        if t == PRIMITIVE_TYPES["float"] {
+        if t == c.primitives["float"] {
             return c.builder.CreateFDiv(left, right, "")
-        } else if t == PRIMITIVE_TYPES["int"] {
+        } else if t == c.primitives["int"] {
             return c.builder.CreateSDiv(left, right, "")
         }
     }
@@ -540,9 +673,9 @@ var (
 
 func (c *Codegen) generateComparisonBinaryExpr(left, right llvm.Value, op string) llvm.Value {
     t := c.getUnderlyingType(left.Type())
-    if t == PRIMITIVE_TYPES["float"] {
+    if t == c.primitives["float"] {
         return c.builder.CreateFCmp(floatPredicates[op], left, right, "")
-    } else if t == PRIMITIVE_TYPES["int"] || op == "==" || op == "!=" {
+    } else if t == c.primitives["int"] || op == "==" || op == "!=" {
         //log.Println("Left:", left.Type(), "Right:", right.Type())
         return c.builder.CreateICmp(intPredicates[op], left, right, "")
     }
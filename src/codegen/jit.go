@@ -0,0 +1,134 @@
+package codegen
+
+/*
+#include <stdio.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+    "errors"
+    "sync"
+    "unsafe"
+
+    "llvm.org/llvm/bindings/go/llvm"
+)
+
+var initJIT sync.Once
+
+func initExecution() {
+    initJIT.Do(func() {
+        llvm.LinkInMCJIT()
+        llvm.InitializeNativeTarget()
+        llvm.InitializeNativeAsmPrinter()
+    })
+}
+
+func flushStdout() {
+    C.fflush(nil)
+}
+
+// Run executes main in-process. libc symbols such as printf resolve
+// against the host process. Run hands the module over to the execution
+// engine, so the Codegen can't be used afterwards.
+func (c *Codegen) Run(args []string) (int, error) {
+    initExecution()
+
+    entry, err := c.generateEntry(len(args) - 1)
+    if err != nil {
+        return 0, err
+    }
+
+    opts := llvm.NewMCJITCompilerOptions()
+    opts.SetMCJITOptimizationLevel(uint(c.options.OptLevel))
+    ee, err := llvm.NewMCJITCompiler(c.module, opts)
+    if err != nil {
+        return 0, err
+    }
+    c.module = llvm.Module{}
+    defer ee.Dispose()
+
+    argv := newCArgs(args)
+    defer argv.free()
+
+    ret := ee.RunFunction(entry, []llvm.GenericValue{
+        llvm.NewGenericValueFromInt(c.primitives["int"], uint64(len(args)), true),
+        llvm.NewGenericValueFromPointer(argv.ptr),
+    })
+    flushStdout()
+
+    return int(int32(ret.Int(true))), nil
+}
+
+// generateEntry wraps main in a C style (argc, argv) entry point, binding
+// command line arguments to main's parameters in order.
+func (c *Codegen) generateEntry(argc int) (llvm.Value, error) {
+    main := c.module.NamedFunction("main")
+    if main.IsNil() {
+        return null, errors.New("no main function to run")
+    }
+    if main.ParamsCount() > argc {
+        return null, errors.New("main expects more arguments than were given")
+    }
+
+    charPtr := llvm.PointerType(c.primitives["char"], 0)
+    t := llvm.FunctionType(c.primitives["int"], []llvm.Type{
+        c.primitives["int"],
+        llvm.PointerType(charPtr, 0),
+    }, false)
+    entry := llvm.AddFunction(c.module, "-lyca-entry", t)
+    block := c.ctx.AddBasicBlock(entry, "entry")
+    c.builder.SetInsertPointAtEnd(block)
+
+    var args []llvm.Value
+    for i, param := range main.Params() {
+        index := llvm.ConstInt(c.primitives["int"], uint64(i + 1), false)
+        arg := c.builder.CreateLoad(c.builder.CreateGEP(entry.Param(1), []llvm.Value{index}, ""), "")
+
+        switch param.Type() {
+        case c.primitives["int"]:
+            arg = c.builder.CreateCall(c.libcFunction("atoi", c.primitives["int"], charPtr), []llvm.Value{arg}, "")
+        case c.primitives["float"]:
+            arg = c.builder.CreateCall(c.libcFunction("atof", c.ctx.DoubleType(), charPtr), []llvm.Value{arg}, "")
+            arg = c.builder.CreateFPTrunc(arg, c.primitives["float"], "")
+        case llvm.PointerType(c.templates["string"].Type, 0):
+            arg = c.generateStringFromC(arg)
+        default:
+            return null, errors.New("main parameters must be int, float or string")
+        }
+
+        args = append(args, arg)
+    }
+
+    ret := c.builder.CreateCall(main, args, "")
+    if ret.Type() == c.primitives["int"] {
+        c.builder.CreateRet(ret)
+    } else {
+        c.builder.CreateRet(llvm.ConstInt(c.primitives["int"], 0, false))
+    }
+
+    return entry, nil
+}
+
+type cArgs struct {
+    ptr  unsafe.Pointer
+    strs []*C.char
+}
+
+func newCArgs(args []string) *cArgs {
+    ptr := C.malloc(C.size_t(len(args) + 1) * C.size_t(unsafe.Sizeof(uintptr(0))))
+    strs := unsafe.Slice((**C.char)(ptr), len(args) + 1)
+    for i, arg := range args {
+        strs[i] = C.CString(arg)
+    }
+    strs[len(args)] = nil
+
+    return &cArgs{ptr, strs}
+}
+
+func (a *cArgs) free() {
+    for _, str := range a.strs {
+        C.free(unsafe.Pointer(str))
+    }
+    C.free(a.ptr)
+}
@@ -0,0 +1,62 @@
+package codegen
+
+import (
+    "llvm.org/llvm/bindings/go/llvm"
+)
+
+type Options struct {
+    OptLevel  int
+    SizeLevel int
+
+    Triple   string
+    CPU      string
+    Features string
+}
+
+var inlineThresholds = []uint{0, 0, 225, 275}
+
+func (c *Codegen) optimize() {
+    if c.options.OptLevel == 0 && c.options.SizeLevel == 0 {
+        return
+    }
+
+    pmb := llvm.NewPassManagerBuilder()
+    defer pmb.Dispose()
+
+    pmb.SetOptLevel(c.options.OptLevel)
+    pmb.SetSizeLevel(c.options.SizeLevel)
+
+    threshold := inlineThresholds[c.options.OptLevel]
+    if c.options.SizeLevel > 0 {
+        threshold = 75
+    }
+    if threshold > 0 {
+        pmb.UseInlinerWithThreshold(threshold)
+    }
+
+    fpm := llvm.NewFunctionPassManagerForModule(c.module)
+    defer fpm.Dispose()
+
+    // Every local and parameter lives in an alloca, so promote them to
+    // registers before anything else looks at the function.
+    fpm.AddPromoteMemoryToRegisterPass()
+    fpm.AddInstructionCombiningPass()
+    fpm.AddReassociatePass()
+    fpm.AddGVNPass()
+    fpm.AddCFGSimplificationPass()
+    pmb.PopulateFunc(fpm)
+
+    fpm.InitializeFunc()
+    for f := c.module.FirstFunction(); !f.IsNil(); f = llvm.NextFunction(f) {
+        if f.BasicBlocksCount() > 0 {
+            fpm.RunFunc(f)
+        }
+    }
+    fpm.FinalizeFunc()
+
+    mpm := llvm.NewPassManager()
+    defer mpm.Dispose()
+
+    pmb.Populate(mpm)
+    mpm.Run(c.module)
+}
@@ -0,0 +1,285 @@
+package codegen
+
+import (
+    "strconv"
+
+    "llvm.org/llvm/bindings/go/llvm"
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/sema"
+)
+
+// A Session compiles a sequence of inputs into separate modules that all
+// live in one execution engine. Templates, functions and globals of
+// earlier inputs are redeclared as externals in every new module, so
+// they stay alive for the rest of the session. Every input is type
+// checked against the declarations before it, so a mistake gets a
+// diagnostic instead of reaching code generation.
+type Session struct {
+    *Codegen
+
+    engine llvm.ExecutionEngine
+    prev   llvm.Module
+    inputs int
+
+    // decls are the declarations of every input so far, which each new
+    // input is type checked against
+    decls []parser.Node
+}
+
+type snapshot struct {
+    scope     *Scope
+    variables map[string]llvm.Value
+    templates map[string]*Template
+}
+
+func NewSession(options Options) (*Session, error) {
+    initExecution()
+
+    c, err := Construct(&parser.AST{}, options)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := c.build(); err != nil {
+        c.Dispose()
+        return nil, err
+    }
+
+    opts := llvm.NewMCJITCompilerOptions()
+    opts.SetMCJITOptimizationLevel(uint(options.OptLevel))
+    engine, err := llvm.NewMCJITCompiler(c.module, opts)
+    if err != nil {
+        c.Dispose()
+        return nil, err
+    }
+
+    return &Session{Codegen: c, engine: engine, prev: c.module}, nil
+}
+
+// Close frees the engine, which owns every module of the session, and
+// then the session's context.
+func (s *Session) Close() {
+    s.engine.Dispose()
+    s.module = llvm.Module{}
+    s.Dispose()
+}
+
+// Declare adds top level declarations to the session. Global variables
+// are initialized by a statement of their own instead of a constant
+// initializer, so any expression can be used as their value.
+func (s *Session) Declare(nodes []parser.Node) error {
+    var decls, inits []parser.Node
+    for _, node := range nodes {
+        if err := s.checkDeclared(node); err != nil {
+            return err
+        }
+    }
+    if _, err := sema.Check(s.declared(nodes...)); err != nil {
+        return err
+    }
+
+    for _, node := range nodes {
+        decl, ok := node.(*parser.VarDeclNode)
+        if !ok || decl.Value == nil {
+            decls = append(decls, node)
+            continue
+        }
+
+        global := *decl
+        global.Value = nil
+        decls = append(decls, &global)
+
+        target := &parser.VarAccessNode{Name: decl.Name}
+        target.SetLoc(decl.Name.Loc)
+        init := &parser.AssignStmtNode{Target: target, Value: decl.Value}
+        init.SetLoc(decl.Loc())
+        inits = append(inits, init)
+    }
+
+    if err := s.eval(decls, inits, nil); err != nil {
+        return err
+    }
+    s.decls = append(s.decls, nodes...)
+    return nil
+}
+
+// Exec runs statements in a function of their own.
+func (s *Session) Exec(stmts []parser.Node) error {
+    if _, err := sema.Check(s.declared(wrapper("-repl-check", stmts))); err != nil {
+        return err
+    }
+    return s.eval(nil, stmts, nil)
+}
+
+// Print evaluates an expression and prints its value.
+func (s *Session) Print(expr parser.Node) error {
+    if _, err := sema.CheckExpr(s.declared(), expr); err != nil {
+        return err
+    }
+    return s.eval(nil, nil, expr)
+}
+
+// declared is a tree of the declarations of the session followed by
+// nodes.
+func (s *Session) declared(nodes ...parser.Node) *parser.AST {
+    return &parser.AST{Nodes: append(append([]parser.Node{}, s.decls...), nodes...)}
+}
+
+// wrapper is a function without parameters named name, which is not a
+// Lyca identifier, running stmts.
+func wrapper(name string, stmts []parser.Node) *parser.FuncDeclNode {
+    return &parser.FuncDeclNode{Function: &parser.FuncNode{
+        Signature: &parser.FuncSignatureNode{Name: parser.Identifier{Value: name}},
+        Body: &parser.BlockNode{Nodes: stmts},
+    }}
+}
+
+func (s *Session) checkDeclared(node parser.Node) error {
+    var name string
+    var declared bool
+    switch n := node.(type) {
+    case *parser.TemplateNode:
+        name = n.Name.Value
+        _, declared = s.templates[name]
+    case *parser.FuncDeclNode:
+        name = n.Function.Signature.Name.Value
+        declared = !s.prev.NamedFunction(s.mangle(name)).IsNil()
+    case *parser.VarDeclNode:
+        name = n.Name.Value
+        declared = s.scope.Declared(name)
+    }
+
+    if declared {
+        return &lexer.Error{Location: node.Loc(), Message: name + " has already been declared"}
+    }
+    return nil
+}
+
+func (s *Session) eval(decls, stmts []parser.Node, expr parser.Node) error {
+    s.inputs++
+    name := "-repl-" + strconv.Itoa(s.inputs)
+
+    nodes := append([]parser.Node{}, decls...)
+    if expr == nil {
+        nodes = append(nodes, wrapper(name, stmts))
+    }
+
+    saved := s.snapshot()
+    s.beginModule(&parser.AST{Nodes: nodes})
+    if err := s.generate(name, expr); err != nil {
+        s.module.Dispose()
+        s.restore(saved)
+        return err
+    }
+
+    s.engine.AddModule(s.module)
+    s.prev = s.module
+    s.engine.RunFunction(s.module.NamedFunction(name), []llvm.GenericValue{})
+    flushStdout()
+
+    return nil
+}
+
+func (s *Session) generate(name string, expr parser.Node) (err error) {
+    defer lexer.Recover(&err)
+
+    s.declareTopLevelNodes()
+    s.generateTopLevelNodes()
+    if expr != nil {
+        s.generatePrint(name, expr)
+    }
+
+    return llvm.VerifyModule(s.module, llvm.ReturnStatusAction)
+}
+
+func (s *Session) snapshot() snapshot {
+    saved := snapshot{
+        scope: s.scope,
+        variables: map[string]llvm.Value{},
+        templates: map[string]*Template{},
+    }
+
+    for name, v := range s.scope.variables {
+        saved.variables[name] = v
+    }
+    for name, t := range s.templates {
+        saved.templates[name] = t
+    }
+
+    return saved
+}
+
+func (s *Session) restore(saved snapshot) {
+    s.scope = saved.scope
+    s.scope.variables = saved.variables
+    s.templates = saved.templates
+    s.module = s.prev
+}
+
+// beginModule starts a new module that declares everything defined by
+// the previous one.
+func (s *Session) beginModule(tree *parser.AST) {
+    s.tree = tree
+    s.module = s.ctx.NewModule("main")
+    s.module.SetTarget(s.prev.Target())
+    s.module.SetDataLayout(s.prev.DataLayout())
+    s.functions = map[string]llvm.BasicBlock{}
+
+    for f := s.prev.FirstFunction(); !f.IsNil(); f = llvm.NextFunction(f) {
+        llvm.AddFunction(s.module, f.Name(), f.Type().ElementType())
+    }
+
+    for g := s.prev.FirstGlobal(); !g.IsNil(); g = llvm.NextGlobal(g) {
+        if g.Linkage() == llvm.PrivateLinkage {
+            continue
+        }
+
+        global := llvm.AddGlobal(s.module, g.Type().ElementType(), g.Name())
+        s.scope.AddVariable(g.Name(), global)
+    }
+}
+
+func (s *Session) generatePrint(name string, expr parser.Node) {
+    fn := llvm.AddFunction(s.module, name, llvm.FunctionType(s.ctx.VoidType(), nil, false))
+    block := s.ctx.AddBasicBlock(fn, "entry")
+    s.builder.SetInsertPointAtEnd(block)
+    s.currFunc = name
+
+    s.enterScope()
+    val := s.generateExpression(expr)
+    s.exitScope()
+    if val.IsNil() {
+        s.fail(expr, "Unsupported expression")
+    }
+
+    var args []llvm.Value
+    t := val.Type()
+    switch {
+    case t.TypeKind() == llvm.VoidTypeKind:
+    case t == s.primitives["int"]:
+        args = []llvm.Value{s.format("%d\n"), val}
+    case t == s.primitives["float"]:
+        args = []llvm.Value{s.format("%g\n"), s.builder.CreateFPExt(val, s.ctx.DoubleType(), "")}
+    case t == s.primitives["char"]:
+        args = []llvm.Value{s.format("'%c'\n"), val}
+    case t == s.primitives["boolean"]:
+        str := s.builder.CreateSelect(val, s.format("true"), s.format("false"), "")
+        args = []llvm.Value{s.format("%s\n"), str}
+    case t == llvm.PointerType(s.templates["string"].Type, 0):
+        args = []llvm.Value{s.format("\"%s\"\n"), s.unbox(val)}
+    case val == s.scope.GetValue("null"):
+        args = []llvm.Value{s.format("null\n")}
+    default:
+        args = []llvm.Value{s.format("<" + s.getStructFromPointer(t) + ">\n")}
+    }
+
+    if args != nil {
+        s.builder.CreateCall(s.module.NamedFunction("printf"), args, "")
+    }
+    s.builder.CreateRetVoid()
+}
+
+func (s *Session) format(str string) llvm.Value {
+    return s.builder.CreateGlobalStringPtr(str, "")
+}
@@ -9,6 +9,9 @@ import (
 
 var mangleFuncs map[string]int = map[string]int {
     "malloc": 0,
+    "strlen": 0,
+    "atoi": 0,
+    "atof": 0,
 }
 
 func (c *Codegen) mangle(name string) string {
@@ -20,6 +23,7 @@ func (c *Codegen) mangle(name string) string {
 }
 
 func (c *Codegen) injectStdLib() {
+    c.declareMalloc();
     c.declareMemcpy();
 
     c.defineConstants();
@@ -27,52 +31,82 @@ func (c *Codegen) injectStdLib() {
     c.stdString();
 }
 
+func (c *Codegen) declareMalloc() {
+    t := llvm.FunctionType(llvm.PointerType(c.primitives["char"], 0), []llvm.Type{
+        c.intPtrType,
+    }, false)
+    malloc := llvm.AddFunction(c.module, "malloc", t)
+
+    if c.IsWasm() {
+        c.defineBumpAllocator(malloc)
+    }
+}
+
+func (c *Codegen) createMalloc(t llvm.Type) llvm.Value {
+    mem := c.builder.CreateCall(c.module.NamedFunction("malloc"), []llvm.Value{c.sizeOf(t)}, "")
+    return c.builder.CreateBitCast(mem, llvm.PointerType(t, 0), "")
+}
+
+func (c *Codegen) libcFunction(name string, ret llvm.Type, params ...llvm.Type) llvm.Value {
+    if f := c.module.NamedFunction(name); !f.IsNil() {
+        return f
+    }
+
+    return llvm.AddFunction(c.module, name, llvm.FunctionType(ret, params, false))
+}
+
+// declareMemcpy declares llvm.memcpy(dst, src, len, volatile). Since LLVM 7
+// alignment is an attribute of the pointers, and chars need none.
 func (c *Codegen) declareMemcpy() {
-    t := llvm.FunctionType(llvm.VoidType(), []llvm.Type{
-        llvm.PointerType(PRIMITIVE_TYPES["char"], 0),
-        llvm.PointerType(PRIMITIVE_TYPES["char"], 0),
-        PRIMITIVE_TYPES["int"],
-        PRIMITIVE_TYPES["int"],
-        PRIMITIVE_TYPES["boolean"],
+    t := llvm.FunctionType(c.ctx.VoidType(), []llvm.Type{
+        llvm.PointerType(c.primitives["char"], 0),
+        llvm.PointerType(c.primitives["char"], 0),
+        c.intPtrType,
+        c.primitives["boolean"],
     }, false)
-    llvm.AddFunction(c.module, "llvm.memcpy.p0i8.p0i8.i32", t)
+    llvm.AddFunction(c.module, c.memcpyName(), t)
 }
 
 func (c *Codegen) defineConstants() {
-    nullVal := llvm.ConstPointerNull(llvm.PointerType(llvm.Int8Type(), 0))
+    nullVal := llvm.ConstPointerNull(llvm.PointerType(c.ctx.Int8Type(), 0))
     c.scope.AddVariable("null", nullVal)
 }
 
 func (c *Codegen) stdString() {
     tmpl := &Template{
-        Type: llvm.GlobalContext().StructCreateNamed("string"),
+        Type: c.ctx.StructCreateNamed("string"),
         Variables: map[string]int{},
     }
     c.templates["string"] = tmpl
 
     vars := []llvm.Type{
-        llvm.PointerType(PRIMITIVE_TYPES["char"], 0),
-        PRIMITIVE_TYPES["int"],
-        PRIMITIVE_TYPES["int"],
+        llvm.PointerType(c.primitives["char"], 0),
+        c.primitives["int"],
+        c.primitives["int"],
     }
     tmpl.Type.StructSetBody(vars, false)
 
-    lenFuncType := llvm.FunctionType(PRIMITIVE_TYPES["int"], []llvm.Type{llvm.PointerType(tmpl.Type, 0)}, false)
+    lenFuncType := llvm.FunctionType(c.primitives["int"], []llvm.Type{llvm.PointerType(tmpl.Type, 0)}, false)
     lenFunc := llvm.AddFunction(c.module, "-string-len", lenFuncType)
     lenFunc.Param(0).SetName("this")
-    block := llvm.AddBasicBlock(c.module.NamedFunction("-string-len"), "entry")
+    c.share(lenFunc)
+    block := c.ctx.AddBasicBlock(c.module.NamedFunction("-string-len"), "entry")
     c.functions["-string-len"] = block
     c.currFunc = "-string-len"
     c.builder.SetInsertPoint(block, block.LastInstruction())
     ret := c.builder.CreateStructGEP(c.getCurrParam("this"), 1, "")
     ret = c.builder.CreateLoad(ret, "")
-    ret = c.builder.CreateSub(ret, llvm.ConstInt(PRIMITIVE_TYPES["int"], 1, false), "")
+    ret = c.builder.CreateSub(ret, llvm.ConstInt(c.primitives["int"], 1, false), "")
     c.builder.CreateRet(ret)
 
-    printFuncType := llvm.FunctionType(PRIMITIVE_TYPES["int"], []llvm.Type{
-        llvm.PointerType(PRIMITIVE_TYPES["char"], 0),
+    printFuncType := llvm.FunctionType(c.primitives["int"], []llvm.Type{
+        llvm.PointerType(c.primitives["char"], 0),
     }, true)
-    llvm.AddFunction(c.module, "printf", printFuncType)
+    printf := llvm.AddFunction(c.module, "printf", printFuncType)
+
+    if c.IsWasm() {
+        c.importHostFunc(printf)
+    }
 }
 
 func (c *Codegen) generateStringLiteral(n *parser.StringLitNode) llvm.Value {
@@ -83,40 +117,54 @@ func (c *Codegen) generateStringLiteral(n *parser.StringLitNode) llvm.Value {
         vals = append(vals, c.generateExpression(char))
     }
     vals = append(vals, c.generateExpression(&parser.CharLitNode{Value: 0}))
-    arr := llvm.ConstArray(PRIMITIVE_TYPES["char"], vals)
-    chars := c.builder.CreateMalloc(arr.Type(), "")
+    arr := llvm.ConstArray(c.primitives["char"], vals)
+    chars := c.createMalloc(arr.Type())
     c.builder.CreateStore(arr, chars)
 
-    str := c.builder.CreateMalloc(c.templates["string"].Type, "")
-    chars = c.builder.CreateBitCast(chars, llvm.PointerType(PRIMITIVE_TYPES["char"], 0), "")
+    str := c.createMalloc(c.templates["string"].Type)
+    chars = c.builder.CreateBitCast(chars, llvm.PointerType(c.primitives["char"], 0), "")
+
+    c.builder.CreateStore(chars, c.builder.CreateStructGEP(str, 0, ""))
+    c.builder.CreateStore(llvm.ConstInt(c.primitives["int"], uint64(len(vals)), false), c.builder.CreateStructGEP(str, 1, ""))
+    c.builder.CreateStore(llvm.ConstInt(c.primitives["int"], uint64(len(vals)), false), c.builder.CreateStructGEP(str, 2, ""))
+
+    return str
+}
 
+func (c *Codegen) generateStringFromC(chars llvm.Value) llvm.Value {
+    strlen := c.libcFunction("strlen", c.intPtrType, llvm.PointerType(c.primitives["char"], 0))
+    length := c.builder.CreateCall(strlen, []llvm.Value{chars}, "")
+    length = c.builder.CreateIntCast(length, c.primitives["int"], "")
+    length = c.builder.CreateAdd(length, llvm.ConstInt(c.primitives["int"], 1, false), "")
+
+    str := c.createMalloc(c.templates["string"].Type)
     c.builder.CreateStore(chars, c.builder.CreateStructGEP(str, 0, ""))
-    c.builder.CreateStore(llvm.ConstInt(PRIMITIVE_TYPES["int"], uint64(len(vals)), false), c.builder.CreateStructGEP(str, 1, ""))
-    c.builder.CreateStore(llvm.ConstInt(PRIMITIVE_TYPES["int"], uint64(len(vals)), false), c.builder.CreateStructGEP(str, 2, ""))
+    c.builder.CreateStore(length, c.builder.CreateStructGEP(str, 1, ""))
+    c.builder.CreateStore(length, c.builder.CreateStructGEP(str, 2, ""))
 
     return str
 }
 
 func (c *Codegen) generateStringConcat(str1, str2 llvm.Value) llvm.Value {
-//    one := llvm.ConstInt(PRIMITIVE_TYPES["int"], 1, false)
+//    one := llvm.ConstInt(c.primitives["int"], 1, false)
 
     len1      := c.builder.CreateCall(c.module.NamedFunction("-string-len"), []llvm.Value{str1}, "")
     len2      := c.builder.CreateLoad(c.builder.CreateStructGEP(str2, 1, ""), "")
     len_sum   := c.builder.CreateAdd(len1, len2, "")
 
-    chars := c.builder.CreateCall(c.module.NamedFunction("malloc"), []llvm.Value{len_sum}, "")
-    c.builder.CreateCall(c.module.NamedFunction("llvm.memcpy.p0i8.p0i8.i32"), []llvm.Value{
-        chars, c.unbox(str1), len1,
-        llvm.ConstInt(PRIMITIVE_TYPES["int"], 0, false),
-        llvm.ConstInt(PRIMITIVE_TYPES["boolean"], 0, false),
+    chars := c.builder.CreateCall(c.module.NamedFunction("malloc"), []llvm.Value{
+        c.builder.CreateIntCast(len_sum, c.intPtrType, ""),
+    }, "")
+    c.builder.CreateCall(c.module.NamedFunction(c.memcpyName()), []llvm.Value{
+        chars, c.unbox(str1), c.builder.CreateIntCast(len1, c.intPtrType, ""),
+        llvm.ConstInt(c.primitives["boolean"], 0, false),
     }, "")
-    c.builder.CreateCall(c.module.NamedFunction("llvm.memcpy.p0i8.p0i8.i32"), []llvm.Value{
-        c.builder.CreateGEP(chars, []llvm.Value{len1}, ""), c.unbox(str2), len2,
-        llvm.ConstInt(PRIMITIVE_TYPES["int"], 0, false),
-        llvm.ConstInt(PRIMITIVE_TYPES["boolean"], 0, false),
+    c.builder.CreateCall(c.module.NamedFunction(c.memcpyName()), []llvm.Value{
+        c.builder.CreateGEP(chars, []llvm.Value{len1}, ""), c.unbox(str2), c.builder.CreateIntCast(len2, c.intPtrType, ""),
+        llvm.ConstInt(c.primitives["boolean"], 0, false),
     }, "")
 
-    str := c.builder.CreateMalloc(c.templates["string"].Type, "")
+    str := c.createMalloc(c.templates["string"].Type)
     c.builder.CreateStore(chars, c.builder.CreateStructGEP(str, 0, ""))
     c.builder.CreateStore(len_sum, c.builder.CreateStructGEP(str, 1, ""))
     c.builder.CreateStore(len_sum, c.builder.CreateStructGEP(str, 2, ""))
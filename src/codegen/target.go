@@ -0,0 +1,87 @@
+package codegen
+
+import (
+    "strconv"
+    "strings"
+    "sync"
+
+    "llvm.org/llvm/bindings/go/llvm"
+)
+
+var initTargets sync.Once
+
+var codeGenLevels = []llvm.CodeGenOptLevel{
+    llvm.CodeGenLevelNone,
+    llvm.CodeGenLevelLess,
+    llvm.CodeGenLevelDefault,
+    llvm.CodeGenLevelAggressive,
+}
+
+func (c *Codegen) configureTarget() error {
+    initTargets.Do(func() {
+        llvm.InitializeAllTargetInfos()
+        llvm.InitializeAllTargets()
+        llvm.InitializeAllTargetMCs()
+        llvm.InitializeAllAsmParsers()
+        llvm.InitializeAllAsmPrinters()
+    })
+
+    triple := c.options.Triple
+    if triple == "" {
+        triple = llvm.DefaultTargetTriple()
+    }
+
+    target, err := llvm.GetTargetFromTriple(triple)
+    if err != nil {
+        return err
+    }
+
+    features := c.options.Features
+    if strings.HasPrefix(triple, "wasm") && features == "" {
+        // lets llvm.memcpy lower to memory.copy instead of a libc call
+        features = "+bulk-memory"
+    }
+
+    c.machine = target.CreateTargetMachine(triple, c.options.CPU, features,
+        codeGenLevels[c.options.OptLevel], llvm.RelocPIC, llvm.CodeModelDefault)
+    c.targetData = c.machine.CreateTargetData()
+    c.intPtrType = c.ctx.IntType(c.targetData.PointerSize() * 8)
+
+    c.module.SetTarget(triple)
+    c.module.SetDataLayout(c.targetData.String())
+
+    return nil
+}
+
+func (c *Codegen) sizeOf(t llvm.Type) llvm.Value {
+    return llvm.ConstInt(c.intPtrType, c.targetData.TypeAllocSize(t), false)
+}
+
+func (c *Codegen) memcpyName() string {
+    return "llvm.memcpy.p0i8.p0i8.i" + strconv.Itoa(c.intPtrType.IntTypeWidth())
+}
+
+func (c *Codegen) Emit(fileType llvm.CodeGenFileType) ([]byte, error) {
+    buf, err := c.machine.EmitToMemoryBuffer(c.module, fileType)
+    if err != nil {
+        return nil, err
+    }
+    defer buf.Dispose()
+
+    return append([]byte{}, buf.Bytes()...), nil
+}
+
+func (c *Codegen) EmitObject() ([]byte, error) {
+    return c.Emit(llvm.ObjectFile)
+}
+
+func (c *Codegen) EmitAssembly() ([]byte, error) {
+    return c.Emit(llvm.AssemblyFile)
+}
+
+func (c *Codegen) EmitBitcode() []byte {
+    buf := llvm.WriteBitcodeToMemoryBuffer(c.module)
+    defer buf.Dispose()
+
+    return append([]byte{}, buf.Bytes()...)
+}
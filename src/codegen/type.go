@@ -1,21 +1,21 @@
 package codegen
 
 import (
-    "log"
-
     "llvm.org/llvm/bindings/go/llvm"
     "github.com/k3v/lyca/src/parser"
 )
 
-var PRIMITIVE_TYPES = map[string]llvm.Type {
-    "int": llvm.Int32Type(), "char": llvm.Int8Type(), "float": llvm.FloatType(), "boolean": llvm.Int1Type(),
+func primitiveTypes(ctx llvm.Context) map[string]llvm.Type {
+    return map[string]llvm.Type {
+        "int": ctx.Int32Type(), "char": ctx.Int8Type(), "float": ctx.FloatType(), "boolean": ctx.Int1Type(),
+    }
 }
 
 var null llvm.Value = llvm.Value{}
 
 func (c *Codegen) getLLVMFuncType(ret parser.Node, params []*parser.VarDeclNode, obj llvm.Type) llvm.Type {
     p := make([]llvm.Type, 0)
-    if obj != llvm.VoidType() {
+    if obj != c.ctx.VoidType() {
         p = append(p, obj)
     }
 
@@ -34,31 +34,33 @@ func (c *Codegen) getLLVMType(node parser.Node) llvm.Type {
     */
     case *parser.NamedTypeNode:
         name := t.Name.Value
-        if prim, ok := PRIMITIVE_TYPES[name]; ok {
+        if prim, ok := c.primitives[name]; ok {
             return prim
         }
 
         if t, ok := c.templates[name]; ok {
             return llvm.PointerType(t.Type, 0)
         }
+
+        c.fail(t, "Undefined type " + name)
     case *parser.BinaryExprNode:
         return c.getLLVMType(t.Left)
     case *parser.CharLitNode:
-        return PRIMITIVE_TYPES["char"]
+        return c.primitives["char"]
     case *parser.BoolLitNode:
-        return PRIMITIVE_TYPES["boolean"]
+        return c.primitives["boolean"]
     case *parser.NumLitNode:
         if t.IsFloat {
-            return PRIMITIVE_TYPES["float"]
+            return c.primitives["float"]
         } else {
-            return PRIMITIVE_TYPES["int"]
+            return c.primitives["int"]
         }
     case *parser.StringLitNode:
         return llvm.PointerType(c.templates["string"].Type, 0)
     case *parser.VarAccessNode:
         if param := c.getCurrParam(t.Name.Value); !param.IsNil() {
             return param.Type()
-        } else if t := c.scope.GetValue(t.Name.Value).Type(); t != llvm.VoidType() {
+        } else if t := c.scope.GetValue(t.Name.Value).Type(); t != c.ctx.VoidType() {
             return t
         }
     case *parser.ObjectAccessNode:
@@ -70,7 +72,7 @@ func (c *Codegen) getLLVMType(node parser.Node) llvm.Type {
         return c.getLLVMTypeOfCall(t)
     }
 
-    return llvm.VoidType()
+    return c.ctx.VoidType()
 }
 
 func (c *Codegen) getLLVMTypeOfCall(node *parser.CallExprNode) llvm.Type {
@@ -83,7 +85,7 @@ func (c *Codegen) getLLVMTypeOfCall(node *parser.CallExprNode) llvm.Type {
             return c.module.NamedFunction("-" + tmpl + "-" + t.Member.Value).Type().ElementType().ReturnType()
     }
 
-    return llvm.VoidType()
+    return c.ctx.VoidType()
 }
 
 func (c *Codegen) getStructFromPointer(t llvm.Type) string {
@@ -104,13 +106,12 @@ func (c *Codegen) convert(val llvm.Value, t llvm.Type) llvm.Value {
     }
 
     if val == c.scope.GetValue("null") {
-        log.Println("Null conversion")
         return llvm.ConstPointerNull(t)
     }
 
     switch val.Type() {
-    case PRIMITIVE_TYPES["int"]:
-        if t == PRIMITIVE_TYPES["float"] {
+    case c.primitives["int"]:
+        if t == c.primitives["float"] {
             return c.builder.CreateSIToFP(val, t, "")
         }
     }
@@ -118,6 +119,20 @@ func (c *Codegen) convert(val llvm.Value, t llvm.Type) llvm.Value {
     return val
 }
 
+// promote applies C's default argument promotions to a variadic argument
+func (c *Codegen) promote(val llvm.Value) llvm.Value {
+    switch val.Type() {
+    case c.primitives["float"]:
+        return c.builder.CreateFPExt(val, c.ctx.DoubleType(), "")
+    case c.primitives["boolean"]:
+        return c.builder.CreateZExt(val, c.primitives["int"], "")
+    case c.primitives["char"]:
+        return c.builder.CreateSExt(val, c.primitives["int"], "")
+    }
+
+    return val
+}
+
 func (c *Codegen) unbox(val llvm.Value) llvm.Value {
     t := c.getUnderlyingType(val.Type())
     switch t {
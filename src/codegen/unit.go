@@ -0,0 +1,150 @@
+package codegen
+
+import (
+    "sync"
+
+    "llvm.org/llvm/bindings/go/llvm"
+    "github.com/k3v/lyca/src/parser"
+)
+
+// GenerateUnits is Generate for a tree split into units, disjoint sets of
+// its top level nodes such as the declarations of one file. Each unit is
+// generated into a module of its own in a separate context, up to jobs at
+// a time, and the modules are then linked into c's module in unit order.
+// Every unit declares the whole tree, so the output does not depend on
+// scheduling or on jobs.
+func (c *Codegen) GenerateUnits(units [][]parser.Node, jobs int) (string, error) {
+    if len(units) < 2 {
+        return c.Generate()
+    }
+    if jobs < 1 {
+        jobs = 1
+    }
+
+    bufs := make([]llvm.MemoryBuffer, len(units))
+    errs := make([]error, len(units))
+
+    next := make(chan int)
+    var wg sync.WaitGroup
+    for w := 0; w < jobs && w < len(units); w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range next {
+                bufs[i], errs[i] = c.generateUnit(units[i])
+            }
+        }()
+    }
+    for i := range units {
+        next <- i
+    }
+    close(next)
+    wg.Wait()
+
+    for _, err := range errs {
+        if err == nil {
+            continue
+        }
+
+        for _, buf := range bufs {
+            if buf != (llvm.MemoryBuffer{}) {
+                buf.Dispose()
+            }
+        }
+        return "", err
+    }
+
+    if err := c.link(bufs); err != nil {
+        return "", err
+    }
+
+    if err := llvm.VerifyModule(c.module, llvm.ReturnStatusAction); err != nil {
+        return "", err
+    }
+    c.optimize()
+
+    return c.module.String(), nil
+}
+
+// EmitUnit generates one unit of the tree on its own, optimizes it and
+// returns it as an object file, for builds that link units separately.
+// It may be called from several goroutines at once.
+func (c *Codegen) EmitUnit(nodes []parser.Node) ([]byte, error) {
+    u, err := c.constructUnit(nodes)
+    if err != nil {
+        return nil, err
+    }
+    defer u.Dispose()
+
+    if err := u.build(); err != nil {
+        return nil, err
+    }
+
+    if err := llvm.VerifyModule(u.module, llvm.ReturnStatusAction); err != nil {
+        return nil, err
+    }
+    u.optimize()
+
+    return u.EmitObject()
+}
+
+// generateUnit builds one unit and returns it as bitcode, which is the
+// only way to move a module between contexts.
+func (c *Codegen) generateUnit(nodes []parser.Node) (llvm.MemoryBuffer, error) {
+    u, err := c.constructUnit(nodes)
+    if err != nil {
+        return llvm.MemoryBuffer{}, err
+    }
+    defer u.Dispose()
+
+    if err := u.build(); err != nil {
+        return llvm.MemoryBuffer{}, err
+    }
+
+    return llvm.WriteBitcodeToMemoryBuffer(u.module), nil
+}
+
+func (c *Codegen) constructUnit(nodes []parser.Node) (*Codegen, error) {
+    u, err := Construct(c.tree, c.options)
+    if err != nil {
+        return nil, err
+    }
+
+    u.unit = map[parser.Node]bool{}
+    for _, node := range nodes {
+        u.unit[node] = true
+    }
+    return u, nil
+}
+
+// link parses every unit into c's context and links them into c's
+// module. Parsing takes ownership of the buffers and linking destroys
+// the unit modules.
+func (c *Codegen) link(bufs []llvm.MemoryBuffer) error {
+    for i, buf := range bufs {
+        m, err := c.ctx.ParseIR(buf)
+        if err != nil {
+            for _, rest := range bufs[i+1:] {
+                rest.Dispose()
+            }
+            return err
+        }
+
+        if err := llvm.LinkModules(c.module, m); err != nil {
+            for _, rest := range bufs[i+1:] {
+                rest.Dispose()
+            }
+            return err
+        }
+    }
+
+    return nil
+}
+
+// share marks a standard library definition that every unit emits, so
+// the linker keeps just one of them.
+func (c *Codegen) share(v llvm.Value) {
+    if c.unit != nil {
+        v.SetLinkage(llvm.LinkOnceODRLinkage)
+    }
+}
@@ -0,0 +1,77 @@
+package codegen
+
+import (
+    "strings"
+
+    "llvm.org/llvm/bindings/go/llvm"
+)
+
+const (
+    WASM_PAGE_SIZE   = 65536
+    WASM_HOST_MODULE = "env"
+)
+
+// IsWasm reports whether the module is being compiled for WebAssembly.
+// There is no libc there: printf is imported from the host and malloc
+// is a bump allocator over linear memory.
+func (c *Codegen) IsWasm() bool {
+    return strings.HasPrefix(c.module.Target(), "wasm")
+}
+
+func (c *Codegen) exportFunc(name string) {
+    f := c.module.NamedFunction(c.mangle(name))
+    f.AddTargetDependentFunctionAttr("wasm-export-name", name)
+}
+
+func (c *Codegen) importHostFunc(f llvm.Value) {
+    f.AddTargetDependentFunctionAttr("wasm-import-module", WASM_HOST_MODULE)
+    f.AddTargetDependentFunctionAttr("wasm-import-name", f.Name())
+}
+
+func (c *Codegen) defineBumpAllocator(malloc llvm.Value) {
+    i32 := c.intPtrType
+    constant := func(v uint64) llvm.Value {
+        return llvm.ConstInt(i32, v, false)
+    }
+
+    heapBase := llvm.AddGlobal(c.module, c.ctx.Int8Type(), "__heap_base")
+    next := llvm.AddGlobal(c.module, i32, "-heap-next")
+    next.SetLinkage(llvm.InternalLinkage)
+    next.SetInitializer(constant(0))
+    c.share(malloc)
+    c.share(next)
+
+    memorySize := c.libcFunction("llvm.wasm.memory.size.i32", i32, i32)
+    memoryGrow := c.libcFunction("llvm.wasm.memory.grow.i32", i32, i32, i32)
+
+    entry := c.ctx.AddBasicBlock(malloc, "entry")
+    grow := c.ctx.AddBasicBlock(malloc, "grow")
+    oom := c.ctx.AddBasicBlock(malloc, "oom")
+    done := c.ctx.AddBasicBlock(malloc, "done")
+
+    c.builder.SetInsertPointAtEnd(entry)
+    start := c.builder.CreateLoad(next, "")
+    first := c.builder.CreateICmp(llvm.IntEQ, start, constant(0), "")
+    start = c.builder.CreateSelect(first, llvm.ConstPtrToInt(heapBase, i32), start, "")
+    start = c.builder.CreateAdd(start, constant(7), "")
+    start = c.builder.CreateAnd(start, llvm.ConstNot(constant(7)), "")
+    end := c.builder.CreateAdd(start, malloc.Param(0), "")
+    c.builder.CreateStore(end, next)
+
+    pages := c.builder.CreateCall(memorySize, []llvm.Value{constant(0)}, "")
+    limit := c.builder.CreateMul(pages, constant(WASM_PAGE_SIZE), "")
+    c.builder.CreateCondBr(c.builder.CreateICmp(llvm.IntUGT, end, limit, ""), grow, done)
+
+    c.builder.SetInsertPointAtEnd(grow)
+    missing := c.builder.CreateAdd(c.builder.CreateSub(end, limit, ""), constant(WASM_PAGE_SIZE - 1), "")
+    missing = c.builder.CreateUDiv(missing, constant(WASM_PAGE_SIZE), "")
+    prev := c.builder.CreateCall(memoryGrow, []llvm.Value{constant(0), missing}, "")
+    failed := c.builder.CreateICmp(llvm.IntEQ, prev, llvm.ConstAllOnes(i32), "")
+    c.builder.CreateCondBr(failed, oom, done)
+
+    c.builder.SetInsertPointAtEnd(oom)
+    c.builder.CreateUnreachable()
+
+    c.builder.SetInsertPointAtEnd(done)
+    c.builder.CreateRet(c.builder.CreateIntToPtr(start, malloc.Type().ElementType().ReturnType(), ""))
+}
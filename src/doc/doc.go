@@ -0,0 +1,194 @@
+// Package doc renders the documentation of a Lyca package, the doc
+// comments of its declarations together with their signatures, as
+// Markdown or HTML.
+package doc
+
+import (
+    htmltemplate "html/template"
+    "io"
+    "sort"
+    "strings"
+    "text/template"
+
+    "github.com/k3v/lyca/src/format"
+    "github.com/k3v/lyca/src/parser"
+)
+
+// Decl is one documented declaration. Signature holds it as the
+// formatter prints it, without a body.
+type Decl struct {
+    Name      string
+    Signature string
+    Doc       string
+}
+
+type Template struct {
+    Decl
+    Fields      []Decl
+    Constructor *Decl
+    Methods     []Decl
+}
+
+type Package struct {
+    Name      string
+    Globals   []Decl
+    Functions []Decl
+    Templates []*Template
+}
+
+// New collects the declarations of tree. Top level declarations are
+// sorted by name, template members keep their source order.
+func New(name string, tree *parser.AST) *Package {
+    pkg := &Package{Name: name}
+    for _, node := range tree.Nodes {
+        switch n := node.(type) {
+        case *parser.VarDeclNode:
+            pkg.Globals = append(pkg.Globals, decl(n, n.Name.Value, n.Doc))
+        case *parser.FuncDeclNode:
+            pkg.Functions = append(pkg.Functions, funcDecl(n))
+        case *parser.TemplateNode:
+            pkg.Templates = append(pkg.Templates, templateDecl(n))
+        }
+    }
+
+    sortDecls(pkg.Globals)
+    sortDecls(pkg.Functions)
+    sort.SliceStable(pkg.Templates, func(i, j int) bool {
+        return pkg.Templates[i].Name < pkg.Templates[j].Name
+    })
+
+    return pkg
+}
+
+func decl(node parser.Node, name, doc string) Decl {
+    return Decl{Name: name, Signature: format.Declaration(node), Doc: doc}
+}
+
+func funcDecl(n *parser.FuncDeclNode) Decl {
+    return decl(n, n.Function.Signature.Name.Value, n.Doc)
+}
+
+func templateDecl(n *parser.TemplateNode) *Template {
+    tmpl := &Template{Decl: decl(n, n.Name.Value, n.Doc)}
+    for _, v := range n.Variables {
+        tmpl.Fields = append(tmpl.Fields, decl(v, v.Name.Value, v.Doc))
+    }
+    if n.Constructor != nil {
+        ctor := decl(n.Constructor, "constructor", n.Constructor.Doc)
+        tmpl.Constructor = &ctor
+    }
+    for _, meth := range n.Methods {
+        tmpl.Methods = append(tmpl.Methods, funcDecl(meth))
+    }
+
+    return tmpl
+}
+
+func sortDecls(decls []Decl) {
+    sort.SliceStable(decls, func(i, j int) bool {
+        return decls[i].Name < decls[j].Name
+    })
+}
+
+// paragraphs splits a doc comment at its blank lines.
+func paragraphs(doc string) []string {
+    var res []string
+    for _, para := range strings.Split(doc, "\n\n") {
+        if para = strings.TrimSpace(para); para != "" {
+            res = append(res, para)
+        }
+    }
+
+    return res
+}
+
+// MARKDOWN_ESCAPE backslash escapes the characters that would turn the
+// text of a doc comment into Markdown markup or raw HTML.
+var MARKDOWN_ESCAPE = strings.NewReplacer(
+    `\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+    `<`, `\<`, `>`, `\>`, `&`, `\&`, `#`, `\#`,
+)
+
+var FUNCS = map[string]interface{}{
+    "paragraphs": paragraphs,
+    "markdown": MARKDOWN_ESCAPE.Replace,
+}
+
+var markdown = template.Must(template.New("markdown").Funcs(FUNCS).Parse(MARKDOWN))
+
+var html = htmltemplate.Must(htmltemplate.New("html").Funcs(FUNCS).Parse(HTML))
+
+// Markdown renders p with doc comments escaped to read as written, like
+// HTML does.
+func (p *Package) Markdown(w io.Writer) error {
+    return markdown.Execute(w, p)
+}
+
+func (p *Package) HTML(w io.Writer) error {
+    return html.Execute(w, p)
+}
+
+const MARKDOWN = `{{define "decl"}}
+` + "```lyca" + `
+{{.Signature}}
+` + "```" + `
+{{range paragraphs .Doc}}
+{{markdown .}}
+{{end}}{{end -}}
+
+# {{.Name}}
+{{if .Globals}}
+## Variables
+{{range .Globals}}{{template "decl" .}}{{end}}{{end}}
+{{- if .Functions}}
+## Functions
+{{range .Functions}}
+### {{.Name}}
+{{template "decl" .}}{{end}}{{end}}
+{{- if .Templates}}
+## Templates
+{{range .Templates}}
+### {{.Name}}
+{{template "decl" .}}
+{{- if .Fields}}
+#### Fields
+{{range .Fields}}{{template "decl" .}}{{end}}{{end}}
+{{- with .Constructor}}
+#### Constructor
+{{template "decl" .}}{{end}}
+{{- if .Methods}}
+#### Methods
+{{range .Methods}}
+##### {{.Name}}
+{{template "decl" .}}{{end}}{{end}}{{end}}{{end -}}
+`
+
+const HTML = `{{define "decl"}}<pre><code>{{.Signature}}</code></pre>
+{{range paragraphs .Doc}}<p>{{.}}</p>
+{{end}}{{end -}}
+<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+{{if .Globals}}<h2 id="variables">Variables</h2>
+{{range .Globals}}{{template "decl" .}}{{end}}{{end -}}
+{{if .Functions}}<h2 id="functions">Functions</h2>
+{{range .Functions}}<h3 id="{{.Name}}">{{.Name}}</h3>
+{{template "decl" .}}{{end}}{{end -}}
+{{if .Templates}}<h2 id="templates">Templates</h2>
+{{range .Templates}}{{$tmpl := .Name}}<h3 id="{{.Name}}">{{.Name}}</h3>
+{{template "decl" .}}
+{{- if .Fields}}<h4>Fields</h4>
+{{range .Fields}}{{template "decl" .}}{{end}}{{end -}}
+{{with .Constructor}}<h4>Constructor</h4>
+{{template "decl" .}}{{end -}}
+{{if .Methods}}<h4>Methods</h4>
+{{range .Methods}}<h5 id="{{$tmpl}}.{{.Name}}">{{.Name}}</h5>
+{{template "decl" .}}{{end}}{{end}}{{end}}{{end -}}
+</body>
+</html>
+`
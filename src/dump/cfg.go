@@ -0,0 +1,122 @@
+// +build !nollvm
+
+package dump
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "regexp"
+    "strings"
+
+    "llvm.org/llvm/bindings/go/llvm"
+)
+
+// SOURCE_LINE matches the names the compiler gives blocks after a source
+// line, such as L12.if.then.
+var SOURCE_LINE = regexp.MustCompile(`^L(\d+)\.(.+)$`)
+
+// CFG writes a Graphviz graph of the control flow of every function
+// defined in m. Basic blocks are nodes listing their instructions, edges
+// lead to the successors of their terminators, labelled true and false
+// for a conditional branch and with the case value for a switch. Blocks
+// the compiler named after a source line are labelled with that line.
+func CFG(w io.Writer, m llvm.Module) error {
+    var b bytes.Buffer
+    for fn := m.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+        if fn.IsDeclaration() {
+            continue
+        }
+
+        names := map[llvm.BasicBlock]string{}
+        var blocks []llvm.BasicBlock
+        for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+            names[bb] = blockName(bb, len(blocks))
+            blocks = append(blocks, bb)
+        }
+
+        fmt.Fprintf(&b, "digraph %s {\n", quote(fn.Name()))
+        b.WriteString("    node [shape=box, fontname=\"monospace\"];\n")
+        for _, bb := range blocks {
+            lines := []string{title(names[bb])}
+            for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+                for _, line := range strings.Split(inst.String(), "\n") {
+                    lines = append(lines, strings.TrimSpace(line))
+                }
+            }
+            fmt.Fprintf(&b, "    %s [label=\"%s\"];\n", quote(names[bb]), label(lines))
+        }
+
+        for _, bb := range blocks {
+            term := bb.LastInstruction()
+            if term.IsNil() {
+                continue
+            }
+            for _, e := range successors(term) {
+                fmt.Fprintf(&b, "    %s -> %s", quote(names[bb]), quote(names[e.to]))
+                if e.label != "" {
+                    fmt.Fprintf(&b, " [label=%s]", quote(e.label))
+                }
+                b.WriteString(";\n")
+            }
+        }
+        b.WriteString("}\n")
+    }
+
+    _, err := w.Write(b.Bytes())
+    return err
+}
+
+type edge struct {
+    to    llvm.BasicBlock
+    label string
+}
+
+// successors lists the blocks term may branch to. A conditional br holds
+// its condition, the false block and then the true block; a switch holds
+// its value and default block followed by a value and block per case.
+func successors(term llvm.Value) []edge {
+    n := term.OperandsCount()
+    switch {
+    case term.InstructionOpcode() == llvm.Br && n == 3:
+        return []edge{
+            {term.Operand(2).AsBasicBlock(), "true"},
+            {term.Operand(1).AsBasicBlock(), "false"},
+        }
+    case term.InstructionOpcode() == llvm.Switch:
+        edges := []edge{{term.Operand(1).AsBasicBlock(), "default"}}
+        for i := 2; i + 1 < n; i += 2 {
+            value := strings.Fields(term.Operand(i).String())
+            edges = append(edges, edge{term.Operand(i + 1).AsBasicBlock(), value[len(value) - 1]})
+        }
+        return edges
+    }
+
+    var edges []edge
+    for i := 0; i < n; i++ {
+        if op := term.Operand(i); op.IsBasicBlock() {
+            edges = append(edges, edge{op.AsBasicBlock(), ""})
+        }
+    }
+    return edges
+}
+
+// blockName is the name of bb, the i-th block of its function. Unnamed
+// blocks are called entry, if first, or by their position.
+func blockName(bb llvm.BasicBlock, i int) string {
+    if name := bb.AsValue().Name(); name != "" {
+        return name
+    }
+    if i == 0 {
+        return "entry"
+    }
+    return fmt.Sprintf("block%d", i)
+}
+
+// title is a block name with its source line, if it has one.
+func title(name string) string {
+    if m := SOURCE_LINE.FindStringSubmatch(name); m != nil {
+        return m[2] + ", line " + m[1]
+    }
+    return name
+}
@@ -0,0 +1,149 @@
+// +build !nollvm
+
+package dump
+
+import (
+    "bytes"
+    "io/ioutil"
+    "os"
+    "strings"
+    "testing"
+
+    "llvm.org/llvm/bindings/go/llvm"
+)
+
+// module parses ir in ctx.
+func module(t *testing.T, ctx llvm.Context, ir string) llvm.Module {
+    f, err := ioutil.TempFile("", "lyca-cfg-*.ll")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.Remove(f.Name())
+    if _, err := f.WriteString(ir); err != nil {
+        t.Fatal(err)
+    }
+    if err := f.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    buf, err := llvm.NewMemoryBufferFromFile(f.Name())
+    if err != nil {
+        t.Fatal(err)
+    }
+    m, err := ctx.ParseIR(buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return m
+}
+
+func TestCFG(t *testing.T) {
+    tests := []struct {
+        name  string
+        ir    string
+        edges []string
+    }{
+        {"branch", `
+define void @"go \"to\""() {
+entry:
+  br label %"L3.if.end"
+"L3.if.end":
+  ret void
+}`, []string{
+            `"entry" -> "L3.if.end";`,
+        }},
+        {"conditional branch", `
+declare void @f()
+
+define i32 @max(i32 %a, i32 %b) {
+  %c = icmp sgt i32 %a, %b
+  br i1 %c, label %L2.if.then, label %L2.if.end
+L2.if.then:
+  call void @f()
+  ret i32 %a
+L2.if.end:
+  ret i32 %b
+}`, []string{
+            `"entry" -> "L2.if.then" [label="true"];`,
+            `"entry" -> "L2.if.end" [label="false"];`,
+        }},
+        {"switch", `
+define i32 @pick(i32 %x) {
+entry:
+  switch i32 %x, label %other [
+    i32 1, label %one
+    i32 -2, label %two
+  ]
+one:
+  ret i32 10
+two:
+  br label %other
+other:
+  ret i32 0
+}`, []string{
+            `"entry" -> "other" [label="default"];`,
+            `"entry" -> "one" [label="1"];`,
+            `"entry" -> "two" [label="-2"];`,
+            `"two" -> "other";`,
+        }},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            ctx := llvm.NewContext()
+            defer ctx.Dispose()
+            m := module(t, ctx, test.ir)
+            defer m.Dispose()
+
+            var out bytes.Buffer
+            if err := CFG(&out, m); err != nil {
+                t.Fatal(err)
+            }
+
+            var edges []string
+            for _, line := range strings.Split(out.String(), "\n") {
+                if strings.Contains(line, " -> ") {
+                    edges = append(edges, strings.TrimSpace(line))
+                }
+            }
+            if strings.Join(edges, "\n") != strings.Join(test.edges, "\n") {
+                t.Errorf("got edges\n%s\nwant\n%s\nin\n%s", strings.Join(edges, "\n"), strings.Join(test.edges, "\n"), out.String())
+            }
+            if strings.Count(out.String(), "digraph ") != 1 {
+                t.Errorf("got\n%s\nwant a graph of the one definition", out.String())
+            }
+        })
+    }
+}
+
+// Names and instructions are escaped the way DOT quotes strings, and a
+// switch is listed over the lines LLVM prints it on.
+func TestCFGLabels(t *testing.T) {
+    ctx := llvm.NewContext()
+    defer ctx.Dispose()
+    m := module(t, ctx, `
+define i32 @"a\5Cb"(i32 %x) {
+entry:
+  switch i32 %x, label %"L7.if.end" [
+    i32 1, label %"L7.if.end"
+  ]
+"L7.if.end":
+  ret i32 0
+}`)
+    defer m.Dispose()
+
+    var out bytes.Buffer
+    if err := CFG(&out, m); err != nil {
+        t.Fatal(err)
+    }
+
+    for _, want := range []string{
+        `digraph "a\\b" {`,
+        `"entry" [label="entry\lswitch i32 %x, label %\"L7.if.end\" [\li32 1, label %\"L7.if.end\"\l]\l"];`,
+        `"L7.if.end" [label="if.end, line 7\lret i32 0\l"];`,
+    } {
+        if !strings.Contains(out.String(), want) {
+            t.Errorf("got\n%s\nwant a line\n%s", out.String(), want)
+        }
+    }
+}
@@ -0,0 +1,144 @@
+package dump
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "strings"
+)
+
+// WriteDOT writes v as a Graphviz graph. Every object that is not plain
+// becomes a node labelled with its kind and its plain fields, with an
+// edge to each object it holds named after the field, and the index for
+// lists.
+func WriteDOT(w io.Writer, v interface{}) error {
+    g := &graph{}
+    g.b.WriteString("digraph {\n")
+    g.b.WriteString("    node [shape=box, fontname=\"monospace\"];\n")
+    g.value(v, "", "")
+    g.b.WriteString("}\n")
+
+    _, err := w.Write(g.b.Bytes())
+    return err
+}
+
+type graph struct {
+    b    bytes.Buffer
+    next int
+}
+
+func (g *graph) value(v interface{}, parent, edge string) {
+    switch v := v.(type) {
+    case *Object:
+        if !plain(v) {
+            g.object(v, parent, edge)
+        }
+    case []interface{}:
+        for i, elem := range v {
+            name := fmt.Sprintf("%s[%d]", edge, i)
+            if edge == "" {
+                name = ""
+            }
+            g.value(elem, parent, name)
+        }
+    }
+}
+
+func (g *graph) object(o *Object, parent, edge string) {
+    id := fmt.Sprintf("n%d", g.next)
+    g.next++
+
+    var lines []string
+    if o.Kind != "" {
+        lines = append(lines, o.Kind)
+    }
+    for _, f := range o.Fields {
+        if text, ok := inline(f.Value); ok {
+            lines = append(lines, f.Name + ": " + text)
+        }
+    }
+
+    fmt.Fprintf(&g.b, "    %s [label=\"%s\"];\n", id, label(lines))
+    if parent != "" {
+        fmt.Fprintf(&g.b, "    %s -> %s [label=%s];\n", parent, id, quote(edge))
+    }
+
+    for _, f := range o.Fields {
+        if _, ok := inline(f.Value); !ok {
+            g.value(f.Value, id, f.Name)
+        }
+    }
+}
+
+// inline renders a plain value, or a list of them, on one line. Spans
+// print as line:offset-line:offset.
+func inline(v interface{}) (string, bool) {
+    switch v := v.(type) {
+    case *Object:
+        if !plain(v) {
+            return "", false
+        }
+
+        if start, end := v.field("start"), v.field("end"); start != nil && end != nil {
+            return lineOffset(start) + "-" + lineOffset(end), true
+        }
+        var parts []string
+        for _, f := range v.Fields {
+            text, _ := inline(f.Value)
+            parts = append(parts, f.Name + ": " + text)
+        }
+        return "{" + strings.Join(parts, ", ") + "}", true
+    case []interface{}:
+        var parts []string
+        for _, elem := range v {
+            text, ok := inline(elem)
+            if !ok {
+                return "", false
+            }
+            parts = append(parts, text)
+        }
+        return "[" + strings.Join(parts, ", ") + "]", true
+    case nil:
+        return "nil", true
+    case string:
+        return fmt.Sprintf("%q", v), true
+    }
+
+    return fmt.Sprint(v), true
+}
+
+func lineOffset(pos interface{}) string {
+    obj, ok := pos.(*Object)
+    if !ok {
+        return "?"
+    }
+    return fmt.Sprintf("%v:%v", obj.field("line"), obj.field("offset"))
+}
+
+func (o *Object) field(name string) interface{} {
+    for _, f := range o.Fields {
+        if f.Name == name {
+            return f.Value
+        }
+    }
+    return nil
+}
+
+// ESCAPE escapes text for a quoted DOT string, where a backslash starts
+// an escape of its own in labels.
+var ESCAPE = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote makes s a quoted DOT identifier.
+func quote(s string) string {
+    return `"` + ESCAPE.Replace(s) + `"`
+}
+
+// label joins lines into a left aligned DOT label.
+func label(lines []string) string {
+    var b strings.Builder
+    for _, line := range lines {
+        b.WriteString(ESCAPE.Replace(line))
+        b.WriteString(`\l`)
+    }
+    return b.String()
+}
@@ -0,0 +1,312 @@
+// Package dump serializes tokens and syntax trees, spans and trivia
+// included, as JSON or S-expressions for external tools and golden
+// tests, or as Graphviz graphs along with the control flow of generated
+// LLVM functions.
+//
+// Both formats write the same values. A node or token is an object whose
+// kind is its type, such as "VarDecl" or "IDENTIFIER", followed by its
+// fields in declaration order with their names starting in lower case.
+// Spans, positions and identifiers are objects without a kind.
+package dump
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "reflect"
+    "strconv"
+    "strings"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+)
+
+// Object is a record of named values. Values are nil, bool, int,
+// float64, string, *Object or []interface{}.
+type Object struct {
+    Kind   string
+    Fields []Field
+}
+
+type Field struct {
+    Name  string
+    Value interface{}
+}
+
+func (o *Object) add(name string, value interface{}) *Object {
+    o.Fields = append(o.Fields, Field{name, value})
+    return o
+}
+
+// File pairs the tokens or nodes of a file with its name.
+func File(name, field string, value interface{}) *Object {
+    return (&Object{}).add("file", name).add(field, value)
+}
+
+func Tokens(toks []*lexer.Token) []interface{} {
+    list := []interface{}{}
+    for _, tok := range toks {
+        obj := &Object{Kind: lexer.TOKEN_NAMES[tok.Type]}
+        obj.add("content", tok.Content).add("span", Span(tok.Location))
+        obj.add("leading", trivia(tok.Leading)).add("trailing", trivia(tok.Trailing))
+        list = append(list, obj)
+    }
+
+    return list
+}
+
+func trivia(trivia []lexer.Trivia) []interface{} {
+    list := []interface{}{}
+    for _, t := range trivia {
+        obj := &Object{Kind: lexer.TRIVIA_NAMES[t.Type]}
+        list = append(list, obj.add("content", t.Content).add("span", Span(t.Location)))
+    }
+
+    return list
+}
+
+func Span(span lexer.Span) *Object {
+    return (&Object{}).add("start", position(span.Start)).add("end", position(span.End))
+}
+
+func position(pos lexer.Position) *Object {
+    return (&Object{}).add("raw", pos.Raw).add("line", pos.Line).add("offset", pos.Offset)
+}
+
+// Nodes converts a list of top level declarations.
+func Nodes(nodes []parser.Node) []interface{} {
+    list := []interface{}{}
+    for _, node := range nodes {
+        list = append(list, Node(node))
+    }
+
+    return list
+}
+
+// Node converts node and everything below it. Every exported field of
+// every node kind is included, so new fields show up without changes
+// here.
+func Node(node parser.Node) interface{} {
+    return value(reflect.ValueOf(node))
+}
+
+var (
+    nodeType = reflect.TypeOf((*parser.Node)(nil)).Elem()
+    spanType = reflect.TypeOf(lexer.Span{})
+)
+
+func value(v reflect.Value) interface{} {
+    switch v.Kind() {
+    case reflect.Invalid:
+        return nil
+    case reflect.Interface, reflect.Ptr:
+        if v.IsNil() {
+            return nil
+        }
+        if v.Type().Implements(nodeType) && v.Kind() == reflect.Ptr {
+            return node(v)
+        }
+        return value(v.Elem())
+    case reflect.Slice:
+        list := []interface{}{}
+        for i := 0; i < v.Len(); i++ {
+            list = append(list, value(v.Index(i)))
+        }
+        return list
+    case reflect.Struct:
+        if v.Type() == spanType {
+            return Span(v.Interface().(lexer.Span))
+        }
+        return fields(&Object{}, v)
+    case reflect.Bool:
+        return v.Bool()
+    case reflect.Int, reflect.Int32, reflect.Int64:
+        return int(v.Int())
+    case reflect.Float32, reflect.Float64:
+        return v.Float()
+    case reflect.String:
+        return v.String()
+    }
+
+    panic(fmt.Sprintf("dump: unexpected %s", v.Type()))
+}
+
+func node(v reflect.Value) *Object {
+    obj := &Object{Kind: strings.TrimSuffix(v.Elem().Type().Name(), "Node")}
+    obj.add("span", Span(v.Interface().(parser.Node).Loc()))
+    return fields(obj, v.Elem())
+}
+
+func fields(obj *Object, v reflect.Value) *Object {
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        if f := t.Field(i); f.PkgPath == "" {
+            obj.add(strings.ToLower(f.Name[:1]) + f.Name[1:], value(v.Field(i)))
+        }
+    }
+
+    return obj
+}
+
+// WriteJSON writes v as indented JSON.
+func WriteJSON(w io.Writer, v interface{}) error {
+    var b bytes.Buffer
+    if err := writeJSON(&b, v, ""); err != nil {
+        return err
+    }
+    b.WriteByte('\n')
+
+    _, err := w.Write(b.Bytes())
+    return err
+}
+
+func writeJSON(b *bytes.Buffer, v interface{}, indent string) error {
+    inner := indent + "  "
+    switch v := v.(type) {
+    case *Object:
+        // spans and positions go on one line
+        first, sep, last := "\n" + inner, ",\n" + inner, "\n" + indent
+        if plain(v) {
+            first, sep, last = "", ", ", ""
+        }
+
+        b.WriteString("{")
+        if v.Kind != "" {
+            b.WriteString(first + `"kind": ` + strconv.Quote(v.Kind))
+            first = sep
+        }
+        for _, f := range v.Fields {
+            b.WriteString(first + strconv.Quote(f.Name) + ": ")
+            if err := writeJSON(b, f.Value, inner); err != nil {
+                return err
+            }
+            first = sep
+        }
+        if len(v.Fields) > 0 || v.Kind != "" {
+            b.WriteString(last)
+        }
+        b.WriteString("}")
+    case []interface{}:
+        if len(v) == 0 {
+            b.WriteString("[]")
+            return nil
+        }
+
+        b.WriteString("[")
+        for i, elem := range v {
+            if i > 0 {
+                b.WriteString(",")
+            }
+            b.WriteString("\n" + inner)
+            if err := writeJSON(b, elem, inner); err != nil {
+                return err
+            }
+        }
+        b.WriteString("\n" + indent + "]")
+    default:
+        var scalar bytes.Buffer
+        enc := json.NewEncoder(&scalar)
+        enc.SetEscapeHTML(false)
+        if err := enc.Encode(v); err != nil {
+            return err
+        }
+        b.Write(bytes.TrimSuffix(scalar.Bytes(), []byte("\n")))
+    }
+
+    return nil
+}
+
+// plain objects have no kind and hold nothing but scalars and other
+// plain objects.
+func plain(v interface{}) bool {
+    switch v := v.(type) {
+    case *Object:
+        if v.Kind != "" {
+            return false
+        }
+        for _, f := range v.Fields {
+            if !plain(f.Value) {
+                return false
+            }
+        }
+    case []interface{}:
+        return false
+    }
+
+    return true
+}
+
+// WriteSexp writes v as S-expressions: an object is (Kind :name value
+// ...), a list (value ...), nil is nil and strings are quoted as in Go.
+// Lists of objects and objects holding them are broken over lines.
+func WriteSexp(w io.Writer, v interface{}) error {
+    var b bytes.Buffer
+    writeSexp(&b, v, "")
+    b.WriteByte('\n')
+
+    _, err := w.Write(b.Bytes())
+    return err
+}
+
+func writeSexp(b *bytes.Buffer, v interface{}, indent string) {
+    inner := indent + "  "
+    switch v := v.(type) {
+    case *Object:
+        b.WriteString("(" + v.Kind)
+        multiline := !small(v)
+        for i, f := range v.Fields {
+            if multiline {
+                b.WriteString("\n" + inner)
+            } else if i > 0 || v.Kind != "" {
+                b.WriteString(" ")
+            }
+            b.WriteString(":" + f.Name + " ")
+            writeSexp(b, f.Value, inner)
+        }
+        b.WriteString(")")
+    case []interface{}:
+        b.WriteString("(")
+        multiline := !small(v)
+        for i, elem := range v {
+            if multiline {
+                b.WriteString("\n" + inner)
+            } else if i > 0 {
+                b.WriteString(" ")
+            }
+            writeSexp(b, elem, inner)
+        }
+        b.WriteString(")")
+    case nil:
+        b.WriteString("nil")
+    case string:
+        b.WriteString(strconv.Quote(v))
+    default:
+        fmt.Fprint(b, v)
+    }
+}
+
+// small values fit on one line: scalars, lists of at most one small
+// value or of scalars only, and objects whose fields are all small.
+func small(v interface{}) bool {
+    switch v := v.(type) {
+    case *Object:
+        for _, f := range v.Fields {
+            if !small(f.Value) {
+                return false
+            }
+        }
+    case []interface{}:
+        if len(v) == 1 {
+            return small(v[0])
+        }
+        for _, elem := range v {
+            switch elem.(type) {
+            case *Object, []interface{}:
+                return false
+            }
+        }
+    }
+
+    return true
+}
@@ -0,0 +1,208 @@
+// Package format reprints Lyca source in one canonical layout: four
+// space indentation, a space on both sides of every '>' in a signature,
+// opening braces at the end of the line that opens them and at most one
+// blank line in a row. Comments are kept where they were written,
+// either on a line of their own or at the end of the line before.
+package format
+
+import (
+    "bytes"
+    "fmt"
+    "strings"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+)
+
+const INDENT = "    "
+
+// Source formats a single file. Errors are *lexer.Error when the file
+// does not parse.
+func Source(src []byte) ([]byte, error) {
+    out, tree, err := format(src)
+    if err != nil {
+        return nil, err
+    }
+
+    // formatting must neither change what the file means nor what a
+    // second run produces
+    again, reparsed, err := format(out)
+    if err != nil {
+        return nil, fmt.Errorf("formatted source does not parse: %v", err)
+    }
+    if dump(tree) != dump(reparsed) {
+        return nil, fmt.Errorf("formatting changed the syntax tree")
+    }
+    if !bytes.Equal(out, again) {
+        return nil, fmt.Errorf("formatting is not idempotent")
+    }
+
+    return out, nil
+}
+
+func format(src []byte) ([]byte, *parser.AST, error) {
+    toks, err := lexer.Lex(lexer.NewFile(src))
+    if err != nil {
+        return nil, nil, err
+    }
+
+    tree, err := parser.Parse(toks)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    text := []rune(string(src))
+    p := &printer{src: text, comments: comments(toks), buf: &bytes.Buffer{}, fresh: true}
+    p.members(tree.Nodes, len(text) + 1)
+
+    return p.buf.Bytes(), tree, nil
+}
+
+func dump(tree *parser.AST) string {
+    var buf bytes.Buffer
+    tree.Fprint(&buf)
+    return buf.String()
+}
+
+type comment struct {
+    start, end int
+    text       string
+
+    // own is set when only whitespace precedes the comment on its line
+    own bool
+}
+
+// comments collects the comment trivia of toks.
+func comments(toks []*lexer.Token) (res []comment) {
+    for i, tok := range toks {
+        own := i == 0
+        for _, trivia := range tok.Leading {
+            if trivia.Type == lexer.TRIVIA_NEWLINE {
+                own = true
+            } else if trivia.IsComment() {
+                res = append(res, newComment(trivia, own))
+                own = false
+            }
+        }
+
+        for _, trivia := range tok.Trailing {
+            if trivia.IsComment() {
+                res = append(res, newComment(trivia, false))
+            }
+        }
+    }
+
+    return
+}
+
+func newComment(trivia lexer.Trivia, own bool) comment {
+    loc := trivia.Location
+    return comment{loc.Start.Raw, loc.End.Raw, strings.TrimRight(trivia.Content, " \t\r"), own}
+}
+
+type printer struct {
+    src      []rune
+    comments []comment
+    next     int // the first comment not printed yet
+
+    buf    *bytes.Buffer
+    indent int
+
+    // last is the end of what was printed last, in the source. fresh is
+    // set when nothing was printed since a block opened and gap when the
+    // next line must follow a blank one.
+    last  int
+    fresh bool
+    gap   bool
+}
+
+func (p *printer) line(text string) {
+    p.buf.WriteString(strings.Repeat(INDENT, p.indent))
+    p.buf.WriteString(text)
+    p.buf.WriteByte('\n')
+    p.fresh = false
+}
+
+// space prints a blank line when the source has one before offset.
+func (p *printer) space(offset int) {
+    if !p.fresh && (p.gap || p.blank(p.last, offset)) {
+        p.blankLine()
+    }
+    p.gap = false
+}
+
+func (p *printer) blankLine() {
+    if p.buf.Len() > 0 && !bytes.HasSuffix(p.buf.Bytes(), []byte("\n\n")) {
+        p.buf.WriteByte('\n')
+    }
+}
+
+func (p *printer) blank(from, to int) bool {
+    if to > len(p.src) {
+        to = len(p.src)
+    }
+
+    newline := false
+    for i := from; i < to; i++ {
+        if p.src[i] == '\n' {
+            if newline {
+                return true
+            }
+            newline = true
+        } else if !lexer.IsWhitespace(p.src[i]) {
+            newline = false
+        }
+    }
+
+    return false
+}
+
+// flush prints the comments that start before offset. A comment that
+// followed code on its line goes at the end of the last line printed.
+func (p *printer) flush(offset int) {
+    for p.next < len(p.comments) && p.comments[p.next].start < offset {
+        c := p.comments[p.next]
+        p.next++
+
+        if !c.own && p.buf.Len() > 0 {
+            p.buf.Truncate(p.buf.Len() - 1)
+            p.buf.WriteString(" " + c.text + "\n")
+        } else {
+            p.space(c.start)
+            p.line(c.text)
+        }
+        p.advance(c.end)
+    }
+}
+
+func (p *printer) advance(offset int) {
+    if offset > p.last {
+        p.last = offset
+    }
+}
+
+func (p *printer) text(span lexer.Span) string {
+    return string(p.src[span.Start.Raw:span.End.Raw])
+}
+
+// Declaration renders a template, function, constructor or variable
+// declaration the way the formatter prints it, without its body or
+// initial value.
+func Declaration(node parser.Node) string {
+    p := &printer{buf: &bytes.Buffer{}}
+    switch n := node.(type) {
+    case *parser.TemplateNode:
+        return "tmpl " + n.Name.Value
+    case *parser.FuncDeclNode:
+        if n.Export {
+            return "export " + p.signature(n.Function.Signature)
+        }
+        return p.signature(n.Function.Signature)
+    case *parser.ConstructorNode:
+        return "constructor < (" + p.params(n.Parameters) + ")"
+    case *parser.VarDeclNode:
+        return p.typ(n.Type) + " " + n.Name.Value
+    }
+
+    return ""
+}
@@ -0,0 +1,87 @@
+package format
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+
+    "github.com/k3v/lyca/src/lexer"
+)
+
+// COMMENTED are layouts the example programs do not have, each with
+// comments the formatter has to move.
+var COMMENTED = map[string]string{
+    "comment before code on its line": "func () > main > () {\n    /* a */ int x = 1;\n}\n",
+    "comment inside an expression": "int x = 1 + /* one */ 1;\n",
+    "comments between declarations": "// a\n\n\n// b\nint x;\n// c\nint y; // d\n",
+    "comment in an empty block": "func () > main > () {\n// inside\n}\n",
+    "comment in a template": "tmpl T {\n    int x; /* x */\n    // end\n}\n",
+    "comment at the end without a newline": "int x;\n// last",
+    "messy layout": "func()>f>(int){return 1;}//f\n\n\n\ntmpl  T{int x;}",
+}
+
+func sources(t *testing.T) map[string][]byte {
+    paths, err := filepath.Glob("../../test/src/*.lyca")
+    if err != nil || len(paths) == 0 {
+        t.Fatalf("no example programs: %v", err)
+    }
+
+    res := map[string][]byte{}
+    for _, path := range paths {
+        src, err := ioutil.ReadFile(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        res[filepath.Base(path)] = src
+    }
+    for name, src := range COMMENTED {
+        res[name] = []byte(src)
+    }
+    return res
+}
+
+// commentTexts lists the comments of src in order, as the formatter
+// prints them.
+func commentTexts(t *testing.T, src []byte) []string {
+    toks, err := lexer.Lex(lexer.NewFile(src))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var res []string
+    for _, c := range comments(toks) {
+        res = append(res, c.text)
+    }
+    return res
+}
+
+// Formatting twice gives what formatting once does, and every comment
+// comes out once, in the order it went in.
+func TestSource(t *testing.T) {
+    for name, src := range sources(t) {
+        src := src
+        t.Run(name, func(t *testing.T) {
+            once, err := Source(src)
+            if err != nil {
+                t.Fatal(err)
+            }
+            twice, err := Source(once)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if string(once) != string(twice) {
+                t.Errorf("formatting again changed\n%s\ninto\n%s", once, twice)
+            }
+
+            want, got := commentTexts(t, src), commentTexts(t, once)
+            if len(got) != len(want) {
+                t.Fatalf("got comments %q, want %q", got, want)
+            }
+            for i := range want {
+                if got[i] != want[i] {
+                    t.Errorf("got comment %q, want %q", got[i], want[i])
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,294 @@
+package format
+
+import (
+    "bytes"
+    "strconv"
+    "strings"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+)
+
+// members prints declarations, at the top level or in a template, with
+// a blank line around every function and template.
+func (p *printer) members(nodes []parser.Node, end int) {
+    for i, node := range nodes {
+        if i > 0 && (spaced(nodes[i - 1]) || spaced(node)) {
+            p.gap = true
+        }
+
+        start := node.Loc().Start.Raw
+        p.flush(start)
+        p.space(start)
+        p.member(node)
+        p.advance(node.Loc().End.Raw)
+    }
+
+    p.flush(end)
+}
+
+func spaced(node parser.Node) bool {
+    switch node.(type) {
+    case *parser.FuncDeclNode, *parser.TemplateNode, *parser.ConstructorNode, *parser.TestNode:
+        return true
+    }
+
+    return false
+}
+
+func (p *printer) member(node parser.Node) {
+    switch n := node.(type) {
+    case *parser.FuncDeclNode:
+        prefix := ""
+        if n.Export {
+            prefix = "export "
+        }
+        p.block(prefix + p.signature(n.Function.Signature), n.Function.Body)
+        p.line("}")
+    case *parser.TemplateNode:
+        p.template(n)
+    case *parser.TestNode:
+        keyword := parser.KEYWORD_TEST
+        if n.Bench {
+            keyword = parser.KEYWORD_BENCH
+        }
+        p.block(keyword + " \"" + p.text(n.Name.Loc) + "\"", n.Body)
+        p.line("}")
+    case *parser.ConstructorNode:
+        p.block("constructor < (" + p.params(n.Parameters) + ")", n.Body)
+        p.line("}")
+    case *parser.VarDeclNode:
+        p.line(p.simple(n) + ";")
+    }
+}
+
+func (p *printer) template(n *parser.TemplateNode) {
+    p.open("tmpl " + n.Name.Value)
+    p.members(n.Members(), n.Loc().End.Raw)
+    p.indent--
+    p.line("}")
+}
+
+func (p *printer) open(header string) {
+    p.line(header + " {")
+    p.indent++
+    p.fresh = true
+}
+
+// block prints header, the opening brace and the statements of body, and
+// leaves the closing brace to the caller.
+func (p *printer) block(header string, body *parser.BlockNode) {
+    p.open(header)
+    for _, node := range body.Nodes {
+        start := node.Loc().Start.Raw
+        p.flush(start)
+        p.space(start)
+        p.stmt(node)
+        p.advance(node.Loc().End.Raw)
+    }
+
+    p.flush(body.Loc().End.Raw)
+    p.indent--
+}
+
+func (p *printer) stmt(node parser.Node) {
+    switch n := node.(type) {
+    case *parser.IfStmtNode:
+        p.ifStmt(n, "")
+    case *parser.LoopStmtNode:
+        header := p.expr(n.Cond)
+        if n.Init != nil || n.Post != nil || n.Cond == nil {
+            header = ""
+            if n.Init != nil {
+                header = p.simple(n.Init)
+            }
+            header += ";" + p.clause(n.Cond) + ";" + p.clause(n.Post)
+        }
+        p.block("for (" + header + ")", n.Body)
+        p.line("}")
+    default:
+        p.line(p.simple(node) + ";")
+    }
+}
+
+func (p *printer) ifStmt(n *parser.IfStmtNode, prefix string) {
+    p.block(prefix + "if (" + p.expr(n.Condition) + ")", n.Body)
+
+    switch e := n.Else.(type) {
+    case *parser.IfStmtNode:
+        p.ifStmt(e, "} else ")
+    case *parser.BlockNode:
+        p.block("} else", e)
+        p.line("}")
+    default:
+        p.line("}")
+    }
+}
+
+// clause is the condition or post statement of a for loop header.
+func (p *printer) clause(node parser.Node) string {
+    if node == nil {
+        return ""
+    }
+
+    if text := p.simple(node); text != "" {
+        return " " + text
+    }
+    return " " + p.expr(node)
+}
+
+// simple prints a statement that fits in a for loop header, without
+// its semicolon.
+func (p *printer) simple(node parser.Node) string {
+    switch n := node.(type) {
+    case *parser.VarDeclNode:
+        decl := p.typ(n.Type) + " " + n.Name.Value
+        if n.Value != nil {
+            decl += " = " + p.expr(n.Value)
+        }
+        return decl
+    case *parser.AssignStmtNode:
+        return p.expr(n.Target) + " = " + p.expr(n.Value)
+    case *parser.CallStmtNode:
+        return p.expr(n.Call)
+    case *parser.ReturnStmtNode:
+        if n.Value == nil {
+            return "return"
+        }
+        return "return " + p.expr(n.Value)
+    }
+
+    return ""
+}
+
+func (p *printer) signature(sig *parser.FuncSignatureNode) string {
+    res := "func (" + p.params(sig.Parameters) + ") > "
+    if sig.Name.Value != "" {
+        res += sig.Name.Value + " > "
+    }
+
+    return res + "(" + p.typ(sig.Return) + ")"
+}
+
+func (p *printer) params(params []*parser.VarDeclNode) string {
+    var list []string
+    for _, param := range params {
+        list = append(list, p.simple(param))
+    }
+
+    return strings.Join(list, ", ")
+}
+
+func (p *printer) typ(node parser.Node) string {
+    switch n := node.(type) {
+    case *parser.NamedTypeNode:
+        return n.Name.Value
+    case *parser.ArrayTypeNode:
+        return "[]" + p.typ(n.MemberType)
+    case *parser.FuncTypeNode:
+        var params []string
+        for _, param := range n.Parameters {
+            params = append(params, p.typ(param))
+        }
+        return "func (" + strings.Join(params, ", ") + ") > (" + p.typ(n.Return) + ")"
+    }
+
+    return ""
+}
+
+func (p *printer) expr(node parser.Node) string {
+    switch n := node.(type) {
+    case *parser.BoolLitNode:
+        return strconv.FormatBool(n.Value)
+    case *parser.NumLitNode:
+        return p.text(n.Loc())
+    case *parser.StringLitNode:
+        return "\"" + p.text(n.Loc()) + "\""
+    case *parser.CharLitNode:
+        return "'" + p.text(n.Loc()) + "'"
+    case *parser.FuncLitNode:
+        return p.funcLit(n.Function.(*parser.FuncNode))
+    case *parser.VarAccessNode:
+        return n.Name.Value
+    case *parser.UnaryExprNode:
+        return n.Operator + p.operand(n.Value)
+    case *parser.BinaryExprNode:
+        prec := parser.OPERATOR_PRECEDENCE[n.Operator.Value]
+        return p.side(n.Left, prec, false) + " " + n.Operator.Value + " " + p.side(n.Right, prec, true)
+    case *parser.ObjectAccessNode:
+        return p.operand(n.Object) + "." + n.Member.Value
+    case *parser.ArrayAccessNode:
+        return p.operand(n.Array) + "[" + p.expr(n.Index) + "]"
+    case *parser.CallExprNode:
+        return p.operand(n.Function) + "(" + p.args(n.Arguments) + ")"
+    case *parser.MakeExprNode:
+        return "make " + n.Template.Value + " < (" + p.args(n.Arguments) + ")"
+    }
+
+    return ""
+}
+
+func (p *printer) args(args []parser.Node) string {
+    var list []string
+    for _, arg := range args {
+        list = append(list, p.expr(arg))
+    }
+
+    return strings.Join(list, ", ")
+}
+
+// operand parenthesizes the operand of a unary or postfix operator when
+// it is an operator expression itself. Two unary operators in a row
+// would also lex as one.
+func (p *printer) operand(node parser.Node) string {
+    switch node.(type) {
+    case *parser.BinaryExprNode, *parser.UnaryExprNode:
+        return "(" + p.expr(node) + ")"
+    }
+
+    return p.expr(node)
+}
+
+// side parenthesizes an operand of a binary operator that binds less
+// tightly, or as tightly on the right since operators associate left.
+// Parentheses the source already had around an operator expression are
+// kept even when they are not needed.
+func (p *printer) side(node parser.Node, prec int, right bool) string {
+    if bin, ok := node.(*parser.BinaryExprNode); ok {
+        inner := parser.OPERATOR_PRECEDENCE[bin.Operator.Value]
+        if inner < prec || (right && inner == prec) || p.grouped(node) {
+            return "(" + p.expr(node) + ")"
+        }
+    }
+
+    return p.expr(node)
+}
+
+// grouped reports whether node is written in parentheses, which the
+// parser does not keep.
+func (p *printer) grouped(node parser.Node) bool {
+    before := node.Loc().Start.Raw - 1
+    for before >= 0 && (lexer.IsWhitespace(p.src[before]) || p.src[before] == '\n') {
+        before--
+    }
+
+    after := node.Loc().End.Raw
+    for after < len(p.src) && (lexer.IsWhitespace(p.src[after]) || p.src[after] == '\n') {
+        after++
+    }
+
+    return before >= 0 && p.src[before] == '(' && after < len(p.src) && p.src[after] == ')'
+}
+
+// funcLit prints a function literal into its own buffer, indented one
+// level deeper than the line it starts on.
+func (p *printer) funcLit(fn *parser.FuncNode) string {
+    outer := p.buf
+    p.buf = &bytes.Buffer{}
+    p.block(p.signature(fn.Signature), fn.Body)
+    body := p.buf.String()
+    p.buf = outer
+
+    // block indents its header like any other line
+    return strings.TrimPrefix(body, strings.Repeat(INDENT, p.indent)) + strings.Repeat(INDENT, p.indent) + "}"
+}
@@ -0,0 +1,305 @@
+package interp
+
+import (
+    "math"
+
+    "github.com/k3v/lyca/src/parser"
+)
+
+func (in *Interpreter) eval(node parser.Node, scope *env) interface{} {
+    switch n := node.(type) {
+    case *parser.NumLitNode:
+        if n.IsFloat {
+            return float32(n.FloatValue)
+        }
+        return int32(n.IntValue)
+    case *parser.BoolLitNode:
+        return n.Value
+    case *parser.CharLitNode:
+        return int8(n.Value)
+    case *parser.StringLitNode:
+        return &String{n.Value}
+    case *parser.FuncLitNode:
+        return &Function{Node: n.Function.(*parser.FuncNode), Env: scope}
+    case *parser.VarAccessNode:
+        return in.evalVarAccess(n, scope)
+    case *parser.ObjectAccessNode:
+        obj := in.object(n.Object, scope)
+        index, ok := obj.Template.Variables[n.Member.Value]
+        if !ok {
+            in.fail(n, "Undefined member " + n.Member.Value)
+        }
+        return obj.Fields[index]
+    case *parser.CallExprNode:
+        return in.evalCall(n, scope)
+    case *parser.MakeExprNode:
+        return in.evalMake(n, scope)
+    case *parser.UnaryExprNode:
+        return in.evalUnary(n, scope)
+    case *parser.BinaryExprNode:
+        return in.evalBinary(n, scope)
+    }
+
+    in.fail(node, "Unsupported expression")
+    return nil
+}
+
+func (in *Interpreter) evalVarAccess(node *parser.VarAccessNode, scope *env) interface{} {
+    name := node.Name.Value
+    if v := scope.lookup(name); v != nil {
+        return v.value
+    }
+
+    if name == "null" {
+        return nil
+    }
+
+    if fn, ok := in.functions[name]; ok {
+        return &Function{Node: fn, Env: in.globals}
+    }
+
+    in.fail(node, "Undefined variable " + name)
+    return nil
+}
+
+func (in *Interpreter) object(node parser.Node, scope *env) *Object {
+    obj, ok := in.eval(node, scope).(*Object)
+    if !ok || obj == nil {
+        in.fail(node, "Expression is not an object or is null")
+    }
+
+    return obj
+}
+
+func (in *Interpreter) evalCall(node *parser.CallExprNode, scope *env) interface{} {
+    var args []interface{}
+    for _, arg := range node.Arguments {
+        args = append(args, in.eval(arg, scope))
+    }
+
+    switch fn := node.Function.(type) {
+    case *parser.VarAccessNode:
+        if scope.lookup(fn.Name.Value) == nil && in.functions[fn.Name.Value] == nil {
+            if builtin, ok := builtins[fn.Name.Value]; ok {
+                return builtin(in, node, args)
+            }
+        }
+    case *parser.ObjectAccessNode:
+        receiver := in.eval(fn.Object, scope)
+        if str, ok := receiver.(*String); ok && fn.Member.Value == "len" {
+            return int32(len(str.Value))
+        }
+
+        obj, ok := receiver.(*Object)
+        if !ok || obj == nil {
+            in.fail(fn.Object, "Expression is not an object or is null")
+        }
+
+        if meth, ok := obj.Template.Methods[fn.Member.Value]; ok {
+            return in.call(&Function{Node: meth, This: obj, Env: in.globals}, args, node)
+        }
+    }
+
+    callee, ok := in.eval(node.Function, scope).(*Function)
+    if !ok || callee == nil {
+        in.fail(node.Function, "Expression is not callable")
+    }
+
+    return in.call(callee, args, node)
+}
+
+func (in *Interpreter) evalMake(node *parser.MakeExprNode, scope *env) interface{} {
+    tmpl, ok := in.templates[node.Template.Value]
+    if !ok {
+        in.fail(node, "Undefined template " + node.Template.Value)
+    }
+
+    obj := &Object{Template: tmpl}
+    for _, v := range tmpl.Node.Variables {
+        obj.Fields = append(obj.Fields, zero(v.Type))
+    }
+
+    var args []interface{}
+    for _, arg := range node.Arguments {
+        args = append(args, in.eval(arg, scope))
+    }
+
+    if construct := tmpl.Node.Constructor; construct != nil {
+        fn := &parser.FuncNode{
+            Signature: &parser.FuncSignatureNode{Parameters: construct.Parameters},
+            Body: construct.Body,
+        }
+        in.call(&Function{Node: fn, This: obj, Env: in.globals}, args, node)
+    }
+
+    return obj
+}
+
+func (in *Interpreter) evalUnary(node *parser.UnaryExprNode, scope *env) interface{} {
+    value := in.eval(node.Value, scope)
+    switch node.Operator {
+    case "!":
+        if b, ok := value.(bool); ok {
+            return !b
+        }
+    case "-":
+        switch v := value.(type) {
+        case int32:
+            return -v
+        case float32:
+            return -v
+        case int8:
+            return -v
+        }
+    }
+
+    in.fail(node, "Invalid operand for " + node.Operator)
+    return nil
+}
+
+func (in *Interpreter) evalBinary(node *parser.BinaryExprNode, scope *env) interface{} {
+    op := node.Operator.Value
+    left := in.eval(node.Left, scope)
+
+    switch op {
+    case "&&":
+        return truthy(left) && truthy(in.eval(node.Right, scope))
+    case "||":
+        return truthy(left) || truthy(in.eval(node.Right, scope))
+    }
+
+    right := in.eval(node.Right, scope)
+
+    if l, ok := left.(*String); ok && op == "+" {
+        if r, ok := right.(*String); ok {
+            return &String{l.Value + r.Value}
+        }
+    }
+
+    switch op {
+    case "==":
+        return equal(left, right)
+    case "!=":
+        return !equal(left, right)
+    }
+
+    _, lf := left.(float32)
+    _, rf := right.(float32)
+    if lf || rf {
+        return in.floatBinary(node, toFloat(left), toFloat(right))
+    }
+
+    l, lok := toInt(left)
+    r, rok := toInt(right)
+    if !lok || !rok {
+        in.fail(node, "Invalid operands for " + op)
+    }
+
+    res := in.intBinary(node, l, r)
+    if v, ok := res.(int32); ok {
+        if _, ok := left.(int8); ok {
+            return int8(v)
+        }
+    }
+
+    return res
+}
+
+func (in *Interpreter) intBinary(node *parser.BinaryExprNode, l, r int32) interface{} {
+    switch node.Operator.Value {
+    case "+":
+        return l + r
+    case "-":
+        return l - r
+    case "*":
+        return l * r
+    case "/", "%":
+        if r == 0 {
+            in.fail(node, "Integer division by zero")
+        }
+        if node.Operator.Value == "/" {
+            return l / r
+        }
+        return l % r
+    case ">":
+        return l > r
+    case ">=":
+        return l >= r
+    case "<":
+        return l < r
+    case "<=":
+        return l <= r
+    }
+
+    in.fail(node, "Invalid operator " + node.Operator.Value)
+    return nil
+}
+
+func (in *Interpreter) floatBinary(node *parser.BinaryExprNode, l, r float32) interface{} {
+    switch node.Operator.Value {
+    case "+":
+        return l + r
+    case "-":
+        return l - r
+    case "*":
+        return l * r
+    case "/":
+        return l / r
+    case "%":
+        return float32(math.Mod(float64(l), float64(r)))
+    case ">":
+        return l > r
+    case ">=":
+        return l >= r
+    case "<":
+        return l < r
+    case "<=":
+        return l <= r
+    }
+
+    in.fail(node, "Invalid operator " + node.Operator.Value)
+    return nil
+}
+
+// equal compares primitives by value and everything else by identity,
+// which is what the compiled pointer comparison does.
+func equal(left, right interface{}) bool {
+    _, lf := left.(float32)
+    _, rf := right.(float32)
+    if lf || rf {
+        return toFloat(left) == toFloat(right)
+    }
+
+    if l, ok := toInt(left); ok {
+        if r, ok := toInt(right); ok {
+            return l == r
+        }
+    }
+
+    return left == right
+}
+
+func toInt(value interface{}) (int32, bool) {
+    switch v := value.(type) {
+    case int32:
+        return v, true
+    case int8:
+        return int32(v), true
+    case bool:
+        if v {
+            return 1, true
+        }
+        return 0, true
+    }
+
+    return 0, false
+}
+
+func toFloat(value interface{}) float32 {
+    if f, ok := value.(float32); ok {
+        return f
+    }
+
+    i, _ := toInt(value)
+    return float32(i)
+}
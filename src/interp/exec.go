@@ -0,0 +1,130 @@
+package interp
+
+import (
+    "github.com/k3v/lyca/src/parser"
+)
+
+// call runs a function with already evaluated arguments. Methods carry
+// their receiver, which is bound to `this`.
+func (in *Interpreter) call(fn *Function, args []interface{}, site parser.Node) interface{} {
+    sig := fn.Node.Signature
+    if len(args) != len(sig.Parameters) {
+        in.fail(site, "Wrong number of arguments in call")
+    }
+    if in.depth >= MAX_DEPTH {
+        in.fail(site, "Stack overflow")
+    }
+    in.depth++
+    defer func() { in.depth-- }()
+
+    scope := newEnv(fn.Env)
+    if fn.This != nil {
+        scope.declare("this", nil, fn.This)
+    }
+
+    for i, param := range sig.Parameters {
+        scope.declare(param.Name.Value, param.Type, convert(args[i], param.Type))
+    }
+
+    ret, _ := in.execBlock(fn.Node.Body, scope)
+    return convert(ret, sig.Return)
+}
+
+func (in *Interpreter) execBlock(block *parser.BlockNode, outer *env) (interface{}, bool) {
+    scope := newEnv(outer)
+    for _, node := range block.Nodes {
+        if ret, done := in.exec(node, scope); done {
+            return ret, true
+        }
+    }
+
+    return nil, false
+}
+
+func (in *Interpreter) exec(node parser.Node, scope *env) (interface{}, bool) {
+    switch n := node.(type) {
+    case *parser.VarDeclNode:
+        in.execVarDecl(n, scope)
+    case *parser.AssignStmtNode:
+        in.execAssign(n, scope)
+    case *parser.CallStmtNode:
+        in.eval(n.Call, scope)
+    case *parser.ReturnStmtNode:
+        if n.Value == nil {
+            return nil, true
+        }
+        return in.eval(n.Value, scope), true
+    case *parser.IfStmtNode:
+        return in.execIf(n, scope)
+    case *parser.LoopStmtNode:
+        return in.execLoop(n, scope)
+    case *parser.BlockNode:
+        return in.execBlock(n, scope)
+    default:
+        in.fail(node, "Unsupported statement")
+    }
+
+    return nil, false
+}
+
+func (in *Interpreter) execVarDecl(node *parser.VarDeclNode, scope *env) {
+    value := zero(node.Type)
+    if node.Value != nil {
+        value = convert(in.eval(node.Value, scope), node.Type)
+    }
+
+    scope.declare(node.Name.Value, node.Type, value)
+}
+
+func (in *Interpreter) execAssign(node *parser.AssignStmtNode, scope *env) {
+    value := in.eval(node.Value, scope)
+
+    switch t := node.Target.(type) {
+    case *parser.VarAccessNode:
+        v := scope.lookup(t.Name.Value)
+        if v == nil {
+            in.fail(t, "Undefined variable " + t.Name.Value)
+        }
+        v.value = convert(value, v.t)
+    case *parser.ObjectAccessNode:
+        obj := in.object(t.Object, scope)
+        index, ok := obj.Template.Variables[t.Member.Value]
+        if !ok {
+            in.fail(t, "Undefined member " + t.Member.Value)
+        }
+        obj.Fields[index] = convert(value, obj.Template.Node.Variables[index].Type)
+    default:
+        in.fail(node.Target, "Cannot assign to expression")
+    }
+}
+
+func (in *Interpreter) execIf(node *parser.IfStmtNode, scope *env) (interface{}, bool) {
+    if truthy(in.eval(node.Condition, scope)) {
+        return in.execBlock(node.Body, scope)
+    }
+
+    if node.Else != nil {
+        return in.exec(node.Else, scope)
+    }
+
+    return nil, false
+}
+
+func (in *Interpreter) execLoop(node *parser.LoopStmtNode, outer *env) (interface{}, bool) {
+    scope := newEnv(outer)
+    if node.Init != nil {
+        in.execVarDecl(node.Init, scope)
+    }
+
+    for node.Cond == nil || truthy(in.eval(node.Cond, scope)) {
+        if ret, done := in.execBlock(node.Body, scope); done {
+            return ret, true
+        }
+
+        if node.Post != nil {
+            in.exec(node.Post, scope)
+        }
+    }
+
+    return nil, false
+}
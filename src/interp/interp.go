@@ -0,0 +1,118 @@
+package interp
+
+import (
+    "io"
+    "strconv"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+)
+
+// MAX_DEPTH limits nested calls like the VM's, so runaway recursion is
+// a Lyca error rather than a Go stack overflow.
+const MAX_DEPTH = 10000
+
+type Interpreter struct {
+    Stdout io.Writer
+
+    tree      *parser.AST
+    globals   *env
+    templates map[string]*Template
+    functions map[string]*parser.FuncNode
+    depth     int
+}
+
+func New(tree *parser.AST, stdout io.Writer) *Interpreter {
+    return &Interpreter{
+        Stdout: stdout,
+
+        tree: tree,
+        globals: newEnv(nil),
+        templates: map[string]*Template{},
+        functions: map[string]*parser.FuncNode{},
+    }
+}
+
+// Run executes main and returns its result as the exit code. args is a
+// command line: args[0] names the program and the rest are bound to
+// main's parameters in order, like the compiled entry point does.
+func (in *Interpreter) Run(args []string) (code int, err error) {
+    defer lexer.Recover(&err)
+
+    in.declare()
+
+    main, ok := in.functions["main"]
+    if !ok {
+        return 0, &lexer.Error{Message: "no main function to run"}
+    }
+
+    in.depth = 0
+    if len(args) > 0 {
+        args = args[1:]
+    }
+
+    params := main.Signature.Parameters
+    if len(params) > len(args) {
+        return 0, &lexer.Error{Location: main.Loc(), Message: "main expects more arguments than were given"}
+    }
+
+    var values []interface{}
+    for i, param := range params {
+        values = append(values, in.argument(param, args[i]))
+    }
+
+    ret := in.call(&Function{Node: main, Env: in.globals}, values, main)
+    if v, ok := ret.(int32); ok {
+        code = int(v)
+    }
+
+    return code, nil
+}
+
+func (in *Interpreter) declare() {
+    for _, node := range in.tree.Nodes {
+        switch n := node.(type) {
+        case *parser.TemplateNode:
+            tmpl := &Template{
+                Node: n,
+                Variables: map[string]int{},
+                Methods: map[string]*parser.FuncNode{},
+            }
+            for i, v := range n.Variables {
+                tmpl.Variables[v.Name.Value] = i
+            }
+            for _, meth := range n.Methods {
+                tmpl.Methods[meth.Function.Signature.Name.Value] = meth.Function
+            }
+            in.templates[n.Name.Value] = tmpl
+        case *parser.FuncDeclNode:
+            in.functions[n.Function.Signature.Name.Value] = n.Function
+        }
+    }
+
+    for _, node := range in.tree.Nodes {
+        if n, ok := node.(*parser.VarDeclNode); ok {
+            in.execVarDecl(n, in.globals)
+        }
+    }
+}
+
+func (in *Interpreter) argument(param *parser.VarDeclNode, arg string) interface{} {
+    switch typeName(param.Type) {
+    case "int":
+        v, _ := strconv.ParseInt(arg, 10, 32)
+        return int32(v)
+    case "float":
+        v, _ := strconv.ParseFloat(arg, 32)
+        return float32(v)
+    case "string":
+        return &String{arg}
+    }
+
+    lexer.Fail(param.Loc(), "main parameters must be int, float or string")
+    return nil
+}
+
+func (in *Interpreter) fail(node parser.Node, message string) {
+    lexer.Fail(node.Loc(), message)
+}
@@ -0,0 +1,97 @@
+package interp
+
+import (
+    "bytes"
+    "math"
+    "strings"
+    "testing"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+)
+
+func parse(t *testing.T, src string) *parser.AST {
+    toks, err := lexer.Lex(lexer.NewFile([]byte(strings.TrimPrefix(src, "\n"))))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tree, err := parser.Parse(toks)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return tree
+}
+
+// run runs src with args as its command line and returns what it
+// printed.
+func run(t *testing.T, src string, args ...string) (string, int, error) {
+    var stdout bytes.Buffer
+    code, err := New(parse(t, src), &stdout).Run(args)
+    return stdout.String(), code, err
+}
+
+const ARGS_PROGRAM = `
+func (int n, string s) > main > (int) {
+    printf("%d %s\n", n, s);
+    return n;
+}`
+
+// The first argument names the program, like argv[0] of the compiled
+// entry point.
+func TestRunArguments(t *testing.T) {
+    out, code, err := run(t, ARGS_PROGRAM, "f.lyca", "5", "hello")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if out != "5 hello\n" || code != 5 {
+        t.Errorf("got %q and exit code %d, want \"5 hello\\n\" and 5", out, code)
+    }
+
+    _, _, err = run(t, ARGS_PROGRAM, "f.lyca", "5")
+    if e, ok := err.(*lexer.Error); !ok || e.Message != "main expects more arguments than were given" {
+        t.Errorf("got error %v, want too few arguments", err)
+    }
+}
+
+// The cases the example programs cannot print the same way on every
+// backend; test/src/printf.lyca compares the rest with C.
+func TestSprintf(t *testing.T) {
+    tests := []struct {
+        format string
+        args   []interface{}
+        want   string
+    }{
+        {"%c", []interface{}{int32(200)}, "\xc8"},
+        {"%p|%8p|%-8p|", []interface{}{nil, nil, (*String)(nil)}, "(nil)|   (nil)|(nil)   |"},
+        {"%p", []interface{}{int32(255)}, "0xff"},
+        {"%g %E", []interface{}{float32(0.5), float32(2)}, "0.5 2.000000E+00"},
+        {"%+F|%-6f|%06g", []interface{}{float32(math.Inf(1)), float32(math.NaN()), float32(math.Inf(-1))}, "+INF|nan   |  -inf"},
+        {"%lu %hd %zd", []interface{}{int32(-1), int32(7), int32(8)}, "4294967295 7 8"},
+    }
+
+    for _, test := range tests {
+        if got := Sprintf(test.format, test.args); got != test.want {
+            t.Errorf("%s: got %q, want %q", test.format, got, test.want)
+        }
+    }
+}
+
+func TestStackOverflow(t *testing.T) {
+    src := `
+func (int n) > down > (int) {
+    return down(n + 1);
+}
+
+func () > main > (int) {
+    return down(0);
+}`
+    in := New(parse(t, src), nil)
+    for i := 0; i < 2; i++ {
+        _, err := in.Run(nil)
+        e, ok := err.(*lexer.Error)
+        if !ok || e.Message != "Stack overflow" || e.Location.Start.Line != 2 {
+            t.Fatalf("run %d: got error %v, want a stack overflow on line 2", i + 1, err)
+        }
+    }
+}
@@ -0,0 +1,164 @@
+package interp
+
+import (
+    "fmt"
+    "math"
+    "reflect"
+    "strings"
+
+    "github.com/k3v/lyca/src/parser"
+)
+
+type builtin func(in *Interpreter, node *parser.CallExprNode, args []interface{}) interface{}
+
+var builtins map[string]builtin
+
+func init() {
+    builtins = map[string]builtin{
+        "printf": printf,
+    }
+}
+
+func printf(in *Interpreter, node *parser.CallExprNode, args []interface{}) interface{} {
+    if len(args) == 0 {
+        in.fail(node, "printf expects a format string")
+    }
+
+    format, ok := args[0].(*String)
+    if !ok || format == nil {
+        in.fail(node.Arguments[0], "printf expects a format string")
+    }
+
+    out := Sprintf(format.Value, args[1:])
+    fmt.Fprint(in.Stdout, out)
+    return int32(len(out))
+}
+
+// Sprintf formats Lyca values following C's printf conventions.
+func Sprintf(format string, args []interface{}) string {
+    var out strings.Builder
+    for i := 0; i < len(format); i++ {
+        if format[i] != '%' {
+            out.WriteByte(format[i])
+            continue
+        }
+
+        start := i
+        i++
+        for i < len(format) && strings.IndexByte("-+ #0123456789.lhz", format[i]) >= 0 {
+            i++
+        }
+        if i == len(format) {
+            out.WriteString(format[start:])
+            break
+        }
+
+        verb := format[i]
+        spec := strings.NewReplacer("l", "", "h", "", "z", "").Replace(format[start:i])
+        if verb == '%' {
+            out.WriteByte('%')
+            continue
+        }
+
+        var arg interface{}
+        if len(args) > 0 {
+            arg, args = args[0], args[1:]
+        }
+        out.WriteString(formatVerb(spec, verb, arg))
+    }
+
+    return out.String()
+}
+
+func formatVerb(spec string, verb byte, arg interface{}) string {
+    switch verb {
+    case 'd', 'i':
+        i, _ := toInt(arg)
+        return fmt.Sprintf(spec + "d", i)
+    case 'u':
+        i, _ := toInt(arg)
+        return fmt.Sprintf(spec + "d", uint32(i))
+    case 'x', 'X', 'o':
+        i, _ := toInt(arg)
+        return fmt.Sprintf(spec + string(verb), uint32(i))
+    case 'c':
+        // one byte, not the UTF-8 encoding of a rune
+        i, _ := toInt(arg)
+        return fmt.Sprintf(spec + "s", string([]byte{byte(i)}))
+    case 'f', 'F', 'e', 'E', 'g', 'G':
+        f := float64(toFloat(arg))
+        if math.IsInf(f, 0) || math.IsNaN(f) {
+            return nonFinite(spec, verb, f)
+        }
+        if !strings.Contains(spec, ".") {
+            // C prints six digits where Go prints as many as it takes
+            spec += ".6"
+        }
+        return fmt.Sprintf(spec + string(verb), f)
+    case 's':
+        if str, ok := arg.(*String); ok && str != nil {
+            return fmt.Sprintf(spec + "s", str.Value)
+        }
+        return fmt.Sprintf(spec + "s", "(null)")
+    case 'p':
+        return fmt.Sprintf(padding(spec) + "s", pointer(arg))
+    }
+
+    return spec + string(verb)
+}
+
+// nonFinite prints infinities and NaN like C: inf and nan, upper case
+// for upper case verbs, padded with spaces only.
+func nonFinite(spec string, verb byte, f float64) string {
+    text := "nan"
+    if math.IsInf(f, 1) {
+        text = "inf"
+    } else if math.IsInf(f, -1) {
+        text = "-inf"
+    }
+
+    if text[0] != '-' {
+        if strings.Contains(spec, "+") {
+            text = "+" + text
+        } else if strings.Contains(spec, " ") {
+            text = " " + text
+        }
+    }
+    if verb >= 'A' && verb <= 'Z' {
+        text = strings.ToUpper(text)
+    }
+
+    return fmt.Sprintf(padding(spec) + "s", text)
+}
+
+// padding keeps the width and left alignment of spec, dropping the
+// flags and precision that only apply to numbers.
+func padding(spec string) string {
+    flags := strings.TrimLeft(spec[1:], "-+ #0")
+    width := flags
+    if dot := strings.IndexByte(width, '.'); dot >= 0 {
+        width = width[:dot]
+    }
+
+    if strings.Contains(spec[:len(spec) - len(flags)], "-") {
+        return "%-" + width
+    }
+    return "%" + width
+}
+
+// pointer prints a reference the way glibc does: (nil) for null and the
+// address in hexadecimal otherwise.
+func pointer(arg interface{}) string {
+    if i, ok := toInt(arg); ok {
+        arg = uintptr(uint32(i))
+    }
+
+    v := reflect.ValueOf(arg)
+    switch {
+    case !v.IsValid(), v.Kind() == reflect.Ptr && v.IsNil(), v.Kind() == reflect.Uintptr && v.Uint() == 0:
+        return "(nil)"
+    case v.Kind() == reflect.Uintptr:
+        return fmt.Sprintf("%#x", v.Uint())
+    }
+    return fmt.Sprintf("%p", arg)
+}
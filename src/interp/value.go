@@ -0,0 +1,106 @@
+package interp
+
+import (
+    "github.com/k3v/lyca/src/parser"
+)
+
+// Values are represented by the Go types int32 (int), float32 (float),
+// int8 (char), bool (boolean), *String, *Object, *Function and nil for
+// null, mirroring the LLVM types the compiler uses.
+
+type String struct {
+    Value string
+}
+
+type Template struct {
+    Node      *parser.TemplateNode
+    Variables map[string]int
+    Methods   map[string]*parser.FuncNode
+}
+
+type Object struct {
+    Template *Template
+    Fields   []interface{}
+}
+
+type Function struct {
+    Node *parser.FuncNode
+    This *Object
+    Env  *env
+}
+
+type variable struct {
+    value interface{}
+    t     parser.Node
+}
+
+type env struct {
+    outer *env
+    vars  map[string]*variable
+}
+
+func newEnv(outer *env) *env {
+    return &env{outer: outer, vars: map[string]*variable{}}
+}
+
+func (e *env) lookup(name string) *variable {
+    for ; e != nil; e = e.outer {
+        if v, ok := e.vars[name]; ok {
+            return v
+        }
+    }
+
+    return nil
+}
+
+func (e *env) declare(name string, t parser.Node, value interface{}) {
+    e.vars[name] = &variable{value, t}
+}
+
+func typeName(t parser.Node) string {
+    if named, ok := t.(*parser.NamedTypeNode); ok {
+        return named.Name.Value
+    }
+
+    return ""
+}
+
+func zero(t parser.Node) interface{} {
+    switch typeName(t) {
+    case "int":
+        return int32(0)
+    case "float":
+        return float32(0)
+    case "char":
+        return int8(0)
+    case "boolean":
+        return false
+    }
+
+    return nil
+}
+
+// convert applies the implicit conversions the compiler performs when a
+// value is stored into a variable, parameter or return slot of type t.
+func convert(value interface{}, t parser.Node) interface{} {
+    switch typeName(t) {
+    case "float":
+        switch v := value.(type) {
+        case int32:
+            return float32(v)
+        case int8:
+            return float32(v)
+        }
+    case "int":
+        if v, ok := value.(int8); ok {
+            return int32(v)
+        }
+    }
+
+    return value
+}
+
+func truthy(value interface{}) bool {
+    b, _ := value.(bool)
+    return b
+}
@@ -0,0 +1,29 @@
+package lexer
+
+import (
+    "strconv"
+)
+
+type Error struct {
+    Location Span
+    Message  string
+}
+
+func (e *Error) Error() string {
+    start := e.Location.Start
+    return strconv.Itoa(start.Line) + ":" + strconv.Itoa(start.Offset) + " " + e.Message
+}
+
+func Fail(location Span, message string) {
+    panic(&Error{location, message})
+}
+
+func Recover(err *error) {
+    if r := recover(); r != nil {
+        e, ok := r.(*Error)
+        if !ok {
+            panic(r)
+        }
+        *err = e
+    }
+}
@@ -1,31 +1,47 @@
 package lexer
 
 import (
-    "os"
-    "log"
+    "io"
     "io/ioutil"
 )
 
 type File struct {
+    Name string
+
     contents []rune
     curr     Position
+    base     int
 }
 
-func LycaFile(file *os.File) *File {
-    contents, err := ioutil.ReadAll(file)
+// LycaFile reads a whole source file, e.g. an *os.File or a bytes.Reader.
+func LycaFile(r io.Reader) (*File, error) {
+    contents, err := ioutil.ReadAll(r)
     if err != nil {
-        log.Fatal("There was a problem reading the file " + file.Name());
+        return nil, err
     }
 
-    return &File{[]rune(string(contents)), Position{0, 1, 1}}
+    return NewFile(contents), nil
+}
+
+func NewFile(contents []byte) *File {
+    return newFile("", contents, 0)
+}
+
+func newFile(name string, contents []byte, base int) *File {
+    return &File{
+        Name: name,
+        contents: []rune(string(contents)),
+        curr: Position{base, 1, 1},
+        base: base,
+    }
 }
 
 func (f *File) peek(ahead int) rune {
-    if f.curr.Raw + ahead >= len(f.contents) {
+    if f.curr.Raw - f.base + ahead >= len(f.contents) {
         return 0
     }
 
-    return f.contents[f.curr.Raw + ahead]
+    return f.contents[f.curr.Raw - f.base + ahead]
 }
 
 func (f *File) consume() {
@@ -39,6 +55,36 @@ func (f *File) consume() {
 
 }
 
+func (f *File) text(start, end Position) string {
+    return string(f.contents[start.Raw - f.base:end.Raw - f.base])
+}
+
+// FileSet gives the files of one compilation disjoint ranges of raw
+// offsets, so that a Span alone tells which file it points into.
+type FileSet struct {
+    files []*File
+    base  int
+}
+
+func (s *FileSet) AddFile(name string, contents []byte) *File {
+    f := newFile(name, contents, s.base)
+    s.files = append(s.files, f)
+    s.base += len(f.contents) + 1
+
+    return f
+}
+
+// File returns the file containing pos, or nil if there is none.
+func (s *FileSet) File(pos Position) *File {
+    for _, f := range s.files {
+        if pos.Raw >= f.base && pos.Raw <= f.base + len(f.contents) {
+            return f
+        }
+    }
+
+    return nil
+}
+
 type Position struct {
     Raw, Line, Offset int
 }
@@ -1,7 +1,6 @@
 package lexer
 
 import (
-    "log"
     "strings"
 )
 
@@ -10,14 +9,23 @@ type lexer struct {
     start  Position
 
     Tokens []*Token
+
+    // trivia is waiting for the next token; trailing is set until the
+    // line of the last token ends, while trivia still trails it
+    trivia   []Trivia
+    trailing bool
 }
 
-func Lex(f *File) []*Token {
+// Lex splits f into tokens ending with a TOKEN_EOF. Comments and
+// whitespace are kept as trivia on the tokens around them so that
+// Source can reproduce the file.
+func Lex(f *File) (toks []*Token, err error) {
     lexer := &lexer{
-        f,
-        Position{0,1,1},
-        []*Token{},
+        File: f,
+        start: f.curr,
+        Tokens: []*Token{},
     }
+    defer Recover(&err)
 
     for {
         if lexer.peek(0) == 0 {
@@ -26,18 +34,23 @@ func Lex(f *File) []*Token {
         lexer.lex()
     }
 
-    return lexer.Tokens
+    lexer.resetToken()
+    lexer.pushToken(TOKEN_EOF)
+
+    return lexer.Tokens, nil
 }
 
 func (l *lexer) lex() {
     l.resetToken()
 
     if l.peek(0) == '/' && (l.peek(1) == '/' || l.peek(1) == '*') {
-        l.ignoreComment()
-    } else if l.peek(0) == ' ' {
-        l.ignoreWhitespace()
+        l.lexComment()
+    } else if IsWhitespace(l.peek(0)) {
+        l.lexWhitespace()
     } else if l.peek(0) == '\n' {
         l.consume()
+        l.trailing = false
+        l.pushTrivia(TRIVIA_NEWLINE)
     } else if l.peek(0) == '_' || IsLetter(l.peek(0)) {
         l.lexIdentifier()
     } else if l.peek(0) == '"' {
@@ -50,45 +63,70 @@ func (l *lexer) lex() {
         l.lexOperator()
     } else if IsSeparator(l.peek(0)) {
         l.lexSeparator()
+    } else {
+        l.fail("Unexpected character " + string(l.peek(0)))
     }
 }
 
+func (l *lexer) fail(message string) {
+    Fail(Span{l.start, l.curr}, message)
+}
+
 func (l *lexer) resetToken() {
     l.start = l.curr
 }
 
 func (l *lexer) pushToken(t TokenType) {
     l.Tokens = append(l.Tokens, &Token{
-        t,
-        string(l.contents[l.start.Raw:l.curr.Raw]),
-        Span{l.start, l.curr},
+        Type: t,
+        Content: l.text(l.start, l.curr),
+        Location: Span{l.start, l.curr},
+        Leading: l.trivia,
     })
+    l.trivia = nil
+    l.trailing = true
 }
 
-func (l *lexer) ignoreComment() {
+func (l *lexer) pushTrivia(t TriviaType) {
+    trivia := Trivia{t, l.text(l.start, l.curr), Span{l.start, l.curr}}
+    if l.trailing && len(l.Tokens) > 0 {
+        last := l.Tokens[len(l.Tokens) - 1]
+        last.Trailing = append(last.Trailing, trivia)
+        return
+    }
+
+    l.trivia = append(l.trivia, trivia)
+}
+
+func (l *lexer) lexComment() {
     l.consume()
     l.expect('/', '*')
     if l.peek(0) == '/' {
         l.consume()
-        for l.peek(0) != '\n' {
+        for l.peek(0) != '\n' && l.peek(0) != 0 {
             l.consume()
         }
-        l.consume()
+        l.pushTrivia(TRIVIA_LINE_COMMENT)
     } else if l.peek(0) == '*' {
         l.consume()
-        for l.peek(0) != '*' && l.peek(0) != '/' {
+        for l.peek(0) != '*' || l.peek(1) != '/' {
+            if l.peek(0) == 0 {
+                l.fail("Unterminated block comment")
+            }
             l.consume()
         }
         l.consume()
         l.consume()
+        l.pushTrivia(TRIVIA_BLOCK_COMMENT)
     }
 }
 
-func (l *lexer) ignoreWhitespace() {
+func (l *lexer) lexWhitespace() {
     l.consume()
-    for l.peek(0) == ' ' {
+    for IsWhitespace(l.peek(0)) {
         l.consume()
     }
+    l.pushTrivia(TRIVIA_WHITESPACE)
 }
 
 func (l *lexer) lexIdentifier() {
@@ -112,7 +150,7 @@ func (l *lexer) lexString() {
             l.consume()
             break
         } else if l.peek(0) == 0 || l.peek(0) == '\n' {
-            log.Fatal("Unterminated string literal")
+            l.fail("Unterminated string literal")
         } else {
             l.consume()
         }
@@ -147,7 +185,7 @@ func (l *lexer) lexCharacter() {
             l.consume()
             break
         } else if l.peek(0) == 0 || l.peek(0) == '\n' {
-            log.Fatal("Unterminated character literal")
+            l.fail("Unterminated character literal")
         } else {
             l.consume()
         }
@@ -179,6 +217,10 @@ func IsLetter(r rune) bool {
     return (r >= 'a' && r <= 'z') || (r >= 'A' && r <='Z')
 }
 
+func IsWhitespace(r rune) bool {
+    return r == ' ' || r == '\t' || r == '\r'
+}
+
 func IsDecimal(r rune) bool {
     return r >= '0' && r <= '9'
 }
@@ -198,13 +240,5 @@ func (l *lexer) expect(runes ...rune) {
         }
     }
 
-    log.Fatal("Unexpected token:", string(l.peek(0)))
-}
-
-func (l *lexer) printTokens() {
-    log.Print("Tokens: [")
-    for _, tok := range l.Tokens {
-        log.Println(TOKEN_NAMES[tok.Type], tok.Content)
-    }
-    log.Print("]")
+    l.fail("Unexpected token: " + string(l.peek(0)))
 }
@@ -0,0 +1,137 @@
+package lexer
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func contents(toks []*Token) string {
+    var parts []string
+    for _, tok := range toks {
+        if tok.Type != TOKEN_EOF {
+            parts = append(parts, tok.Content)
+        }
+    }
+    return strings.Join(parts, " ")
+}
+
+// Each of these used to stop the compiler with log.Fatal, loop forever
+// or lex into something else.
+func TestLexAccepts(t *testing.T) {
+    tests := []struct {
+        name string
+        src  string
+        want string
+    }{
+        {"tabs", "int\tx\t=\t1;", "int x = 1 ;"},
+        {"crlf", "int x;\r\nint y;\r\n", "int x ; int y ;"},
+        {"star in block comment", "/* a * b */ x", "x"},
+        {"slash in block comment", "/* a / b */ x", "x"},
+        {"multi line block comment", "/**\n * doc\n */\nx", "x"},
+        {"line comment at end of file", "x // no newline", "x"},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            toks, err := Lex(NewFile([]byte(test.src)))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := contents(toks); got != test.want {
+                t.Errorf("got tokens %q, want %q", got, test.want)
+            }
+        })
+    }
+}
+
+// Lex errors come back as an *Error locating the problem instead of
+// ending the process. Literals are located from after their opening
+// quote, like their tokens.
+func TestLexErrors(t *testing.T) {
+    tests := []struct {
+        name    string
+        src     string
+        message string
+        line    int
+        offset  int
+    }{
+        {"unterminated block comment", "x /* never closed", "Unterminated block comment", 1, 3},
+        {"unknown character", "int x = 1 @ 2;", "Unexpected character @", 1, 11},
+        {"unterminated string", "string s = \"abc\n", "Unterminated string literal", 1, 13},
+        {"unterminated character", "char c = 'a", "Unterminated character literal", 1, 11},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            _, err := Lex(NewFile([]byte(test.src)))
+            e, ok := err.(*Error)
+            if !ok {
+                t.Fatalf("got error %v, want an *Error", err)
+            }
+
+            start := e.Location.Start
+            if e.Message != test.message || start.Line != test.line || start.Offset != test.offset {
+                t.Errorf("got %d:%d %s, want %d:%d %s", start.Line, start.Offset, e.Message,
+                    test.line, test.offset, test.message)
+            }
+        })
+    }
+}
+
+// LAYOUTS are trivia the example programs do not have.
+var LAYOUTS = map[string]string{
+    "tabs": "func () > main > () {\n\tint\tx = 1;\t// tab\n}\n",
+    "crlf": "int x = 1;\r\n\r\n// comment\r\nint y;\r\n",
+    "block comments": "/**\n * doc\n */\nint /* a */ x /**/;/* end */",
+    "line comment at end of file": "int x;\n// no newline",
+    "only a comment": "// nothing else",
+    "empty": "",
+    "blank lines and spaces": "\n\n   int x;   \n\n",
+    "escapes": "string s = \"a\\\"b\\n\"; char c = '\\'';",
+}
+
+// Source gives back every byte that was lexed: tokens with their
+// leading and trailing trivia.
+func TestSourceRoundTrip(t *testing.T) {
+    srcs := map[string]string{}
+    for name, src := range LAYOUTS {
+        srcs[name] = src
+    }
+    for _, dir := range []string{"src", "fail"} {
+        paths, err := filepath.Glob(filepath.Join("../../test", dir, "*.lyca"))
+        if err != nil || len(paths) == 0 {
+            t.Fatalf("no programs in test/%s: %v", dir, err)
+        }
+        for _, path := range paths {
+            src, err := ioutil.ReadFile(path)
+            if err != nil {
+                t.Fatal(err)
+            }
+            srcs[dir + "/" + filepath.Base(path)] = string(src)
+        }
+    }
+
+    for name, src := range srcs {
+        src := src
+        t.Run(name, func(t *testing.T) {
+            toks, err := Lex(NewFile([]byte(src)))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := Source(toks); got != src {
+                t.Errorf("got\n%q\nwant\n%q", got, src)
+            }
+        })
+    }
+}
+
+// A comment that never ends has nothing to round trip; it is an error
+// at its start.
+func TestSourceUnterminatedComment(t *testing.T) {
+    _, err := Lex(NewFile([]byte("int x;\n/* open\n * still open\n")))
+    if e, ok := err.(*Error); !ok || e.Message != "Unterminated block comment" {
+        t.Errorf("got error %v, want an unterminated block comment", err)
+    }
+}
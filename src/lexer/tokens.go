@@ -1,5 +1,9 @@
 package lexer
 
+import (
+    "strings"
+)
+
 type TokenType int
 
 const (
@@ -11,12 +15,22 @@ const (
 
     TOKEN_OPERATOR
     TOKEN_SEPARATOR
+
+    // TOKEN_EOF ends every file and holds the trivia after its last token
+    TOKEN_EOF
 )
 
+// A Token's Content and Location leave out the quotes of string and
+// character literals. Leading trivia is everything between the line of
+// the previous token and the token, trailing trivia the rest of the
+// token's own line.
 type Token struct {
     Type     TokenType
     Content  string
     Location Span
+
+    Leading  []Trivia
+    Trailing []Trivia
 }
 
 var TOKEN_NAMES = []string{
@@ -26,4 +40,61 @@ var TOKEN_NAMES = []string{
     "CHARACTER",
     "OPERATOR",
     "SEPARATOR",
+    "EOF",
+}
+
+type TriviaType int
+
+const (
+    TRIVIA_WHITESPACE TriviaType = iota
+    TRIVIA_NEWLINE
+    TRIVIA_LINE_COMMENT
+    TRIVIA_BLOCK_COMMENT
+)
+
+var TRIVIA_NAMES = []string{
+    "WHITESPACE",
+    "NEWLINE",
+    "LINE_COMMENT",
+    "BLOCK_COMMENT",
+}
+
+// Trivia is source text the parser skips: whitespace, a newline or a
+// comment.
+type Trivia struct {
+    Type     TriviaType
+    Content  string
+    Location Span
+}
+
+func (t Trivia) IsComment() bool {
+    return t.Type == TRIVIA_LINE_COMMENT || t.Type == TRIVIA_BLOCK_COMMENT
+}
+
+// Text is the token as it is written in the source.
+func (t *Token) Text() string {
+    switch t.Type {
+    case TOKEN_STRING:
+        return "\"" + t.Content + "\""
+    case TOKEN_CHARACTER:
+        return "'" + t.Content + "'"
+    }
+
+    return t.Content
+}
+
+// Source reproduces the text toks were lexed from, trivia included.
+func Source(toks []*Token) string {
+    var b strings.Builder
+    for _, tok := range toks {
+        for _, trivia := range tok.Leading {
+            b.WriteString(trivia.Content)
+        }
+        b.WriteString(tok.Text())
+        for _, trivia := range tok.Trailing {
+            b.WriteString(trivia.Content)
+        }
+    }
+
+    return b.String()
 }
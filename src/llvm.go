@@ -0,0 +1,41 @@
+// +build !nollvm
+
+package main
+
+import (
+    "os"
+
+    "github.com/k3v/lyca/src/codegen"
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/repl"
+)
+
+func (cfg *config) codegen() codegen.Options {
+    opts := cfg.options()
+    return codegen.Options{
+        OptLevel: opts.OptLevel,
+        SizeLevel: opts.SizeLevel,
+        Triple: opts.Triple,
+        CPU: opts.CPU,
+        Features: opts.Features,
+    }
+}
+
+// runJIT compiles the tree in memory and runs its main.
+func runJIT(tree *parser.AST, cfg *config, argv []string) (int, error) {
+    gen, err := codegen.Construct(tree, cfg.codegen())
+    if err != nil {
+        return 0, err
+    }
+    defer gen.Dispose()
+
+    if _, err := gen.Generate(); err != nil {
+        return 0, err
+    }
+
+    return gen.Run(argv)
+}
+
+func startSession(cfg *config) error {
+    return repl.Start(os.Stdin, os.Stdout, cfg.codegen())
+}
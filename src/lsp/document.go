@@ -0,0 +1,138 @@
+package lsp
+
+import (
+    "sort"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/sema"
+)
+
+type document struct {
+    uri   string
+    text  []rune
+    lines []int // offset of the first rune of every line
+
+    // tree and info describe the current text, nil when it does not
+    // parse. good is the last version that did, which symbols and
+    // completion fall back to while the user is typing.
+    tree *parser.AST
+    info *sema.Info
+    good *document
+
+    diags []Diagnostic
+}
+
+func newDocument(uri, text string, prev *document) *document {
+    d := &document{uri: uri, text: []rune(text), diags: []Diagnostic{}}
+    d.lines = []int{0}
+    for i, r := range d.text {
+        if r == '\n' {
+            d.lines = append(d.lines, i + 1)
+        }
+    }
+
+    d.analyze()
+    if d.tree != nil {
+        d.good = d
+    } else if prev != nil {
+        d.good = prev.good
+    }
+
+    return d
+}
+
+func (d *document) analyze() {
+    toks, err := lexer.Lex(lexer.NewFile([]byte(string(d.text))))
+    if err != nil {
+        d.report(err)
+        return
+    }
+
+    tree, err := parser.Parse(toks)
+    if err != nil {
+        d.report(err)
+        return
+    }
+
+    d.tree = tree
+    d.info, err = sema.Check(tree)
+    if err != nil {
+        d.report(err)
+    }
+}
+
+func (d *document) report(err error) {
+    diag := Diagnostic{Severity: SEVERITY_ERROR, Source: "lyca", Message: err.Error()}
+    if e, ok := err.(*lexer.Error); ok {
+        diag.Message = e.Message
+        diag.Range = d.span(e.Location)
+    }
+
+    d.diags = append(d.diags, diag)
+}
+
+// Spans are converted through their raw offsets, which count runes from
+// the start of the document, while positions count UTF-16 code units
+// from the start of their line, so a rune outside the Basic Multilingual
+// Plane takes up two characters.
+
+func (d *document) position(offset int) Position {
+    line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+    if line < 0 {
+        line = 0
+    }
+
+    character := 0
+    for _, r := range d.text[d.lines[line]:offset] {
+        character += units(r)
+    }
+    return Position{Line: line, Character: character}
+}
+
+func (d *document) span(span lexer.Span) Range {
+    return Range{Start: d.position(span.Start.Raw), End: d.position(span.End.Raw)}
+}
+
+// offset is the rune at pos, or the end of its line when pos is past it.
+// A position inside a surrogate pair moves to the rune after it.
+func (d *document) offset(pos Position) int {
+    if pos.Line >= len(d.lines) {
+        return len(d.text)
+    }
+
+    offset := d.lines[pos.Line]
+    for character := 0; character < pos.Character && offset < len(d.text) && d.text[offset] != '\n'; offset++ {
+        character += units(d.text[offset])
+    }
+    return offset
+}
+
+// units is the number of UTF-16 code units r is encoded in.
+func units(r rune) int {
+    if r >= 0x10000 {
+        return 2
+    }
+    return 1
+}
+
+func contains(span lexer.Span, offset int) bool {
+    return span.Start.Raw <= offset && offset <= span.End.Raw
+}
+
+// innermost returns the node with the smallest span containing offset.
+func innermost(nodes []parser.Node, offset int) parser.Node {
+    var best parser.Node
+    for _, node := range nodes {
+        span := node.Loc()
+        if !contains(span, offset) {
+            continue
+        }
+
+        if best == nil || span.End.Raw - span.Start.Raw < best.Loc().End.Raw - best.Loc().Start.Raw {
+            best = node
+        }
+    }
+
+    return best
+}
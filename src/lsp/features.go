@@ -0,0 +1,337 @@
+package lsp
+
+import (
+    "sort"
+    "strings"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/sema"
+)
+
+func (d *document) hover(offset int) *Hover {
+    if d.info == nil {
+        return nil
+    }
+
+    var nodes []parser.Node
+    for node := range d.info.Types {
+        nodes = append(nodes, node)
+    }
+
+    node := innermost(nodes, offset)
+    text := ""
+    if node != nil {
+        text = d.describe(node)
+    } else if decl, name := d.declarationAt(offset); decl != nil {
+        node, text = decl, name
+    }
+
+    if text == "" {
+        return nil
+    }
+
+    return &Hover{
+        Contents: markupContent{Kind: "markdown", Value: "```lyca\n" + text + "\n```"},
+        Range: d.span(node.Loc()),
+    }
+}
+
+// describe renders a node's type the way it would be declared.
+func (d *document) describe(node parser.Node) string {
+    t := d.info.TypeOf(node)
+
+    switch n := node.(type) {
+    case *parser.VarDeclNode:
+        return t.String() + " " + n.Name.Value
+    case *parser.VarAccessNode:
+        if fn, ok := t.(*sema.Func); ok {
+            return signature(n.Name.Value, fn)
+        }
+        return t.String() + " " + n.Name.Value
+    case *parser.ObjectAccessNode:
+        name := d.info.TypeOf(n.Object).String() + "." + n.Member.Value
+        if fn, ok := t.(*sema.Func); ok {
+            return signature(name, fn)
+        }
+        return t.String() + " " + name
+    }
+
+    return t.String()
+}
+
+// declarationAt finds the name of a template, function or method
+// declaration at offset.
+func (d *document) declarationAt(offset int) (parser.Node, string) {
+    for _, node := range d.tree.Nodes {
+        switch n := node.(type) {
+        case *parser.FuncDeclNode:
+            name := n.Function.Signature.Name
+            if contains(name.Loc, offset) {
+                return n, signature(name.Value, d.info.Functions[name.Value])
+            }
+        case *parser.TemplateNode:
+            if contains(n.Name.Loc, offset) {
+                return n, "tmpl " + n.Name.Value
+            }
+
+            tmpl := d.info.Templates[n.Name.Value]
+            for _, meth := range n.Methods {
+                name := meth.Function.Signature.Name
+                if contains(name.Loc, offset) && tmpl != nil {
+                    return meth, signature(n.Name.Value + "." + name.Value, tmpl.Methods[name.Value])
+                }
+            }
+        }
+    }
+
+    return nil, ""
+}
+
+// signature renders fn in Lyca's declaration syntax, with parameter
+// names when the function has a body.
+func signature(name string, fn *sema.Func) string {
+    if fn == nil {
+        return "func " + name
+    }
+
+    var params []string
+    for i, p := range fn.Params {
+        param := p.String()
+        if fn.Node != nil && i < len(fn.Node.Signature.Parameters) {
+            param += " " + fn.Node.Signature.Parameters[i].Name.Value
+        }
+        params = append(params, param)
+    }
+    if fn.Variadic {
+        params = append(params, "...")
+    }
+
+    ret := ""
+    if fn.Return != nil && fn.Return != sema.Void {
+        ret = fn.Return.String()
+    }
+
+    return "func (" + strings.Join(params, ", ") + ") > " + name + " > (" + ret + ")"
+}
+
+func (d *document) definition(offset int) *Location {
+    if d.info == nil {
+        return nil
+    }
+
+    var nodes []parser.Node
+    for node := range d.info.Defs {
+        nodes = append(nodes, node)
+    }
+
+    node := innermost(nodes, offset)
+    if node == nil {
+        return nil
+    }
+
+    return &Location{URI: d.uri, Range: d.span(nameSpan(d.info.Defs[node]))}
+}
+
+// nameSpan is where a declaration names what it declares.
+func nameSpan(node parser.Node) lexer.Span {
+    var name parser.Identifier
+    switch n := node.(type) {
+    case *parser.VarDeclNode:
+        name = n.Name
+    case *parser.FuncNode:
+        name = n.Signature.Name
+    case *parser.TemplateNode:
+        name = n.Name
+    }
+
+    if name.Loc.End.Raw > name.Loc.Start.Raw {
+        return name.Loc
+    }
+    return node.Loc()
+}
+
+// symbols lists the templates and functions of the last version of the
+// document that parsed.
+func (d *document) symbols() []DocumentSymbol {
+    g := d.good
+    syms := []DocumentSymbol{}
+    if g == nil {
+        return syms
+    }
+
+    for _, node := range g.tree.Nodes {
+        switch n := node.(type) {
+        case *parser.FuncDeclNode:
+            name := n.Function.Signature.Name
+            syms = append(syms, g.symbol(n, name, SYMBOL_FUNCTION, funcDetail(g.info.Functions[name.Value])))
+        case *parser.TemplateNode:
+            sym := g.symbol(n, n.Name, SYMBOL_CLASS, "")
+            for _, v := range n.Variables {
+                sym.Children = append(sym.Children, g.symbol(v, v.Name, SYMBOL_FIELD, g.info.TypeOf(v).String()))
+            }
+
+            tmpl := g.info.Templates[n.Name.Value]
+            if n.Constructor != nil {
+                var fn *sema.Func
+                if tmpl != nil {
+                    fn = tmpl.Constructor
+                }
+                ctor := parser.Identifier{Value: "constructor", Loc: n.Constructor.Loc()}
+                sym.Children = append(sym.Children, g.symbol(n.Constructor, ctor, SYMBOL_CONSTRUCTOR, funcDetail(fn)))
+            }
+
+            for _, meth := range n.Methods {
+                name := meth.Function.Signature.Name
+                var fn *sema.Func
+                if tmpl != nil {
+                    fn = tmpl.Methods[name.Value]
+                }
+                sym.Children = append(sym.Children, g.symbol(meth, name, SYMBOL_METHOD, funcDetail(fn)))
+            }
+            syms = append(syms, sym)
+        case *parser.TestNode:
+            detail := parser.KEYWORD_TEST
+            if n.Bench {
+                detail = parser.KEYWORD_BENCH
+            }
+            syms = append(syms, g.symbol(n, n.Name, SYMBOL_FUNCTION, detail))
+        }
+    }
+
+    return syms
+}
+
+func (d *document) symbol(node parser.Node, name parser.Identifier, kind int, detail string) DocumentSymbol {
+    return DocumentSymbol{
+        Name: name.Value,
+        Detail: detail,
+        Kind: kind,
+        Range: d.span(node.Loc()),
+        SelectionRange: d.span(name.Loc),
+    }
+}
+
+func funcDetail(fn *sema.Func) string {
+    if fn == nil {
+        return ""
+    }
+    return fn.String()
+}
+
+// completion lists the members of the template before a '.', found by
+// resolving the chain of names before it, such as this.head.next,
+// against the last version of the document that parsed.
+func (d *document) completion(offset int) []CompletionItem {
+    items := []CompletionItem{}
+    g := d.good
+    if g == nil || g.info == nil {
+        return items
+    }
+
+    start := offset
+    for start > 0 && isIdentRune(d.text[start-1]) {
+        start--
+    }
+    prefix := string(d.text[start:offset])
+    if start == 0 || d.text[start-1] != '.' {
+        return items
+    }
+
+    var chain []string
+    for i := start - 1; ; {
+        end := i
+        for i > 0 && isIdentRune(d.text[i-1]) {
+            i--
+        }
+        if i == end {
+            return items
+        }
+
+        chain = append([]string{string(d.text[i:end])}, chain...)
+        if i == 0 || d.text[i-1] != '.' {
+            break
+        }
+        i--
+    }
+
+    t := g.resolve(chain[0], offset)
+    for _, member := range chain[1:] {
+        tmpl, ok := t.(*sema.Template)
+        if !ok {
+            return items
+        }
+
+        field, _ := tmpl.Field(member)
+        if field == nil {
+            return items
+        }
+        t = field.Type
+    }
+
+    tmpl, ok := t.(*sema.Template)
+    if !ok {
+        return items
+    }
+
+    for _, field := range tmpl.Fields {
+        if strings.HasPrefix(field.Name, prefix) {
+            items = append(items, CompletionItem{Label: field.Name, Kind: COMPLETION_FIELD, Detail: field.Type.String()})
+        }
+    }
+
+    var meths []string
+    for name := range tmpl.Methods {
+        meths = append(meths, name)
+    }
+    sort.Strings(meths)
+    for _, name := range meths {
+        if strings.HasPrefix(name, prefix) {
+            items = append(items, CompletionItem{Label: name, Kind: COMPLETION_METHOD, Detail: tmpl.Methods[name].String()})
+        }
+    }
+
+    return items
+}
+
+// resolve finds the type of the variable name visible at offset: the
+// closest declaration before it in the enclosing top level declaration,
+// or a global. this is the enclosing template.
+func (d *document) resolve(name string, offset int) sema.Type {
+    var enclosing parser.Node
+    for _, node := range d.tree.Nodes {
+        if node.Loc().Start.Raw <= offset {
+            enclosing = node
+        }
+    }
+
+    if name == "this" {
+        if tmpl, ok := enclosing.(*parser.TemplateNode); ok {
+            return d.info.Templates[tmpl.Name.Value]
+        }
+        return nil
+    }
+
+    var decl *parser.VarDeclNode
+    if enclosing != nil {
+        parser.Inspect(enclosing, func(node parser.Node) bool {
+            if v, ok := node.(*parser.VarDeclNode); ok && v.Name.Value == name && v.Loc().Start.Raw <= offset {
+                decl = v
+            }
+            return node == nil || node.Loc().Start.Raw <= offset
+        })
+    }
+
+    if decl == nil {
+        decl = d.info.Globals[name]
+    }
+    if decl == nil {
+        return nil
+    }
+
+    return d.info.TypeOf(decl)
+}
+
+func isIdentRune(r rune) bool {
+    return r == '_' || lexer.IsLetter(r) || lexer.IsDecimal(r)
+}
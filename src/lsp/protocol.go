@@ -0,0 +1,166 @@
+package lsp
+
+import (
+    "encoding/json"
+)
+
+// The subset of the Language Server Protocol the server speaks.
+
+const (
+    PARSE_ERROR      = -32700
+    INVALID_REQUEST  = -32600
+    METHOD_NOT_FOUND = -32601
+    INVALID_PARAMS   = -32602
+)
+
+const (
+    SYNC_FULL = 1
+
+    SEVERITY_ERROR = 1
+
+    SYMBOL_CLASS       = 5
+    SYMBOL_METHOD      = 6
+    SYMBOL_FIELD       = 8
+    SYMBOL_CONSTRUCTOR = 9
+    SYMBOL_FUNCTION    = 12
+
+    COMPLETION_METHOD = 2
+    COMPLETION_FIELD  = 5
+)
+
+type message struct {
+    JSONRPC string           `json:"jsonrpc"`
+    ID      *json.RawMessage `json:"id,omitempty"`
+    Method  string           `json:"method,omitempty"`
+    Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+// A response carries either a result, which may be null, or an error.
+type response struct {
+    JSONRPC string           `json:"jsonrpc"`
+    ID      *json.RawMessage `json:"id"`
+    Result  interface{}      `json:"result"`
+}
+
+type errorResponse struct {
+    JSONRPC string           `json:"jsonrpc"`
+    ID      *json.RawMessage `json:"id"`
+    Error   *responseError   `json:"error"`
+}
+
+type responseError struct {
+    Code    int    `json:"code"`
+    Message string `json:"message"`
+}
+
+type notification struct {
+    JSONRPC string      `json:"jsonrpc"`
+    Method  string      `json:"method"`
+    Params  interface{} `json:"params"`
+}
+
+// Position counts lines and characters from zero. Characters are UTF-16
+// code units, the encoding LSP positions use by default.
+type Position struct {
+    Line      int `json:"line"`
+    Character int `json:"character"`
+}
+
+type Range struct {
+    Start Position `json:"start"`
+    End   Position `json:"end"`
+}
+
+type Location struct {
+    URI   string `json:"uri"`
+    Range Range  `json:"range"`
+}
+
+type Diagnostic struct {
+    Range    Range  `json:"range"`
+    Severity int    `json:"severity"`
+    Source   string `json:"source"`
+    Message  string `json:"message"`
+}
+
+type publishDiagnosticsParams struct {
+    URI         string       `json:"uri"`
+    Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+type textDocumentItem struct {
+    URI  string `json:"uri"`
+    Text string `json:"text"`
+}
+
+type textDocumentIdentifier struct {
+    URI string `json:"uri"`
+}
+
+type didOpenParams struct {
+    TextDocument textDocumentItem `json:"textDocument"`
+}
+
+type didChangeParams struct {
+    TextDocument   textDocumentIdentifier `json:"textDocument"`
+    ContentChanges []struct {
+        Text string `json:"text"`
+    } `json:"contentChanges"`
+}
+
+type didCloseParams struct {
+    TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type textDocumentPositionParams struct {
+    TextDocument textDocumentIdentifier `json:"textDocument"`
+    Position     Position               `json:"position"`
+}
+
+type documentSymbolParams struct {
+    TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type initializeResult struct {
+    Capabilities serverCapabilities `json:"capabilities"`
+    ServerInfo   struct {
+        Name string `json:"name"`
+    } `json:"serverInfo"`
+}
+
+type serverCapabilities struct {
+    TextDocumentSync       int               `json:"textDocumentSync"`
+    HoverProvider          bool              `json:"hoverProvider"`
+    DefinitionProvider     bool              `json:"definitionProvider"`
+    DocumentSymbolProvider bool              `json:"documentSymbolProvider"`
+    CompletionProvider     completionOptions `json:"completionProvider"`
+}
+
+type completionOptions struct {
+    TriggerCharacters []string `json:"triggerCharacters"`
+}
+
+type Hover struct {
+    Contents markupContent `json:"contents"`
+    Range    Range         `json:"range"`
+}
+
+type markupContent struct {
+    Kind  string `json:"kind"`
+    Value string `json:"value"`
+}
+
+type DocumentSymbol struct {
+    Name           string           `json:"name"`
+    Detail         string           `json:"detail,omitempty"`
+    Kind           int              `json:"kind"`
+    Range          Range            `json:"range"`
+    SelectionRange Range            `json:"selectionRange"`
+    Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+type CompletionItem struct {
+    Label  string `json:"label"`
+    Kind   int    `json:"kind"`
+    Detail string `json:"detail,omitempty"`
+}
@@ -0,0 +1,213 @@
+// Package lsp is a Language Server Protocol server for Lyca over stdio.
+// Every open document is lexed, parsed and type checked on each change,
+// and the results answer diagnostics, hover, go-to-definition, document
+// symbol and completion requests.
+package lsp
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/textproto"
+    "strconv"
+    "strings"
+)
+
+type Server struct {
+    in   *bufio.Reader
+    out  io.Writer
+    docs map[string]*document
+
+    shutdown bool
+}
+
+// Serve answers requests read from r on w until the client sends exit.
+func Serve(r io.Reader, w io.Writer) error {
+    s := &Server{in: bufio.NewReader(r), out: w, docs: map[string]*document{}}
+    for {
+        msg, err := s.read()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+
+        if msg.Method == "exit" {
+            if !s.shutdown {
+                return fmt.Errorf("exit before shutdown")
+            }
+            return nil
+        }
+
+        if err := s.handle(msg); err != nil {
+            return err
+        }
+    }
+}
+
+// read reads one message framed by a Content-Length header.
+func (s *Server) read() (*message, error) {
+    header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+    if err != nil {
+        return nil, err
+    }
+
+    length, err := strconv.Atoi(header.Get("Content-Length"))
+    if err != nil {
+        return nil, fmt.Errorf("bad Content-Length %q", header.Get("Content-Length"))
+    }
+
+    body := make([]byte, length)
+    if _, err := io.ReadFull(s.in, body); err != nil {
+        return nil, err
+    }
+
+    msg := &message{}
+    if err := json.Unmarshal(body, msg); err != nil {
+        return msg, s.reply(nil, nil, &responseError{PARSE_ERROR, err.Error()})
+    }
+    return msg, nil
+}
+
+func (s *Server) write(v interface{}) error {
+    body, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+
+    _, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+    return err
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, e *responseError) error {
+    if e != nil {
+        return s.write(&errorResponse{JSONRPC: "2.0", ID: id, Error: e})
+    }
+    return s.write(&response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+    return s.write(&notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) handle(msg *message) error {
+    if msg.Method == "" {
+        return nil
+    }
+
+    result, e := s.dispatch(msg)
+    if msg.ID == nil {
+        // notifications get no response, not even for errors
+        return nil
+    }
+
+    return s.reply(msg.ID, result, e)
+}
+
+func (s *Server) dispatch(msg *message) (interface{}, *responseError) {
+    decode := func(v interface{}) *responseError {
+        if err := json.Unmarshal(msg.Params, v); err != nil {
+            return &responseError{INVALID_PARAMS, err.Error()}
+        }
+        return nil
+    }
+
+    switch msg.Method {
+    case "initialize":
+        res := initializeResult{}
+        res.ServerInfo.Name = "lyca"
+        res.Capabilities = serverCapabilities{
+            TextDocumentSync: SYNC_FULL,
+            HoverProvider: true,
+            DefinitionProvider: true,
+            DocumentSymbolProvider: true,
+            CompletionProvider: completionOptions{TriggerCharacters: []string{"."}},
+        }
+        return res, nil
+    case "initialized":
+        return nil, nil
+    case "shutdown":
+        s.shutdown = true
+        return nil, nil
+
+    case "textDocument/didOpen":
+        var params didOpenParams
+        if e := decode(&params); e != nil {
+            return nil, e
+        }
+        return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+    case "textDocument/didChange":
+        var params didChangeParams
+        if e := decode(&params); e != nil {
+            return nil, e
+        }
+        changes := params.ContentChanges
+        if len(changes) == 0 {
+            return nil, nil
+        }
+        return nil, s.update(params.TextDocument.URI, changes[len(changes)-1].Text)
+    case "textDocument/didClose":
+        var params didCloseParams
+        if e := decode(&params); e != nil {
+            return nil, e
+        }
+        delete(s.docs, params.TextDocument.URI)
+        if err := s.publish(params.TextDocument.URI, []Diagnostic{}); err != nil {
+            return nil, &responseError{INVALID_REQUEST, err.Error()}
+        }
+        return nil, nil
+
+    case "textDocument/hover", "textDocument/definition", "textDocument/completion":
+        var params textDocumentPositionParams
+        if e := decode(&params); e != nil {
+            return nil, e
+        }
+        doc := s.docs[params.TextDocument.URI]
+        if doc == nil {
+            return nil, nil
+        }
+
+        pos := doc.offset(params.Position)
+        switch msg.Method {
+        case "textDocument/hover":
+            return doc.hover(pos), nil
+        case "textDocument/definition":
+            return doc.definition(pos), nil
+        default:
+            return doc.completion(pos), nil
+        }
+    case "textDocument/documentSymbol":
+        var params documentSymbolParams
+        if e := decode(&params); e != nil {
+            return nil, e
+        }
+        doc := s.docs[params.TextDocument.URI]
+        if doc == nil {
+            return []DocumentSymbol{}, nil
+        }
+        return doc.symbols(), nil
+    }
+
+    if strings.HasPrefix(msg.Method, "$/") {
+        return nil, nil
+    }
+    return nil, &responseError{METHOD_NOT_FOUND, "unsupported method " + msg.Method}
+}
+
+// update reanalyzes a document after it changed and publishes its
+// diagnostics.
+func (s *Server) update(uri, text string) *responseError {
+    doc := newDocument(uri, text, s.docs[uri])
+    s.docs[uri] = doc
+
+    if err := s.publish(uri, doc.diags); err != nil {
+        return &responseError{INVALID_REQUEST, err.Error()}
+    }
+    return nil
+}
+
+func (s *Server) publish(uri string, diags []Diagnostic) error {
+    return s.notify("textDocument/publishDiagnostics", &publishDiagnosticsParams{URI: uri, Diagnostics: diags})
+}
@@ -0,0 +1,384 @@
+package lsp
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/textproto"
+    "reflect"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+const URI = "file:///point.lyca"
+
+// POINT is the document the tests open. Positions below count lines and
+// characters from zero.
+var POINT = strings.TrimPrefix(`
+tmpl Point {
+    int x;
+
+    constructor < (int x) {
+        this.x = x;
+    }
+
+    func () > norm > (int) {
+        return this.x;
+    }
+}
+
+func (Point p) > get > (int) {
+    return p.x;
+}
+
+func () > main > (int) {
+    Point p = make Point < (1);
+    return get(p);
+}
+`, "\n")
+
+// client talks to a Server over in-memory pipes, the way an editor does
+// over stdio.
+type client struct {
+    t    *testing.T
+    in   *io.PipeWriter
+    out  *bufio.Reader
+    id   int
+    done chan error
+}
+
+// incoming is any message the server sends.
+type incoming struct {
+    ID     *int            `json:"id"`
+    Method string          `json:"method"`
+    Params json.RawMessage `json:"params"`
+    Result json.RawMessage `json:"result"`
+    Error  *responseError  `json:"error"`
+}
+
+func start(t *testing.T) *client {
+    inR, inW := io.Pipe()
+    outR, outW := io.Pipe()
+
+    c := &client{t: t, in: inW, out: bufio.NewReader(outR), done: make(chan error, 1)}
+    go func() {
+        err := Serve(inR, outW)
+        outW.Close()
+        c.done <- err
+    }()
+
+    c.request("initialize", struct{}{}, nil)
+    c.notify("initialized", struct{}{})
+    return c
+}
+
+func (c *client) send(v interface{}) {
+    body, err := json.Marshal(v)
+    if err != nil {
+        c.t.Fatal(err)
+    }
+    if _, err := fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+        c.t.Fatal(err)
+    }
+}
+
+func (c *client) receive() *incoming {
+    header, err := textproto.NewReader(c.out).ReadMIMEHeader()
+    if err != nil {
+        c.t.Fatal(err)
+    }
+    length, err := strconv.Atoi(header.Get("Content-Length"))
+    if err != nil {
+        c.t.Fatal(err)
+    }
+
+    body := make([]byte, length)
+    if _, err := io.ReadFull(c.out, body); err != nil {
+        c.t.Fatal(err)
+    }
+
+    msg := &incoming{}
+    if err := json.Unmarshal(body, msg); err != nil {
+        c.t.Fatal(err)
+    }
+    return msg
+}
+
+func (c *client) notify(method string, params interface{}) {
+    c.send(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// request sends a request and decodes the result of its response into
+// result. It returns the error the server answered with, if any.
+func (c *client) request(method string, params, result interface{}) *responseError {
+    c.id++
+    c.send(map[string]interface{}{"jsonrpc": "2.0", "id": c.id, "method": method, "params": params})
+
+    msg := c.receive()
+    if msg.ID == nil || *msg.ID != c.id {
+        c.t.Fatalf("%s: got %+v, want the response to request %d", method, msg, c.id)
+    }
+    if msg.Error != nil {
+        return msg.Error
+    }
+    if result != nil {
+        if err := json.Unmarshal(msg.Result, result); err != nil {
+            c.t.Fatal(err)
+        }
+    }
+    return nil
+}
+
+// diagnostics reads the publishDiagnostics notification that follows
+// opening, changing or closing a document.
+func (c *client) diagnostics() []Diagnostic {
+    msg := c.receive()
+    if msg.Method != "textDocument/publishDiagnostics" {
+        c.t.Fatalf("got %+v, want diagnostics", msg)
+    }
+
+    var params publishDiagnosticsParams
+    if err := json.Unmarshal(msg.Params, &params); err != nil {
+        c.t.Fatal(err)
+    }
+    if params.URI != URI {
+        c.t.Errorf("got diagnostics for %s", params.URI)
+    }
+    return params.Diagnostics
+}
+
+func (c *client) open(text string) []Diagnostic {
+    c.notify("textDocument/didOpen", map[string]interface{}{
+        "textDocument": map[string]string{"uri": URI, "text": text},
+    })
+    return c.diagnostics()
+}
+
+func (c *client) change(text string) []Diagnostic {
+    c.notify("textDocument/didChange", map[string]interface{}{
+        "textDocument": map[string]string{"uri": URI},
+        "contentChanges": []map[string]string{{"text": text}},
+    })
+    return c.diagnostics()
+}
+
+func at(line, character int) textDocumentPositionParams {
+    return textDocumentPositionParams{textDocumentIdentifier{URI}, Position{line, character}}
+}
+
+func (c *client) stop() {
+    if e := c.request("shutdown", nil, nil); e != nil {
+        c.t.Fatal(e.Message)
+    }
+    c.notify("exit", nil)
+    c.in.Close()
+    if err := <-c.done; err != nil {
+        c.t.Errorf("Serve: %v", err)
+    }
+}
+
+func TestDiagnostics(t *testing.T) {
+    c := start(t)
+    defer c.stop()
+
+    if diags := c.open(POINT); len(diags) != 0 {
+        t.Errorf("got diagnostics %+v for a correct document", diags)
+    }
+
+    broken := strings.Replace(POINT, "return p.x;", "return p.y;", 1)
+    want := []Diagnostic{{
+        Range: Range{Position{13, 11}, Position{13, 14}},
+        Severity: SEVERITY_ERROR,
+        Source: "lyca",
+        Message: "Undefined member y of Point",
+    }}
+    if diags := c.change(broken); !reflect.DeepEqual(diags, want) {
+        t.Errorf("got diagnostics %+v, want %+v", diags, want)
+    }
+
+    if diags := c.change(POINT); len(diags) != 0 {
+        t.Errorf("got diagnostics %+v after the fix", diags)
+    }
+
+    c.notify("textDocument/didClose", map[string]interface{}{"textDocument": map[string]string{"uri": URI}})
+    if diags := c.diagnostics(); len(diags) != 0 {
+        t.Errorf("got diagnostics %+v for a closed document", diags)
+    }
+}
+
+func TestHover(t *testing.T) {
+    c := start(t)
+    defer c.stop()
+    c.open(POINT)
+
+    tests := []struct {
+        name string
+        pos  textDocumentPositionParams
+        want string
+    }{
+        {"call", at(18, 12), "func (Point p) > get > (int)"},
+        {"parameter", at(18, 15), "Point p"},
+        {"member", at(13, 13), "int Point.x"},
+        {"method declaration", at(7, 15), "func () > Point.norm > (int)"},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            var hover *Hover
+            c.request("textDocument/hover", test.pos, &hover)
+            if hover == nil {
+                t.Fatal("no hover")
+            }
+            if want := "```lyca\n" + test.want + "\n```"; hover.Contents.Value != want {
+                t.Errorf("got %q, want %q", hover.Contents.Value, want)
+            }
+        })
+    }
+
+    var hover *Hover
+    c.request("textDocument/hover", at(11, 0), &hover)
+    if hover != nil {
+        t.Errorf("got %+v on an empty line", hover)
+    }
+}
+
+func TestDefinition(t *testing.T) {
+    c := start(t)
+    defer c.stop()
+    c.open(POINT)
+
+    tests := []struct {
+        name string
+        pos  textDocumentPositionParams
+        want Range
+    }{
+        {"function", at(18, 12), Range{Position{12, 17}, Position{12, 20}}},
+        {"local", at(18, 15), Range{Position{17, 10}, Position{17, 11}}},
+        {"template", at(17, 20), Range{Position{0, 5}, Position{0, 10}}},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            var loc *Location
+            c.request("textDocument/definition", test.pos, &loc)
+            if loc == nil {
+                t.Fatal("no definition")
+            }
+            if loc.URI != URI || loc.Range != test.want {
+                t.Errorf("got %+v, want %+v", *loc, test.want)
+            }
+        })
+    }
+}
+
+func TestDocumentSymbol(t *testing.T) {
+    c := start(t)
+    defer c.stop()
+    c.open(POINT)
+
+    var syms []DocumentSymbol
+    c.request("textDocument/documentSymbol", documentSymbolParams{textDocumentIdentifier{URI}}, &syms)
+
+    var got []string
+    for _, sym := range syms {
+        got = append(got, fmt.Sprintf("%d %s", sym.Kind, sym.Name))
+        for _, child := range sym.Children {
+            got = append(got, fmt.Sprintf("  %d %s %s", child.Kind, child.Name, child.Detail))
+        }
+    }
+
+    want := []string{
+        fmt.Sprintf("%d Point", SYMBOL_CLASS),
+        fmt.Sprintf("  %d x int", SYMBOL_FIELD),
+        fmt.Sprintf("  %d constructor func (int) > ()", SYMBOL_CONSTRUCTOR),
+        fmt.Sprintf("  %d norm func () > (int)", SYMBOL_METHOD),
+        fmt.Sprintf("%d get", SYMBOL_FUNCTION),
+        fmt.Sprintf("%d main", SYMBOL_FUNCTION),
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("got symbols\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+    }
+}
+
+// Completion works on the text being typed, which does not parse, from
+// the last version that did.
+func TestMemberCompletion(t *testing.T) {
+    c := start(t)
+    defer c.stop()
+    c.open(POINT)
+
+    tests := []struct {
+        name string
+        line string
+        want []string
+    }{
+        {"after a dot", "    p.", []string{"x", "norm"}},
+        {"with a prefix", "    p.n", []string{"norm"}},
+        {"not a template", "    q.", nil},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            typing := strings.Replace(POINT, "    return get(p);", test.line, 1)
+            if diags := c.change(typing); len(diags) == 0 {
+                t.Fatal("incomplete text has no diagnostics")
+            }
+
+            var items []CompletionItem
+            c.request("textDocument/completion", at(18, len(test.line)), &items)
+
+            var got []string
+            for _, item := range items {
+                got = append(got, item.Label)
+            }
+            if !reflect.DeepEqual(got, test.want) {
+                t.Errorf("got %v, want %v", got, test.want)
+            }
+        })
+    }
+}
+
+func TestUnknownMethod(t *testing.T) {
+    c := start(t)
+    defer c.stop()
+
+    e := c.request("textDocument/rename", at(0, 0), nil)
+    if e == nil || e.Code != METHOD_NOT_FOUND {
+        t.Errorf("got %+v, want method not found", e)
+    }
+}
+
+// The emoji is two UTF-16 code units, which is what LSP characters
+// count.
+const EMOJI = `func (int x) > get > (int) {
+    return x;
+}
+
+func () > main > (int) {
+    string s = "😀"; return get(%s);
+}
+`
+
+func TestUTF16Positions(t *testing.T) {
+    c := start(t)
+    defer c.stop()
+
+    want := []Diagnostic{{
+        Range: Range{Position{5, 32}, Position{5, 33}},
+        Severity: SEVERITY_ERROR,
+        Source: "lyca",
+        Message: "Cannot use string as int",
+    }}
+    if diags := c.open(fmt.Sprintf(EMOJI, "s")); !reflect.DeepEqual(diags, want) {
+        t.Errorf("got diagnostics %+v, want %+v", diags, want)
+    }
+
+    c.change(fmt.Sprintf(EMOJI, "2"))
+    var loc *Location
+    c.request("textDocument/definition", at(5, 29), &loc)
+    if want := (Range{Position{0, 15}, Position{0, 18}}); loc == nil || loc.Range != want {
+        t.Errorf("got definition %+v, want %+v", loc, want)
+    }
+}
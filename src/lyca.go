@@ -2,55 +2,844 @@ package main
 
 import (
     "os"
-    "log"
+    "bytes"
+    "fmt"
+    "flag"
     "strings"
+    "io/ioutil"
     "os/exec"
     "path/filepath"
+    "regexp"
+    "time"
 
+    "github.com/k3v/lyca/src/lyca"
+    "github.com/k3v/lyca/src/format"
+    "github.com/k3v/lyca/src/doc"
     "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/interp"
+    "github.com/k3v/lyca/src/lsp"
     "github.com/k3v/lyca/src/parser"
-    "github.com/k3v/lyca/src/codegen"
+    "github.com/k3v/lyca/src/project"
+    "github.com/k3v/lyca/src/vm"
 )
 
+const (
+    EXIT_OK    = 0
+    EXIT_ERROR = 1 // compile errors or a failed step
+    EXIT_USAGE = 2 // bad command line
+)
+
+type command struct {
+    name  string
+    args  string
+    short string
+    run   func(cmd *command, args []string) int
+}
+
+var COMMANDS []*command
+
+func init() {
+    COMMANDS = []*command{
+        {"build", "[flags] [files... | dir]", "compile files, or the package in dir, into an executable", build},
+        {"run", "[flags] file [arguments...]", "compile and run a file", run},
+        {"check", "[files... | dir]", "report errors without generating code", check},
+        {"fmt", "[flags] [files... | dirs...]", "format source files", reformat},
+        {"test", "[flags] [files... | dir]", "run the test blocks", test},
+        {"dump", "[flags] [files... | dir]", "print tokens, the AST or generated code", dump},
+        {"doc", "[flags] [files... | dir]", "render documentation from doc comments", document},
+        {"repl", "[flags]", "start an interactive session", startRepl},
+        {"lsp", "", "run a language server on stdin and stdout", serveLSP},
+        {"help", "[command]", "show help for a command", help},
+    }
+}
+
+// EMITS maps --emit values to what the compiler produces.
+var EMITS = map[string]lyca.Emit{
+    "tokens": lyca.EmitTokens,
+    "ast": lyca.EmitAST,
+    "ll": lyca.EmitIR,
+    "bc": lyca.EmitBitcode,
+    "asm": lyca.EmitAssembly,
+    "obj": lyca.EmitObject,
+    "exe": lyca.EmitExecutable,
+    "c": lyca.EmitC,
+    "bytecode": lyca.EmitBytecode,
+    "cfg": lyca.EmitCFG,
+}
+
+// FORMATS maps --format values to how tokens and syntax trees print.
+var FORMATS = map[string]lyca.Format{
+    "text": lyca.FormatText,
+    "json": lyca.FormatJSON,
+    "sexp": lyca.FormatSexp,
+    "dot": lyca.FormatDOT,
+}
+
+var EXTENSIONS = map[lyca.Emit]string{
+    lyca.EmitTokens: ".tokens",
+    lyca.EmitAST: ".ast",
+    lyca.EmitIR: ".ll",
+    lyca.EmitBitcode: ".bc",
+    lyca.EmitAssembly: ".s",
+    lyca.EmitObject: ".o",
+    lyca.EmitExecutable: "",
+    lyca.EmitC: ".c",
+    lyca.EmitBytecode: ".lbc",
+    lyca.EmitCFG: ".dot",
+}
+
 func main() {
-    path := os.Args[1];
-    name := filepath.Base(path)
-    strip := strings.Split(name, ".")[0]
+    os.Exit(dispatch(os.Args[1:]))
+}
+
+func dispatch(args []string) int {
+    if len(args) == 0 {
+        usage(os.Stderr)
+        return EXIT_USAGE
+    }
+
+    switch args[0] {
+    case "-h", "-help", "--help":
+        usage(os.Stdout)
+        return EXIT_OK
+    }
+
+    cmd := lookup(args[0])
+    if cmd == nil {
+        fmt.Fprintf(os.Stderr, "lyca: unknown command %q\n\n", args[0])
+        usage(os.Stderr)
+        return EXIT_USAGE
+    }
+
+    return cmd.run(cmd, args[1:])
+}
+
+func lookup(name string) *command {
+    for _, cmd := range COMMANDS {
+        if cmd.name == name {
+            return cmd
+        }
+    }
+
+    return nil
+}
+
+func usage(w *os.File) {
+    fmt.Fprintln(w, "usage: lyca <command> [arguments]")
+    fmt.Fprintln(w)
+    fmt.Fprintln(w, "commands:")
+    for _, cmd := range COMMANDS {
+        fmt.Fprintf(w, "    %-8s %s\n", cmd.name, cmd.short)
+    }
+    fmt.Fprintln(w)
+    fmt.Fprintln(w, "Run 'lyca help <command>' for the flags of a command.")
+}
+
+func help(cmd *command, args []string) int {
+    if len(args) == 0 {
+        usage(os.Stdout)
+        return EXIT_OK
+    }
+
+    target := lookup(args[0])
+    if target == nil {
+        fmt.Fprintf(os.Stderr, "lyca: unknown command %q\n", args[0])
+        return EXIT_USAGE
+    }
+
+    return target.run(target, []string{"--help"})
+}
+
+// flagSet returns the command's flags; its usage prints the command line
+// and every flag registered on it.
+func (cmd *command) flagSet() *flag.FlagSet {
+    fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+    fs.Usage = func() {
+        fmt.Fprintf(fs.Output(), "usage: lyca %s %s\n\n%s.\n", cmd.name, cmd.args, strings.ToUpper(cmd.short[:1]) + cmd.short[1:])
+
+        empty := true
+        fs.VisitAll(func(*flag.Flag) { empty = false })
+        if !empty {
+            fmt.Fprintln(fs.Output(), "\nflags:")
+            fs.PrintDefaults()
+        }
+    }
+
+    return fs
+}
+
+// parseFlags parses args, returning false and the exit code when the
+// command should stop: after --help or a bad flag.
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+    fs.SetOutput(os.Stderr)
+    for _, arg := range args {
+        if arg == "-h" || arg == "-help" || arg == "--help" {
+            fs.SetOutput(os.Stdout)
+            break
+        }
+    }
+
+    err := fs.Parse(args)
+    if err == flag.ErrHelp {
+        return EXIT_OK, false
+    }
+    if err != nil {
+        return EXIT_USAGE, false
+    }
+    return EXIT_OK, true
+}
+
+// config holds the flags shared by the compiling commands.
+type config struct {
+    output    string
+    emit      string
+    keepTemps bool
+
+    // dump only
+    tokens bool
+    ast    bool
+    cfg    bool
+    format string
+
+    backend string
+    jobs    int
+    cache   string
+
+    opt      [4]bool
+    size     bool
+    target   string
+    cpu      string
+    features string
+}
+
+func (cfg *config) codegenFlags(fs *flag.FlagSet) {
+    fs.BoolVar(&cfg.opt[0], "O0", false, "disable optimizations")
+    fs.BoolVar(&cfg.opt[1], "O1", false, "enable basic optimizations")
+    fs.BoolVar(&cfg.opt[2], "O2", false, "enable most optimizations")
+    fs.BoolVar(&cfg.opt[3], "O3", false, "enable aggressive optimizations")
+    fs.BoolVar(&cfg.size, "Os", false, "optimize for code size")
+
+    fs.StringVar(&cfg.target, "target", "", "target triple to generate code for")
+    fs.StringVar(&cfg.cpu, "cpu", "", "target CPU to generate code for")
+    fs.StringVar(&cfg.features, "features", "", "comma separated target features, e.g. +sse4.2,-avx")
+}
+
+func (cfg *config) compileFlags(fs *flag.FlagSet, emit, output string) {
+    fs.StringVar(&cfg.output, "o", output, "output path, - for stdout")
+    fs.StringVar(&cfg.emit, "emit", emit, "what to produce: tokens, ast, ll, bc, asm, obj, exe, c, bytecode or cfg")
+    fs.BoolVar(&cfg.keepTemps, "keep-temps", false, "keep temporary files and print where they are")
+    fs.StringVar(&cfg.backend, "backend", "llvm", "code generator to build executables with, llvm or c")
+    fs.IntVar(&cfg.jobs, "j", 0, "number of files to compile in parallel, defaults to the number of CPUs")
+    fs.StringVar(&cfg.cache, "cache", defaultCacheDir(), "directory caching the object file of every source file, empty to disable")
+    cfg.codegenFlags(fs)
+}
+
+func defaultCacheDir() string {
+    dir, err := os.UserCacheDir()
+    if err != nil {
+        return ""
+    }
+    return filepath.Join(dir, "lyca")
+}
+
+func (cfg *config) options() lyca.Options {
+    opts := lyca.Options{
+        Emit: EMITS[cfg.emit],
+        Format: FORMATS[cfg.format],
+        Triple: cfg.target,
+        CPU: cfg.cpu,
+        Features: cfg.features,
+        Jobs: cfg.jobs,
+        CacheDir: cfg.cache,
+        KeepTemps: cfg.keepTemps,
+    }
+    for level, set := range cfg.opt {
+        if set {
+            opts.OptLevel = level
+        }
+    }
 
-    f, err := os.Open(path); if err != nil {
-        log.Fatal(err)
+    if cfg.size {
+        opts.OptLevel = 2
+        opts.SizeLevel = 1
     }
 
-    file := lexer.LycaFile(f)
-    f.Close()
+    return opts
+}
+
+func build(cmd *command, args []string) int {
+    cfg := &config{}
+    fs := cmd.flagSet()
+    cfg.compileFlags(fs, "exe", "")
+    return compile(cmd, fs, cfg, args)
+}
+
+func dump(cmd *command, args []string) int {
+    cfg := &config{}
+    fs := cmd.flagSet()
+    cfg.compileFlags(fs, "ast", "-")
+    fs.BoolVar(&cfg.tokens, "tokens", false, "print the tokens, the same as --emit=tokens")
+    fs.BoolVar(&cfg.ast, "ast", false, "print the syntax tree, the same as --emit=ast")
+    fs.BoolVar(&cfg.cfg, "cfg", false, "print a Graphviz graph of every generated function, the same as --emit=cfg")
+    fs.StringVar(&cfg.format, "format", "text", "how to print tokens and the syntax tree: text, json, sexp or dot")
+    return compile(cmd, fs, cfg, args)
+}
+
+// compile builds the files named by args, or the package in the
+// directory they name, and writes what --emit asks for.
+func compile(cmd *command, fs *flag.FlagSet, cfg *config, args []string) int {
+    if code, ok := parseFlags(fs, args); !ok {
+        return code
+    }
 
-    toks := lexer.Lex(file)
-    tree := parser.Parse(toks)
-//    tree.Print()
+    if cfg.tokens {
+        cfg.emit = "tokens"
+    } else if cfg.ast {
+        cfg.emit = "ast"
+    } else if cfg.cfg {
+        cfg.emit = "cfg"
+    }
 
-    gen := codegen.Construct(tree)
-    ir  := gen.Generate()
-//    log.Println("\n" + ir)
+    if _, ok := FORMATS[cfg.format]; !ok && cfg.format != "" {
+        fmt.Fprintf(os.Stderr, "lyca %s: unknown --format=%s\n", cmd.name, cfg.format)
+        return EXIT_USAGE
+    }
+    if _, ok := EMITS[cfg.emit]; !ok {
+        fmt.Fprintf(os.Stderr, "lyca %s: unknown --emit=%s\n", cmd.name, cfg.emit)
+        return EXIT_USAGE
+    }
+    if cfg.backend != "llvm" && cfg.backend != "c" {
+        fmt.Fprintf(os.Stderr, "lyca %s: unknown --backend=%s\n", cmd.name, cfg.backend)
+        return EXIT_USAGE
+    }
+    if cfg.backend == "c" && EMITS[cfg.emit] == lyca.EmitExecutable {
+        // the C compiler targets whatever it was built for
+        for _, flag := range [][2]string{{"target", cfg.target}, {"cpu", cfg.cpu}, {"features", cfg.features}} {
+            if flag[1] != "" {
+                fmt.Fprintf(os.Stderr, "lyca %s: --%s does not apply to --backend=c, set CC to a cross compiler instead\n", cmd.name, flag[0])
+                return EXIT_USAGE
+            }
+        }
+    }
 
-    f, err = os.Create(strip + ".ll")
+    opts := cfg.options()
+    in, err := inputs(fs.Args())
     if err != nil {
-        log.Fatal(err)
+        return fail(err)
     }
+    opts.Libs, opts.LibDirs = in.libs, in.libDirs
 
-    f.WriteString(ir)
+    out := cfg.output
+    if out == "" {
+        out = in.output + EXTENSIONS[opts.Emit]
+        if opts.Emit == lyca.EmitExecutable && strings.HasPrefix(opts.Triple, "wasm") {
+            out += ".wasm"
+        }
+    }
+
+    if cfg.backend == "c" && opts.Emit == lyca.EmitExecutable {
+        return buildC(in.sources, out, opts, cfg.keepTemps)
+    }
+
+    res, diags := lyca.Compile(in.sources, opts)
+    if len(diags) > 0 {
+        return report(diags)
+    }
+
+    if res.Temps != "" {
+        fmt.Fprintln(os.Stderr, "lyca: temporary files kept in", res.Temps)
+    }
+
+    mode := os.FileMode(0644)
+    if opts.Emit == lyca.EmitExecutable {
+        mode = 0755
+    }
+    if err := writeOutput(out, res.Output, mode); err != nil {
+        return fail(err)
+    }
+
+    return EXIT_OK
+}
+
+// input is what a command line names: source files, or a package
+// directory whose manifest also gives libraries and the output path.
+type input struct {
+    sources map[string][]byte
+    output  string
+
+    libs    []string
+    libDirs []string
+}
 
-    toObj := exec.Command("llc", "-filetype=obj", strip + ".ll")
-    err = toObj.Run()
+func inputs(args []string) (*input, error) {
+    dir := ""
+    switch {
+    case len(args) == 0:
+        dir = "."
+    case len(args) == 1:
+        if info, err := os.Stat(args[0]); err == nil && info.IsDir() {
+            dir = args[0]
+        }
+    }
+
+    if dir == "" {
+        sources, err := readSources(args)
+        if err != nil {
+            return nil, err
+        }
+        return &input{sources: sources, output: outputName(args[0])}, nil
+    }
+
+    proj, err := project.Open(dir)
     if err != nil {
-        log.Fatal(err)
+        return nil, err
+    }
+
+    sources, err := proj.Sources()
+    if err != nil {
+        return nil, err
+    }
+
+    return &input{
+        sources: sources,
+        output: proj.Output(),
+        libs: proj.Libs(),
+        libDirs: proj.LibDirs(),
+    }, nil
+}
+
+func readSources(paths []string) (map[string][]byte, error) {
+    sources := map[string][]byte{}
+    for _, path := range paths {
+        src, err := ioutil.ReadFile(path)
+        if err != nil {
+            return nil, err
+        }
+        sources[path] = src
+    }
+
+    return sources, nil
+}
+
+// outputName is the file name without its extension, so the output
+// lands in the current directory whatever directory the file is in.
+func outputName(path string) string {
+    base := filepath.Base(path)
+    return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+func writeOutput(out string, data []byte, mode os.FileMode) error {
+    if out == "-" {
+        _, err := os.Stdout.Write(data)
+        return err
+    }
+
+    if err := makeOutputDir(out); err != nil {
+        return err
+    }
+
+    return ioutil.WriteFile(out, data, mode)
+}
+
+// makeOutputDir creates the directory out goes in, which for a package
+// is its out-dir.
+func makeOutputDir(out string) error {
+    if dir := filepath.Dir(out); dir != "." {
+        return os.MkdirAll(dir, 0755)
     }
 
-    toBin := exec.Command("clang", strip + ".o", "-o", strip)
-    err = toBin.Run()
+    return nil
+}
+
+// buildC generates C and compiles it with $CC, cc by default, at the
+// optimization level of opts, linking the libraries in opts.
+func buildC(sources map[string][]byte, out string, opts lyca.Options, keepTemps bool) int {
+    res, diags := lyca.Compile(sources, lyca.Options{Emit: lyca.EmitC})
+    if len(diags) > 0 {
+        return report(diags)
+    }
+
+    dir, err := ioutil.TempDir("", "lyca")
     if err != nil {
-        log.Fatal(err)
+        return fail(err)
+    }
+    if keepTemps {
+        fmt.Fprintln(os.Stderr, "lyca: temporary files kept in", dir)
+    } else {
+        defer os.RemoveAll(dir)
+    }
+
+    src := filepath.Join(dir, "main.c")
+    if err := ioutil.WriteFile(src, res.Output, 0644); err != nil {
+        return fail(err)
     }
 
-    os.Remove(strip + ".ll")
-    os.Remove(strip + ".o")
+    cc := os.Getenv("CC")
+    if cc == "" {
+        cc = "cc"
+    }
+
+    // Lyca integers wrap around like those of the LLVM backend
+    args := []string{"-std=c99", "-fwrapv", fmt.Sprintf("-O%d", opts.OptLevel), src, "-o", out}
+    if opts.SizeLevel > 0 {
+        args[2] = "-Os"
+    }
+    for _, dir := range opts.LibDirs {
+        args = append(args, "-L" + dir)
+    }
+    for _, lib := range opts.Libs {
+        args = append(args, "-l" + lib)
+    }
+    args = append(args, "-lm")
+
+    if err := makeOutputDir(out); err != nil {
+        return fail(err)
+    }
+
+    output, err := exec.Command(cc, args...).CombinedOutput()
+    if err != nil {
+        os.Stderr.Write(output)
+        return fail(fmt.Errorf("compiling C output failed: %v", err))
+    }
+
+    return EXIT_OK
+}
+
+func check(cmd *command, args []string) int {
+    fs := cmd.flagSet()
+    if code, ok := parseFlags(fs, args); !ok {
+        return code
+    }
+
+    in, err := inputs(fs.Args())
+    if err != nil {
+        return fail(err)
+    }
+
+    if diags := lyca.Check(in.sources); len(diags) > 0 {
+        return report(diags)
+    }
+    return EXIT_OK
+}
+
+// reformat prints the formatted files, or with --write rewrites them in
+// place. With --check it only lists the files that are not formatted
+// and fails if there are any. Without arguments it formats stdin.
+func reformat(cmd *command, args []string) int {
+    fs := cmd.flagSet()
+    checkOnly := fs.Bool("check", false, "list files whose formatting differs and exit 1 if there are any")
+    write := fs.Bool("write", false, "write the result back to the files instead of stdout")
+    if code, ok := parseFlags(fs, args); !ok {
+        return code
+    }
+
+    if fs.NArg() == 0 {
+        src, err := ioutil.ReadAll(os.Stdin)
+        if err != nil {
+            return fail(err)
+        }
+        return reformatFile("<stdin>", src, *checkOnly, false)
+    }
+
+    paths, err := lycaFiles(fs.Args())
+    if err != nil {
+        return fail(err)
+    }
+
+    code := EXIT_OK
+    for _, path := range paths {
+        src, err := ioutil.ReadFile(path)
+        if err != nil {
+            fail(err)
+            code = EXIT_ERROR
+            continue
+        }
+
+        if reformatFile(path, src, *checkOnly, *write) != EXIT_OK {
+            code = EXIT_ERROR
+        }
+    }
+
+    return code
+}
+
+func reformatFile(path string, src []byte, checkOnly, write bool) int {
+    out, err := format.Source(src)
+    if e, ok := err.(*lexer.Error); ok {
+        return report([]lyca.Diagnostic{{File: path, Span: e.Location, Message: e.Message}})
+    }
+    if err != nil {
+        return fail(fmt.Errorf("%s: %v", path, err))
+    }
+
+    switch {
+    case checkOnly:
+        if !bytes.Equal(src, out) {
+            fmt.Println(path)
+            return EXIT_ERROR
+        }
+    case write:
+        if !bytes.Equal(src, out) {
+            if err := ioutil.WriteFile(path, out, 0644); err != nil {
+                return fail(err)
+            }
+        }
+    default:
+        os.Stdout.Write(out)
+    }
+
+    return EXIT_OK
+}
+
+// lycaFiles expands directories in paths to the .lyca files under them.
+func lycaFiles(paths []string) ([]string, error) {
+    var files []string
+    for _, path := range paths {
+        info, err := os.Stat(path)
+        if err != nil {
+            return nil, err
+        }
+        if !info.IsDir() {
+            files = append(files, path)
+            continue
+        }
+
+        err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+            if err == nil && !info.IsDir() && filepath.Ext(file) == ".lyca" {
+                files = append(files, file)
+            }
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    return files, nil
+}
+
+// document renders the doc comments and declarations of the files in
+// args, or of the package in the directory they name.
+func document(cmd *command, args []string) int {
+    fs := cmd.flagSet()
+    output := fs.String("o", "-", "output path, - for stdout")
+    kind := fs.String("format", "md", "what to render: md or html")
+    if code, ok := parseFlags(fs, args); !ok {
+        return code
+    }
+
+    if *kind != "md" && *kind != "html" {
+        fmt.Fprintf(os.Stderr, "lyca %s: unknown --format=%s\n", cmd.name, *kind)
+        return EXIT_USAGE
+    }
+
+    in, err := inputs(fs.Args())
+    if err != nil {
+        return fail(err)
+    }
+
+    tree, diags := lyca.Parse(in.sources)
+    if len(diags) > 0 {
+        return report(diags)
+    }
+
+    pkg := doc.New(filepath.Base(in.output), tree)
+    render := pkg.Markdown
+    if *kind == "html" {
+        render = pkg.HTML
+    }
+
+    var out bytes.Buffer
+    if err := render(&out); err != nil {
+        return fail(err)
+    }
+    if err := writeOutput(*output, out.Bytes(), 0644); err != nil {
+        return fail(err)
+    }
+
+    return EXIT_OK
+}
+
+// test runs the test blocks of the files or package and reports every
+// failure with the location of the assertion that failed. With --bench
+// it then runs the bench blocks, if every test passed, and prints their
+// results in the format of go test -bench.
+func test(cmd *command, args []string) int {
+    fs := cmd.flagSet()
+    pattern := fs.String("run", "", "only run tests and benchmarks whose name matches this regular expression")
+    verbose := fs.Bool("v", false, "report passing tests as well")
+    bench := fs.Bool("bench", false, "run the bench blocks after the tests")
+    benchtime := fs.Duration("benchtime", time.Second, "run each bench block for at least this long")
+    if code, ok := parseFlags(fs, args); !ok {
+        return code
+    }
+
+    var run *regexp.Regexp
+    if *pattern != "" {
+        var err error
+        if run, err = regexp.Compile(*pattern); err != nil {
+            fmt.Fprintf(os.Stderr, "lyca %s: bad --run: %v\n", cmd.name, err)
+            return EXIT_USAGE
+        }
+    }
+
+    in, err := inputs(fs.Args())
+    if err != nil {
+        return fail(err)
+    }
+
+    results, diags := lyca.Test(in.sources, run, os.Stdout)
+    if len(diags) > 0 {
+        return report(diags)
+    }
+
+    failed := 0
+    for _, res := range results {
+        elapsed := res.Elapsed.Seconds()
+        if res.Failure != nil {
+            failed++
+            fmt.Printf("--- FAIL: %s (%.2fs)\n    %s\n", res.Name, elapsed, res.Failure)
+        } else if *verbose {
+            fmt.Printf("--- PASS: %s (%.2fs)\n", res.Name, elapsed)
+        }
+    }
+
+    switch {
+    case len(results) == 0:
+        if !*bench {
+            fmt.Println("no tests to run")
+        }
+    case failed > 0:
+        fmt.Printf("FAIL: %d of %d tests failed\n", failed, len(results))
+        return EXIT_ERROR
+    default:
+        fmt.Printf("ok: %d tests passed\n", len(results))
+    }
+
+    if *bench {
+        return runBenchmarks(in.sources, run, *benchtime)
+    }
+    return EXIT_OK
+}
+
+func runBenchmarks(sources map[string][]byte, run *regexp.Regexp, benchtime time.Duration) int {
+    results, diags := lyca.Bench(sources, run, benchtime, os.Stdout)
+    if len(diags) > 0 {
+        return report(diags)
+    }
+
+    code := EXIT_OK
+    for _, res := range results {
+        if res.Failure != nil {
+            fmt.Printf("--- FAIL: %s\n    %s\n", res.Name, res.Failure)
+            code = EXIT_ERROR
+        } else {
+            fmt.Println(res)
+        }
+    }
+
+    if len(results) == 0 {
+        fmt.Println("no benchmarks to run")
+    }
+    return code
+}
+
+func run(cmd *command, args []string) int {
+    cfg := &config{}
+    fs := cmd.flagSet()
+    useInterp := fs.Bool("interp", false, "run with the tree-walking interpreter instead of LLVM")
+    useVM := fs.Bool("vm", false, "run with the bytecode VM instead of LLVM")
+    cfg.codegenFlags(fs)
+    if code, ok := parseFlags(fs, args); !ok {
+        return code
+    }
+
+    if fs.NArg() < 1 {
+        fs.Usage()
+        return EXIT_USAGE
+    }
+    path := fs.Arg(0)
+    argv := append([]string{path}, fs.Args()[1:]...)
+
+    sources, err := readSources([]string{path})
+    if err != nil {
+        return fail(err)
+    }
+
+    // the backends expect a well typed tree, like build does
+    if diags := lyca.Check(sources); len(diags) > 0 {
+        return report(diags)
+    }
+    tree, _ := lyca.Parse(sources)
+
+    var code int
+    switch {
+    case *useInterp:
+        code, err = interp.New(tree, os.Stdout).Run(argv)
+    case *useVM:
+        code, err = runVM(tree, argv)
+    default:
+        code, err = runJIT(tree, cfg, argv)
+    }
+    if e, ok := err.(*lexer.Error); ok {
+        // a run time error, located in the one file run reads
+        return report([]lyca.Diagnostic{{File: path, Span: e.Location, Message: e.Message}})
+    }
+    if err != nil {
+        return fail(err)
+    }
+
+    return code
+}
+
+func runVM(tree *parser.AST, argv []string) (int, error) {
+    m := vm.New(os.Stdout)
+    prog, err := m.Compile(tree)
+    if err != nil {
+        return 0, err
+    }
+
+    if err := m.Load(prog); err != nil {
+        return 0, err
+    }
+    return m.Run(argv)
+}
+
+func startRepl(cmd *command, args []string) int {
+    cfg := &config{}
+    fs := cmd.flagSet()
+    cfg.codegenFlags(fs)
+    if code, ok := parseFlags(fs, args); !ok {
+        return code
+    }
+
+    if err := startSession(cfg); err != nil {
+        return fail(err)
+    }
+    return EXIT_OK
+}
+
+func serveLSP(cmd *command, args []string) int {
+    fs := cmd.flagSet()
+    if code, ok := parseFlags(fs, args); !ok {
+        return code
+    }
+
+    if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+        return fail(err)
+    }
+    return EXIT_OK
+}
+
+func report(diags []lyca.Diagnostic) int {
+    for _, diag := range diags {
+        fmt.Fprintln(os.Stderr, diag)
+    }
+
+    return EXIT_ERROR
+}
+
+func fail(err error) int {
+    fmt.Fprintln(os.Stderr, "lyca:", err)
+    return EXIT_ERROR
 }
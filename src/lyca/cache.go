@@ -0,0 +1,155 @@
+package lyca
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+
+    "github.com/k3v/lyca/src/sema"
+)
+
+// CACHE_MAX_AGE is how long a cached object is kept after the last build
+// that used it.
+const CACHE_MAX_AGE = 30 * 24 * time.Hour
+
+// cached returns the object of every unit, read from dir when its key is
+// there, or made by emit and stored for the next build. Builds touch the
+// objects they read and remove those no build used for CACHE_MAX_AGE, so
+// the cache only keeps what recent builds need.
+func (c *compilation) cached(dir string, keys []string, emit func(i int) ([]byte, error)) ([][]byte, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, err
+    }
+
+    now := time.Now()
+    objs := make([][]byte, len(keys))
+    errs := make([]error, len(keys))
+    c.each(len(keys), func(i int) {
+        path := filepath.Join(dir, keys[i] + ".o")
+        if obj, err := ioutil.ReadFile(path); err == nil {
+            objs[i] = obj
+            os.Chtimes(path, now, now)
+            return
+        }
+
+        objs[i], errs[i] = emit(i)
+        if errs[i] == nil {
+            errs[i] = store(path, objs[i])
+        }
+    })
+
+    for _, err := range errs {
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    prune(dir, now.Add(-CACHE_MAX_AGE))
+    return objs, nil
+}
+
+// prune removes the files in dir last modified before cutoff, including
+// temporary files of builds that died. Errors are ignored, another build
+// may be pruning at the same time.
+func prune(dir string, cutoff time.Time) {
+    files, err := ioutil.ReadDir(dir)
+    if err != nil {
+        return
+    }
+
+    for _, f := range files {
+        if f.Mode().IsRegular() && f.ModTime().Before(cutoff) {
+            os.Remove(filepath.Join(dir, f.Name()))
+        }
+    }
+}
+
+// cacheKeys returns the key of every file in c.sources.
+func (c *compilation) cacheKeys(info *sema.Info, opts Options) []string {
+    decls := declarations(info)
+    var keys []string
+    for _, src := range c.sources {
+        keys = append(keys, cacheKey(VERSION, opts, decls, src))
+    }
+    return keys
+}
+
+// cacheKey hashes everything a file's object depends on. Every unit
+// declares the templates, functions and globals of the whole program,
+// so their types are part of the key, but not the other files' bodies.
+func cacheKey(version string, opts Options, decls string, src []byte) string {
+    h := sha256.New()
+    fmt.Fprintf(h, "lyca %s\n", version)
+    fmt.Fprintf(h, "O%d s%d %q %q %q\n", opts.OptLevel, opts.SizeLevel, opts.Triple, opts.CPU, opts.Features)
+    io.WriteString(h, decls)
+    h.Write(src)
+
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// declarations describes the program's top level declarations in a
+// stable order.
+func declarations(info *sema.Info) string {
+    var decls []string
+    for name, tmpl := range info.Templates {
+        decl := "tmpl " + name + " {"
+        for _, field := range tmpl.Fields {
+            decl += " " + field.Type.String() + " " + field.Name + ";"
+        }
+        if tmpl.Constructor != nil {
+            decl += " constructor " + tmpl.Constructor.String() + ";"
+        }
+        var meths []string
+        for meth, fn := range tmpl.Methods {
+            meths = append(meths, " " + meth + " " + fn.String() + ";")
+        }
+        sort.Strings(meths)
+        for _, meth := range meths {
+            decl += meth
+        }
+        decls = append(decls, decl + " }")
+    }
+
+    for name, fn := range info.Functions {
+        decls = append(decls, "func " + name + " " + fn.String())
+    }
+
+    for name, global := range info.Globals {
+        decls = append(decls, "var " + name + " " + info.TypeOf(global).String())
+    }
+
+    sort.Strings(decls)
+
+    var res string
+    for _, decl := range decls {
+        res += decl + "\n"
+    }
+    return res
+}
+
+// store writes obj to path through a temporary file, so concurrent
+// builds sharing the cache never read a partial object.
+func store(path string, obj []byte) error {
+    tmp, err := ioutil.TempFile(filepath.Dir(path), "tmp")
+    if err != nil {
+        return err
+    }
+
+    if _, err := tmp.Write(obj); err != nil {
+        tmp.Close()
+        os.Remove(tmp.Name())
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        os.Remove(tmp.Name())
+        return err
+    }
+
+    return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,185 @@
+package lyca
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/sema"
+)
+
+var CACHE_PROGRAM = map[string]string{
+    "main.lyca": `func () > main > (int) {
+    return twice(total);
+}
+`,
+    "math.lyca": `func (int x) > twice > (int) {
+    return 2 * x;
+}
+`,
+    "total.lyca": `int total = 3;
+`,
+}
+
+// build runs a cached build of sources in dir, with objects that name
+// their file, and returns the files whose object was generated.
+func build(t *testing.T, dir string, sources map[string]string, opts Options) []string {
+    srcs := map[string][]byte{}
+    for name, src := range sources {
+        srcs[name] = []byte(src)
+    }
+
+    c := &compilation{files: &lexer.FileSet{}}
+    tree := c.parse(srcs)
+    if len(c.diags) > 0 {
+        t.Fatal(c.diags)
+    }
+    info, err := sema.Check(tree)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // c.sources is in name order
+    var names []string
+    for name := range sources {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    var (
+        mu      sync.Mutex
+        emitted []string
+    )
+    objs, err := c.cached(dir, c.cacheKeys(info, opts), func(i int) ([]byte, error) {
+        mu.Lock()
+        emitted = append(emitted, names[i])
+        mu.Unlock()
+        return []byte(names[i]), nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for i, obj := range objs {
+        if string(obj) != names[i] {
+            t.Errorf("got object %q for %s", obj, names[i])
+        }
+    }
+
+    sort.Strings(emitted)
+    return emitted
+}
+
+func edit(sources map[string]string, name, from, to string) map[string]string {
+    res := map[string]string{}
+    for n, src := range sources {
+        res[n] = src
+    }
+    res[name] = strings.Replace(res[name], from, to, 1)
+    return res
+}
+
+func expectEmitted(t *testing.T, what string, got []string, want ...string) {
+    t.Helper()
+    if strings.Join(got, " ") != strings.Join(want, " ") {
+        t.Errorf("%s: generated %v, want %v", what, got, want)
+    }
+}
+
+func TestCacheReuse(t *testing.T) {
+    dir, err := ioutil.TempDir("", "lyca-cache")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    opts := Options{Emit: EmitExecutable}
+    expectEmitted(t, "first build", build(t, dir, CACHE_PROGRAM, opts), "main.lyca", "math.lyca", "total.lyca")
+    expectEmitted(t, "unchanged", build(t, dir, CACHE_PROGRAM, opts))
+
+    body := edit(CACHE_PROGRAM, "math.lyca", "2 * x", "x + x")
+    expectEmitted(t, "body edited", build(t, dir, body, opts), "math.lyca")
+
+    // every unit declares twice, so a new signature changes them all
+    decl := edit(body, "math.lyca", "(int x) > twice > (int)", "(float x) > twice > (int)")
+    decl = edit(decl, "math.lyca", "x + x", "2")
+    expectEmitted(t, "declaration changed", build(t, dir, decl, opts), "main.lyca", "math.lyca", "total.lyca")
+
+    // so is a new global
+    global := edit(CACHE_PROGRAM, "total.lyca", "int total = 3;", "int total = 3;\nint extra = 1;")
+    expectEmitted(t, "global changed", build(t, dir, global, opts), "main.lyca", "math.lyca", "total.lyca")
+
+    expectEmitted(t, "back to the first", build(t, dir, CACHE_PROGRAM, opts))
+}
+
+func TestCacheKey(t *testing.T) {
+    src := []byte(CACHE_PROGRAM["main.lyca"])
+    base := cacheKey(VERSION, Options{}, "func main () > (int)\n", src)
+
+    keys := map[string]string{
+        "version":  cacheKey(VERSION + "+1", Options{}, "func main () > (int)\n", src),
+        "-O":       cacheKey(VERSION, Options{OptLevel: 2}, "func main () > (int)\n", src),
+        "-Os":      cacheKey(VERSION, Options{SizeLevel: 1}, "func main () > (int)\n", src),
+        "triple":   cacheKey(VERSION, Options{Triple: "wasm32-unknown-unknown"}, "func main () > (int)\n", src),
+        "cpu":      cacheKey(VERSION, Options{CPU: "znver3"}, "func main () > (int)\n", src),
+        "features": cacheKey(VERSION, Options{Features: "+avx2"}, "func main () > (int)\n", src),
+        "decls":    cacheKey(VERSION, Options{}, "func main () > (float)\n", src),
+        "source":   cacheKey(VERSION, Options{}, "func main () > (int)\n", append(src, '\n')),
+    }
+    for what, key := range keys {
+        if key == base {
+            t.Errorf("changing the %s keeps the key", what)
+        }
+    }
+
+    // options that do not change the object keep it
+    if key := cacheKey(VERSION, Options{Jobs: 8, KeepTemps: true}, "func main () > (int)\n", src); key != base {
+        t.Error("-j or keeping temporary files changed the key")
+    }
+}
+
+func TestCachePrune(t *testing.T) {
+    dir, err := ioutil.TempDir("", "lyca-cache")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    opts := Options{Emit: EmitExecutable}
+    build(t, dir, CACHE_PROGRAM, opts)
+
+    // a stale object of an old build and a temporary file of a dead one
+    old := time.Now().Add(-CACHE_MAX_AGE - time.Hour)
+    for _, name := range []string{"stale.o", "tmp123"} {
+        path := filepath.Join(dir, name)
+        if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+            t.Fatal(err)
+        }
+        os.Chtimes(path, old, old)
+    }
+
+    // objects the build reads are touched and kept, however old
+    files, _ := filepath.Glob(filepath.Join(dir, "*.o"))
+    for _, path := range files {
+        if filepath.Base(path) != "stale.o" {
+            os.Chtimes(path, old, old)
+        }
+    }
+
+    expectEmitted(t, "rebuild", build(t, dir, CACHE_PROGRAM, opts))
+
+    left, _ := ioutil.ReadDir(dir)
+    if len(left) != len(CACHE_PROGRAM) {
+        var names []string
+        for _, f := range left {
+            names = append(names, f.Name())
+        }
+        t.Errorf("cache holds %v, want the %d objects of the build", names, len(CACHE_PROGRAM))
+    }
+}
@@ -0,0 +1,70 @@
+package lyca
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+)
+
+// link runs the system linker over objs in a temporary directory and
+// returns the linked executable or wasm module, and the directory if
+// opts.KeepTemps is set.
+func link(objs [][]byte, opts Options, wasm bool) (out []byte, temps string, err error) {
+    dir, err := ioutil.TempDir("", "lyca")
+    if err != nil {
+        return nil, "", err
+    }
+    if opts.KeepTemps {
+        temps = dir
+    } else {
+        defer os.RemoveAll(dir)
+    }
+
+    var in []string
+    for i, obj := range objs {
+        path := filepath.Join(dir, "main" + strconv.Itoa(i) + ".o")
+        if err := ioutil.WriteFile(path, obj, 0644); err != nil {
+            return nil, temps, err
+        }
+        in = append(in, path)
+    }
+    exe := filepath.Join(dir, "main")
+
+    linker := opts.Linker
+    var args []string
+    if wasm {
+        if linker == "" {
+            linker = "wasm-ld"
+        }
+        // printf is imported from the host's "env" namespace; main and
+        // every export func are exported
+        args = append([]string{"--no-entry"}, in...)
+    } else {
+        if linker == "" {
+            linker = "clang"
+        }
+        args = in
+        for _, dir := range opts.LibDirs {
+            args = append(args, "-L" + dir)
+        }
+        for _, lib := range opts.Libs {
+            args = append(args, "-l" + lib)
+        }
+        if opts.Triple != "" {
+            args = append(args, "--target=" + opts.Triple)
+        }
+    }
+
+    args = append(args, "-o", exe)
+
+    output, err := exec.Command(linker, args...).CombinedOutput()
+    if err != nil {
+        return nil, temps, fmt.Errorf("linking failed: %v\n%s", err, output)
+    }
+
+    out, err = ioutil.ReadFile(exe)
+    return out, temps, err
+}
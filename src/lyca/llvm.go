@@ -0,0 +1,88 @@
+// +build !nollvm
+
+package lyca
+
+import (
+    "bytes"
+    "fmt"
+
+    "github.com/k3v/lyca/src/codegen"
+    "github.com/k3v/lyca/src/dump"
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/sema"
+)
+
+func (opts Options) codegen() codegen.Options {
+    return codegen.Options{
+        OptLevel: opts.OptLevel,
+        SizeLevel: opts.SizeLevel,
+        Triple: opts.Triple,
+        CPU: opts.CPU,
+        Features: opts.Features,
+    }
+}
+
+func (c *compilation) generate(tree *parser.AST, opts Options) (*Result, error) {
+    gen, err := codegen.Construct(tree, opts.codegen())
+    if err != nil {
+        return nil, err
+    }
+    defer gen.Dispose()
+
+    ir, err := gen.GenerateUnits(c.units, c.workers())
+    if err != nil {
+        return nil, err
+    }
+
+    res := &Result{IR: ir}
+    switch opts.Emit {
+    case EmitIR:
+        res.Output = []byte(ir)
+    case EmitAssembly:
+        res.Output, err = gen.EmitAssembly()
+    case EmitObject:
+        res.Output, err = gen.EmitObject()
+    case EmitBitcode:
+        res.Output = gen.EmitBitcode()
+    case EmitCFG:
+        var out bytes.Buffer
+        err = dump.CFG(&out, gen.Module())
+        res.Output = out.Bytes()
+    case EmitExecutable:
+        var obj []byte
+        if obj, err = gen.EmitObject(); err == nil {
+            res.Output, res.Temps, err = link([][]byte{obj}, opts, gen.IsWasm())
+        }
+    default:
+        err = fmt.Errorf("unknown emit kind %d", opts.Emit)
+    }
+
+    if err != nil {
+        return nil, err
+    }
+    return res, nil
+}
+
+// generateCached compiles every file to an object of its own, reusing
+// the objects in opts.CacheDir whose key still matches, and links them.
+func (c *compilation) generateCached(tree *parser.AST, info *sema.Info, opts Options) (*Result, error) {
+    gen, err := codegen.Construct(tree, opts.codegen())
+    if err != nil {
+        return nil, err
+    }
+    defer gen.Dispose()
+
+    objs, err := c.cached(opts.CacheDir, c.cacheKeys(info, opts), func(i int) ([]byte, error) {
+        return gen.EmitUnit(c.units[i])
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    out, temps, err := link(objs, opts, gen.IsWasm())
+    if err != nil {
+        return nil, err
+    }
+    return &Result{Output: out, Temps: temps}, nil
+}
+
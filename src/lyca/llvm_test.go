@@ -0,0 +1,113 @@
+// +build !nollvm
+
+package lyca
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// programs reads the example programs the golden tests run, one source
+// map each.
+func programs(t *testing.T) map[string]map[string][]byte {
+    paths, err := filepath.Glob("../../test/src/*.lyca")
+    if err != nil || len(paths) == 0 {
+        t.Fatalf("no example programs: %v", err)
+    }
+
+    res := map[string]map[string][]byte{}
+    for _, path := range paths {
+        src, err := ioutil.ReadFile(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        name := filepath.Base(path)
+        res[name] = map[string][]byte{name: src}
+    }
+    return res
+}
+
+func compileIR(t *testing.T, sources map[string][]byte, jobs int) string {
+    res, diags := Compile(sources, Options{Emit: EmitIR, Jobs: jobs})
+    if len(diags) > 0 {
+        t.Errorf("%v", diags)
+        return ""
+    }
+    if !strings.Contains(res.IR, "define") {
+        t.Errorf("no functions in IR:\n%s", res.IR)
+    }
+    return res.IR
+}
+
+// Every Codegen has a context of its own, so compiling on several
+// goroutines at once gives what compiling one at a time does. Run with
+// -race.
+func TestCompileConcurrently(t *testing.T) {
+    progs := programs(t)
+    want := map[string]string{}
+    for name, sources := range progs {
+        want[name] = compileIR(t, sources, 1)
+    }
+
+    const ROUNDS = 4
+    var wg sync.WaitGroup
+    for i := 0; i < ROUNDS; i++ {
+        for name, sources := range progs {
+            wg.Add(1)
+            go func(name string, sources map[string][]byte) {
+                defer wg.Done()
+                if got := compileIR(t, sources, 2); got != want[name] {
+                    t.Errorf("%s: IR differs from a compile on its own", name)
+                }
+            }(name, sources)
+        }
+    }
+    wg.Wait()
+}
+
+// JOBS_PROGRAM is split over files that use each other's declarations,
+// so every unit links against the others.
+var JOBS_PROGRAM = map[string][]byte{
+    "main.lyca": []byte(`func () > main > (int) {
+    Counter c = make Counter < (2);
+    c.add(total);
+    return twice(c.value);
+}
+`),
+    "counter.lyca": []byte(`tmpl Counter {
+    int value;
+
+    constructor < (int start) {
+        this.value = start;
+    }
+
+    func (int n) > add > () {
+        this.value = this.value + n;
+    }
+}
+`),
+    "math.lyca": []byte(`int total = 3;
+
+func (int x) > twice > (int) {
+    return 2 * x;
+}
+`),
+    "text.lyca": []byte(`func (string s) > greet > (string) {
+    return "hello " + s;
+}
+`),
+}
+
+// The linked module does not depend on how many units are generated at
+// once.
+func TestCompileJobs(t *testing.T) {
+    want := compileIR(t, JOBS_PROGRAM, 1)
+    for _, jobs := range []int{2, 4, 8} {
+        if got := compileIR(t, JOBS_PROGRAM, jobs); got != want {
+            t.Errorf("-j%d: IR differs from -j1", jobs)
+        }
+    }
+}
@@ -0,0 +1,369 @@
+// Package lyca compiles Lyca programs in memory. It is the pipeline behind
+// the lyca command, usable from other Go programs:
+//
+//     res, diags := lyca.Compile(map[string][]byte{"main.lyca": src}, lyca.Options{
+//         Emit: lyca.EmitObject,
+//     })
+package lyca
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "runtime"
+    "sort"
+    "sync"
+
+    "github.com/k3v/lyca/src/cgen"
+    "github.com/k3v/lyca/src/dump"
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/sema"
+    "github.com/k3v/lyca/src/vm"
+)
+
+// VERSION is part of every cache key, so objects cached by one version
+// of the compiler are never linked by another.
+const VERSION = "0.1.0"
+
+type Emit int
+
+const (
+    EmitIR Emit = iota
+    EmitAssembly
+    EmitObject
+    EmitExecutable
+    EmitC
+    EmitBitcode
+
+    // EmitTokens and EmitAST stop after lexing and parsing, EmitBytecode
+    // writes the VM's disassembly. They and EmitC need no LLVM.
+    EmitTokens
+    EmitAST
+    EmitBytecode
+
+    // EmitCFG writes a Graphviz graph of the basic blocks of every
+    // generated function.
+    EmitCFG
+)
+
+// Format is how EmitTokens and EmitAST write their output: as text, or
+// as the JSON, S-expressions or Graphviz graph of package dump, one entry
+// per file.
+type Format int
+
+const (
+    FormatText Format = iota
+    FormatJSON
+    FormatSexp
+    FormatDOT
+)
+
+type Options struct {
+    Emit   Emit
+    Format Format
+
+    OptLevel  int
+    SizeLevel int
+
+    Triple   string
+    CPU      string
+    Features string
+
+    // Linker links executables, clang by default or wasm-ld for wasm
+    // targets. Linking is the only step that uses temporary files.
+    Linker string
+
+    // Libs are C libraries linked into executables, searched for in
+    // LibDirs before the system directories.
+    Libs    []string
+    LibDirs []string
+
+    // Jobs is how many files are parsed and generated at once, the
+    // number of CPUs when zero. The output is the same for any value.
+    Jobs int
+
+    // CacheDir keeps the object file of every source file of an
+    // executable build, keyed by its contents, the compiler version, the
+    // options and the declarations of the whole program. A rebuild only
+    // recompiles files whose key changed and relinks. Objects no build
+    // used for CACHE_MAX_AGE are removed. Empty disables the cache; other
+    // emit kinds never use it.
+    CacheDir string
+
+    // KeepTemps keeps the linker's temporary directory instead of
+    // removing it, and reports it in Result.Temps.
+    KeepTemps bool
+}
+
+type Result struct {
+    // IR is the optimized LLVM module, empty when emitting C or building
+    // through the cache.
+    IR string
+
+    // Output is what Options.Emit asked for.
+    Output []byte
+
+    // Temps is the directory holding intermediate files when
+    // Options.KeepTemps is set and the compiler needed any.
+    Temps string
+}
+
+type Diagnostic struct {
+    File    string
+    Span    lexer.Span
+    Message string
+}
+
+func (d Diagnostic) String() string {
+    if d.Span.Start.Line == 0 {
+        return d.Message
+    }
+
+    if d.File == "" {
+        return fmt.Sprintf("%d:%d: %s", d.Span.Start.Line, d.Span.Start.Offset, d.Message)
+    }
+
+    return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Span.Start.Line, d.Span.Start.Offset, d.Message)
+}
+
+type compilation struct {
+    files *lexer.FileSet
+    diags []Diagnostic
+    jobs  int
+
+    // units holds the top level nodes of each file, in name order, and
+    // sources the text they were parsed from
+    units   [][]parser.Node
+    sources [][]byte
+}
+
+// Compile compiles sources, keyed by file name, into a single program.
+// Files are processed in name order so the output does not depend on map
+// iteration. Any diagnostic means compilation failed and Result is nil.
+// Compile is safe to call from several goroutines at once.
+func Compile(sources map[string][]byte, opts Options) (*Result, []Diagnostic) {
+    c := &compilation{files: &lexer.FileSet{}, jobs: opts.Jobs}
+
+    if opts.Emit == EmitTokens {
+        out := c.tokens(sources, opts.Format)
+        if len(c.diags) > 0 {
+            return nil, c.diags
+        }
+        return &Result{Output: out}, nil
+    }
+
+    tree := c.parse(sources)
+    if len(c.diags) > 0 {
+        return nil, c.diags
+    }
+
+    if opts.Emit == EmitAST {
+        var out bytes.Buffer
+        if opts.Format == FormatText {
+            tree.Fprint(&out)
+            return &Result{Output: out.Bytes()}, nil
+        }
+
+        var files []interface{}
+        for i, name := range sortedNames(sources) {
+            files = append(files, dump.File(name, "nodes", dump.Nodes(c.units[i])))
+        }
+        if err := write(&out, files, opts.Format); err != nil {
+            return nil, c.report(err)
+        }
+        return &Result{Output: out.Bytes()}, nil
+    }
+
+    info, err := sema.Check(tree)
+    if err != nil {
+        return nil, c.report(err)
+    }
+
+    switch opts.Emit {
+    case EmitC:
+        src, err := cgen.Generate(tree)
+        if err != nil {
+            return nil, c.report(err)
+        }
+        return &Result{Output: []byte(src)}, nil
+    case EmitBytecode:
+        prog, err := vm.New(ioutil.Discard).Compile(tree)
+        if err != nil {
+            return nil, c.report(err)
+        }
+
+        var out bytes.Buffer
+        vm.Disassemble(&out, prog)
+        return &Result{Output: out.Bytes()}, nil
+    }
+
+    var res *Result
+    if opts.CacheDir != "" && opts.Emit == EmitExecutable {
+        res, err = c.generateCached(tree, info, opts)
+    } else {
+        res, err = c.generate(tree, opts)
+    }
+    if err != nil {
+        return nil, c.report(err)
+    }
+
+    return res, nil
+}
+
+// Parse lexes and parses sources into one AST holding the declarations
+// of every file.
+func Parse(sources map[string][]byte) (*parser.AST, []Diagnostic) {
+    c := &compilation{files: &lexer.FileSet{}}
+    tree := c.parse(sources)
+    return tree, c.diags
+}
+
+// Check parses and type checks sources without generating any code.
+func Check(sources map[string][]byte) []Diagnostic {
+    c := &compilation{files: &lexer.FileSet{}}
+    tree := c.parse(sources)
+    if len(c.diags) > 0 {
+        return c.diags
+    }
+
+    if _, err := sema.Check(tree); err != nil {
+        c.report(err)
+    }
+    return c.diags
+}
+
+// tokens lists the tokens of every file, one per line.
+func (c *compilation) tokens(sources map[string][]byte, format Format) []byte {
+    var (
+        out   bytes.Buffer
+        files []interface{}
+    )
+    for _, name := range sortedNames(sources) {
+        toks, err := lexer.Lex(c.files.AddFile(name, sources[name]))
+        if err != nil {
+            c.report(err)
+            continue
+        }
+
+        if format != FormatText {
+            files = append(files, dump.File(name, "tokens", dump.Tokens(toks)))
+            continue
+        }
+        for _, tok := range toks {
+            start := tok.Location.Start
+            fmt.Fprintf(&out, "%s:%d:%d\t%s\t%q\n", name, start.Line, start.Offset, lexer.TOKEN_NAMES[tok.Type], tok.Content)
+        }
+    }
+
+    if format != FormatText && len(c.diags) == 0 {
+        if err := write(&out, files, format); err != nil {
+            c.report(err)
+        }
+    }
+    return out.Bytes()
+}
+
+func write(w io.Writer, v interface{}, format Format) error {
+    switch format {
+    case FormatSexp:
+        return dump.WriteSexp(w, v)
+    case FormatDOT:
+        return dump.WriteDOT(w, v)
+    }
+    return dump.WriteJSON(w, v)
+}
+
+func sortedNames(sources map[string][]byte) []string {
+    var names []string
+    for name := range sources {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// parse lexes and parses the files concurrently. Files are added to the
+// file set up front and results are collected by index, so positions,
+// node order and diagnostics are the same as a serial parse.
+func (c *compilation) parse(sources map[string][]byte) *parser.AST {
+    names := sortedNames(sources)
+
+    files := make([]*lexer.File, len(names))
+    for i, name := range names {
+        files[i] = c.files.AddFile(name, sources[name])
+    }
+
+    trees := make([]*parser.AST, len(files))
+    errs := make([]error, len(files))
+    c.each(len(files), func(i int) {
+        toks, err := lexer.Lex(files[i])
+        if err != nil {
+            errs[i] = err
+            return
+        }
+        trees[i], errs[i] = parser.Parse(toks)
+    })
+
+    tree := &parser.AST{}
+    for i := range files {
+        if errs[i] != nil {
+            c.report(errs[i])
+            continue
+        }
+
+        c.units = append(c.units, trees[i].Nodes)
+        c.sources = append(c.sources, sources[names[i]])
+        tree.Nodes = append(tree.Nodes, trees[i].Nodes...)
+    }
+
+    return tree
+}
+
+// each calls fn for 0 through n-1 on up to c.jobs goroutines.
+func (c *compilation) each(n int, fn func(i int)) {
+    jobs := c.workers()
+    next := make(chan int)
+    var wg sync.WaitGroup
+    for w := 0; w < jobs && w < n; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range next {
+                fn(i)
+            }
+        }()
+    }
+
+    for i := 0; i < n; i++ {
+        next <- i
+    }
+    close(next)
+    wg.Wait()
+}
+
+func (c *compilation) workers() int {
+    if c.jobs > 0 {
+        return c.jobs
+    }
+    return runtime.NumCPU()
+}
+
+func (c *compilation) report(err error) []Diagnostic {
+    c.diags = append(c.diags, c.diagnostic(err))
+    return c.diags
+}
+
+func (c *compilation) diagnostic(err error) Diagnostic {
+    diag := Diagnostic{Message: err.Error()}
+    if e, ok := err.(*lexer.Error); ok {
+        diag.Span = e.Location
+        diag.Message = e.Message
+        if f := c.files.File(e.Location.Start); f != nil && e.Location.Start.Line > 0 {
+            diag.File = f.Name
+        }
+    }
+
+    return diag
+}
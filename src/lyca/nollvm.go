@@ -0,0 +1,20 @@
+// +build nollvm
+
+package lyca
+
+import (
+    "errors"
+
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/sema"
+)
+
+var errNoLLVM = errors.New("lyca was built without LLVM, only tokens, ast, bytecode and c can be emitted")
+
+func (c *compilation) generate(tree *parser.AST, opts Options) (*Result, error) {
+    return nil, errNoLLVM
+}
+
+func (c *compilation) generateCached(tree *parser.AST, info *sema.Info, opts Options) (*Result, error) {
+    return nil, errNoLLVM
+}
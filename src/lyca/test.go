@@ -0,0 +1,183 @@
+package lyca
+
+import (
+    "fmt"
+    "io"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/sema"
+    "github.com/k3v/lyca/src/vm"
+)
+
+// TestResult is the outcome of one test block. Failure is nil when the
+// test passed, otherwise it locates the failed assertion or run time
+// error that stopped it.
+type TestResult struct {
+    Name    string
+    File    string
+    Elapsed time.Duration
+    Failure *Diagnostic
+}
+
+// Test compiles the test blocks of sources, along with the rest of the
+// program, for the bytecode VM and runs those whose name matches run,
+// all of them when run is nil. Every test starts from freshly
+// initialized globals, so tests cannot see each other's changes, and a
+// failed test does not stop the others. What tests print goes to stdout.
+func Test(sources map[string][]byte, run *regexp.Regexp, stdout io.Writer) ([]TestResult, []Diagnostic) {
+    c, m, prog := compileTests(sources, stdout)
+    if len(c.diags) > 0 {
+        return nil, c.diags
+    }
+
+    var results []TestResult
+    for i, index := range prog.Tests {
+        test := prog.Functions[index].Type
+        if run != nil && !run.MatchString(test.Name) {
+            continue
+        }
+
+        res := TestResult{Name: test.Name, File: c.file(test)}
+        start := time.Now()
+        err := m.Load(prog)
+        if err == nil {
+            err = m.Test(i)
+        }
+        res.Elapsed = time.Since(start)
+
+        if err != nil {
+            diag := c.diagnostic(err)
+            res.Failure = &diag
+        }
+        results = append(results, res)
+    }
+
+    return results, nil
+}
+
+// BenchResult is the outcome of one bench block: N iterations that took
+// Elapsed in total and allocated Allocs values, or the Failure that
+// stopped it.
+type BenchResult struct {
+    Name    string
+    File    string
+    N       int
+    Elapsed time.Duration
+    Allocs  int64
+    Failure *Diagnostic
+}
+
+func (r BenchResult) NsPerOp() float64 {
+    if r.N == 0 {
+        return 0
+    }
+    return float64(r.Elapsed.Nanoseconds()) / float64(r.N)
+}
+
+func (r BenchResult) AllocsPerOp() int64 {
+    if r.N == 0 {
+        return 0
+    }
+    return r.Allocs / int64(r.N)
+}
+
+// String formats the result the way go test -bench does, so runs can be
+// compared with the usual tools. Spaces in the name become underscores
+// to keep it a single field.
+func (r BenchResult) String() string {
+    name := "Benchmark/" + strings.Join(strings.Fields(r.Name), "_")
+    return fmt.Sprintf("%s\t%10d\t%12.1f ns/op\t%6d allocs/op", name, r.N, r.NsPerOp(), r.AllocsPerOp())
+}
+
+// MAX_BENCH_N caps the iterations of a bench block, as in go test.
+const MAX_BENCH_N = 1000000000
+
+// Bench runs the bench blocks of sources whose name matches run, all of
+// them when run is nil, on the bytecode VM. Each starts from freshly
+// initialized globals and runs 1 iteration, then more and more until
+// the iterations take at least benchtime, predicting the count from the
+// previous run like go test does. Allocations are counted by the VM.
+func Bench(sources map[string][]byte, run *regexp.Regexp, benchtime time.Duration, stdout io.Writer) ([]BenchResult, []Diagnostic) {
+    c, m, prog := compileTests(sources, stdout)
+    if len(c.diags) > 0 {
+        return nil, c.diags
+    }
+
+    var results []BenchResult
+    for i, index := range prog.Benches {
+        bench := prog.Functions[index].Type
+        if run != nil && !run.MatchString(bench.Name) {
+            continue
+        }
+
+        res := BenchResult{Name: bench.Name, File: c.file(bench)}
+        for n := 1; ; n = nextN(n, res.Elapsed, benchtime) {
+            err := m.Load(prog)
+            m.Allocs = 0
+            start := time.Now()
+            if err == nil {
+                err = m.Bench(i, n)
+            }
+            res.N, res.Elapsed, res.Allocs = n, time.Since(start), m.Allocs
+
+            if err != nil {
+                diag := c.diagnostic(err)
+                res.Failure = &diag
+                break
+            }
+            if res.Elapsed >= benchtime || n >= MAX_BENCH_N {
+                break
+            }
+        }
+        results = append(results, res)
+    }
+
+    return results, nil
+}
+
+// nextN predicts how many iterations take benchtime from the last run
+// of n, overshooting by a fifth, growing at least by one and at most a
+// hundredfold.
+func nextN(n int, elapsed, benchtime time.Duration) int {
+    next := 100 * n
+    if elapsed > 0 {
+        next = int(1.2 * float64(n) * float64(benchtime) / float64(elapsed))
+    }
+
+    if next > 100 * n {
+        next = 100 * n
+    }
+    if next <= n {
+        next = n + 1
+    }
+    if next > MAX_BENCH_N {
+        next = MAX_BENCH_N
+    }
+    return next
+}
+
+func compileTests(sources map[string][]byte, stdout io.Writer) (*compilation, *vm.VM, *vm.Program) {
+    c := &compilation{files: &lexer.FileSet{}}
+    tree := c.parse(sources)
+    if len(c.diags) > 0 {
+        return c, nil, nil
+    }
+
+    m := vm.New(stdout)
+    prog, err := m.Compile(tree)
+    if err != nil {
+        c.report(err)
+    }
+    return c, m, prog
+}
+
+// file names the file a test or bench block was written in.
+func (c *compilation) file(fn *sema.Func) string {
+    if f := c.files.File(fn.Node.Loc().Start); f != nil {
+        return f.Name
+    }
+    return ""
+}
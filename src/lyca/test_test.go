@@ -0,0 +1,76 @@
+package lyca
+
+import (
+    "io/ioutil"
+    "regexp"
+    "strconv"
+    "testing"
+    "time"
+)
+
+func TestNextN(t *testing.T) {
+    tests := []struct {
+        name      string
+        n         int
+        elapsed   time.Duration
+        benchtime time.Duration
+        want      int
+    }{
+        {"too fast to time", 1, 0, time.Second, 100},
+        {"overshoots by a fifth", 100, 100 * time.Millisecond, time.Second, 1200},
+        {"grows at most a hundredfold", 10, time.Microsecond, time.Second, 1000},
+        {"grows at least by one", 50, 2 * time.Second, time.Second, 51},
+        {"capped", MAX_BENCH_N / 10, time.Nanosecond, time.Second, MAX_BENCH_N},
+    }
+
+    for _, test := range tests {
+        if got := nextN(test.n, test.elapsed, test.benchtime); got != test.want {
+            t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+        }
+    }
+}
+
+const BENCH_PROGRAM = `int count = 0;
+
+bench "add one" {
+    count = count + 1;
+}
+
+bench "other" {
+    count = count - 1;
+}
+`
+
+// BENCH_LINE matches a result the way go test -bench prints it.
+var BENCH_LINE = regexp.MustCompile(`^Benchmark/(\S+)\t +(\d+)\t +([\d.]+) ns/op\t +(\d+) allocs/op$`)
+
+func TestBench(t *testing.T) {
+    sources := map[string][]byte{"bench.lyca": []byte(BENCH_PROGRAM)}
+    benchtime := 20 * time.Millisecond
+    results, diags := Bench(sources, regexp.MustCompile("add"), benchtime, ioutil.Discard)
+    if len(diags) > 0 {
+        t.Fatal(diags)
+    }
+    if len(results) != 1 {
+        t.Fatalf("got %+v, want only the add one bench", results)
+    }
+
+    res := results[0]
+    if res.Failure != nil || res.File != "bench.lyca" {
+        t.Fatalf("got %+v", res)
+    }
+    if res.Elapsed < benchtime || res.N <= 1 {
+        t.Errorf("got %d iterations in %v, want many taking at least %v", res.N, res.Elapsed, benchtime)
+    }
+
+    m := BENCH_LINE.FindStringSubmatch(res.String())
+    if m == nil {
+        t.Fatalf("got %q, want a go test -bench line", res.String())
+    }
+    if n, _ := strconv.Atoi(m[2]); m[1] != "add_one" || n != res.N {
+        t.Errorf("got %q, want add_one run %d times", res.String(), res.N)
+    }
+    if ns, _ := strconv.ParseFloat(m[3], 64); ns <= 0 {
+        t.Errorf("got %q, want the time per iteration", res.String())
+    }
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "reflect"
+    "regexp"
+    "strings"
+    "testing"
+
+    "github.com/k3v/lyca/src/lyca"
+)
+
+var C_PACKAGE = map[string]string{
+    "app/lyca.toml": `[package]
+name = "app"
+entry = "main.lyca"
+libs = ["m"]
+out-dir = "build/bin"
+
+[dependencies]
+util = { path = "../util" }
+`,
+    "app/main.lyca": `func () > main > (int) {
+    return twice(21);
+}
+`,
+    "util/lyca.toml": `[package]
+name = "util"
+`,
+    "util/twice.lyca": `func (int x) > twice > (int) {
+    return 2 * x;
+}
+`,
+}
+
+// write writes files, named by slash separated paths, into a new
+// temporary directory and returns it.
+func write(t *testing.T, files map[string]string) string {
+    dir, err := ioutil.TempDir("", "lyca-test")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for name, src := range files {
+        path := filepath.Join(dir, filepath.FromSlash(name))
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            t.Fatal(err)
+        }
+        if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return dir
+}
+
+// A package builds with the C backend into an out-dir that does not
+// exist yet.
+func TestBuildPackageC(t *testing.T) {
+    if _, err := exec.LookPath("cc"); err != nil && os.Getenv("CC") == "" {
+        t.Skip("no C compiler")
+    }
+
+    dir := write(t, C_PACKAGE)
+    defer os.RemoveAll(dir)
+
+    if code := dispatch([]string{"build", "--backend=c", filepath.Join(dir, "app")}); code != EXIT_OK {
+        t.Fatalf("build exited with %d", code)
+    }
+
+    err := exec.Command(filepath.Join(dir, "app", "build", "bin", "app")).Run()
+    if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 42 {
+        t.Errorf("got %v, want exit status 42", err)
+    }
+}
+
+// TEST_FILES have tests that pass only when each starts from freshly
+// initialized globals, a failing assertion and a run time error.
+var TEST_FILES = map[string]string{
+    "count.lyca": `int count = 0;
+
+func () > bump > (int) {
+    count = count + 1;
+    return count;
+}
+
+test "first bump" {
+    assert(bump() == 1, "count starts at zero");
+}
+
+test "second bump" {
+    assert(bump() == 1, "count is reset");
+}
+`,
+    "fail.lyca": `test "assertion" {
+    int x = 2;
+    assert(x == 3, "x is three");
+}
+
+test "division" {
+    int zero = 0;
+    printf("%d", 1 / zero);
+}
+`,
+}
+
+func testSources(files map[string]string) map[string][]byte {
+    sources := map[string][]byte{}
+    for name, src := range files {
+        sources[name] = []byte(src)
+    }
+    return sources
+}
+
+func TestTestResults(t *testing.T) {
+    results, diags := lyca.Test(testSources(TEST_FILES), nil, ioutil.Discard)
+    if len(diags) > 0 {
+        t.Fatal(diags)
+    }
+
+    var got []string
+    for _, res := range results {
+        line := res.File + " " + res.Name + ": ok"
+        if res.Failure != nil {
+            line = fmt.Sprintf("%s %s: %s:%d %s", res.File, res.Name, res.Failure.File, res.Failure.Span.Start.Line, res.Failure.Message)
+        }
+        got = append(got, line)
+    }
+
+    want := []string{
+        "count.lyca first bump: ok",
+        "count.lyca second bump: ok",
+        "fail.lyca assertion: fail.lyca:3 assertion failed: x is three",
+        "fail.lyca division: fail.lyca:8 Integer division by zero",
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+    }
+
+    results, _ = lyca.Test(testSources(TEST_FILES), regexp.MustCompile("bump$"), ioutil.Discard)
+    if len(results) != 2 || results[0].Name != "first bump" || results[1].Name != "second bump" {
+        t.Errorf("got %+v, want the two bump tests", results)
+    }
+}
+
+// captured runs f with *file, os.Stdout or os.Stderr, going to a
+// temporary file and returns what was written there along with the
+// result of f.
+func captured(t *testing.T, file **os.File, f func() int) (string, int) {
+    out, err := ioutil.TempFile("", "lyca-output")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.Remove(out.Name())
+    defer out.Close()
+
+    saved := *file
+    *file = out
+    code := f()
+    *file = saved
+
+    text, err := ioutil.ReadFile(out.Name())
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(text), code
+}
+
+func TestTestExitStatus(t *testing.T) {
+    dir := write(t, TEST_FILES)
+    defer os.RemoveAll(dir)
+    count, fail := filepath.Join(dir, "count.lyca"), filepath.Join(dir, "fail.lyca")
+
+    tests := []struct {
+        name string
+        args []string
+        code int
+        last string
+    }{
+        {"passing", []string{count}, EXIT_OK, "ok: 2 tests passed"},
+        {"failing", []string{count, fail}, EXIT_ERROR, "FAIL: 2 of 4 tests failed"},
+        {"filtered", []string{"--run", "bump", count, fail}, EXIT_OK, "ok: 2 tests passed"},
+        {"no match", []string{"--run", "none", count}, EXIT_OK, "no tests to run"},
+        {"bad pattern", []string{"--run", "(", count}, EXIT_USAGE, ""},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            out, code := captured(t, &os.Stdout, func() int {
+                return dispatch(append([]string{"test"}, test.args...))
+            })
+            lines := strings.Split(strings.TrimSpace(out), "\n")
+            if code != test.code || lines[len(lines) - 1] != test.last {
+                t.Errorf("got exit status %d and output\n%s\nwant %d and a last line %q", code, out, test.code, test.last)
+            }
+        })
+    }
+
+    out, _ := captured(t, &os.Stdout, func() int {
+        return dispatch([]string{"test", count, fail})
+    })
+    if want := "    " + fail + ":3:"; !strings.Contains(out, "--- FAIL: assertion (") || !strings.Contains(out, want) {
+        t.Errorf("got\n%s\nwant the failed assertion at %s", out, want)
+    }
+}
+
+// RECORD_CC is a C compiler that writes its arguments to the file after
+// -o instead of compiling.
+const RECORD_CC = `#!/bin/sh
+out=
+prev=
+for arg in "$@"; do
+    [ "$prev" = "-o" ] && out="$arg"
+    prev="$arg"
+done
+echo "$@" > "$out"
+`
+
+func TestBuildCFlags(t *testing.T) {
+    dir := write(t, map[string]string{"main.lyca": "func () > main > (int) {\n    return 0;\n}\n", "cc": RECORD_CC})
+    defer os.RemoveAll(dir)
+    if err := os.Chmod(filepath.Join(dir, "cc"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    defer os.Setenv("CC", os.Getenv("CC"))
+    os.Setenv("CC", filepath.Join(dir, "cc"))
+
+    src, out := filepath.Join(dir, "main.lyca"), filepath.Join(dir, "main")
+    tests := []struct {
+        flags []string
+        want  string
+    }{
+        {nil, "-std=c99 -fwrapv -O0 "},
+        {[]string{"-O3"}, "-std=c99 -fwrapv -O3 "},
+        {[]string{"-Os"}, "-std=c99 -fwrapv -Os "},
+    }
+
+    for _, test := range tests {
+        args := append(append([]string{"build", "--backend=c", "-o", out}, test.flags...), src)
+        if code := dispatch(args); code != EXIT_OK {
+            t.Fatalf("%v: build exited with %d", test.flags, code)
+        }
+        got, err := ioutil.ReadFile(out)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !strings.HasPrefix(string(got), test.want) {
+            t.Errorf("%v: got cc %s, want it to start with %s", test.flags, got, test.want)
+        }
+    }
+
+    for _, flag := range []string{"--target=wasm32", "--cpu=generic", "--features=+sse4.2"} {
+        if code := dispatch([]string{"build", "--backend=c", "-o", out, flag, src}); code != EXIT_USAGE {
+            t.Errorf("%s: build exited with %d, want %d", flag, code, EXIT_USAGE)
+        }
+    }
+}
+
+// Run time errors name the file like compile errors do.
+func TestRunError(t *testing.T) {
+    dir := write(t, map[string]string{"div.lyca": `func () > main > (int) {
+    int zero = 0;
+    return 1 / zero;
+}
+`})
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "div.lyca")
+
+    for _, engine := range []string{"-interp", "-vm"} {
+        msg, code := captured(t, &os.Stderr, func() int {
+            return dispatch([]string{"run", engine, path})
+        })
+        if want := path + ":3:"; code != EXIT_ERROR || !strings.HasPrefix(msg, want) || !strings.Contains(msg, "Integer division by zero") {
+            t.Errorf("%s: got exit status %d and %q, want %d and an error at %s", engine, code, msg, EXIT_ERROR, want)
+        }
+    }
+}
@@ -0,0 +1,19 @@
+// +build nollvm
+
+package main
+
+import (
+    "errors"
+
+    "github.com/k3v/lyca/src/parser"
+)
+
+var errNoLLVM = errors.New("lyca was built without LLVM, use run -interp or -vm")
+
+func runJIT(tree *parser.AST, cfg *config, argv []string) (int, error) {
+    return 0, errNoLLVM
+}
+
+func startSession(cfg *config) error {
+    return errNoLLVM
+}
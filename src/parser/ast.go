@@ -1,7 +1,9 @@
 package parser
 
 import (
-    "log"
+    "fmt"
+    "io"
+    "os"
     "strconv"
     "github.com/k3v/lyca/src/lexer"
 )
@@ -47,12 +49,14 @@ type TemplateNode struct {
     Constructor *ConstructorNode
     Methods []*FuncDeclNode
     Variables []*VarDeclNode
+    Doc string
 }
 
 type ConstructorNode struct {
     baseNode
     Parameters []*VarDeclNode
     Body *BlockNode
+    Doc string
 }
 
 type ArrayTypeNode struct {
@@ -76,6 +80,7 @@ type VarDeclNode struct {
     Name Identifier
     Type Node
     Value Node
+    Doc string
 }
 
 type MakeExprNode struct {
@@ -168,7 +173,18 @@ type FuncSignatureNode struct {
 
 type FuncDeclNode struct {
     baseNode
+    Export bool
     Function *FuncNode
+    Doc string
+}
+
+// TestNode is a top level test "name" { ... } or bench "name" { ... }
+// block, which only lyca test compiles.
+type TestNode struct {
+    baseNode
+    Bench bool
+    Name Identifier
+    Body *BlockNode
 }
 
 type BlockNode struct {
@@ -208,196 +224,212 @@ type LoopStmtNode struct {
 }
 
 func (p *AST) Print() {
+    p.Fprint(os.Stdout)
+}
+
+// Fprint writes the tree to w, one indented line per node and field.
+func (p *AST) Fprint(w io.Writer) {
     for _, node := range p.Nodes {
-        p.printNode(node, 0)
+        p.printNode(w, node, 0)
     }
 }
 
-func (p *AST) printNode(node Node, pad int) {
+func (p *AST) printNode(w io.Writer, node Node, pad int) {
     if node == nil {
         return
     }
 
     switch node := node.(type) {
     case *VarDeclNode:
-        padPrint("[Var Decl Node]", pad)
-        padPrint("Name: " + node.Name.Value, pad + 1)
-        padPrint("Type: ", pad + 1)
-        p.printNode(node.Type, pad + 2)
+        padPrint(w, "[Var Decl Node]", pad)
+        padPrint(w, "Name: " + node.Name.Value, pad + 1)
+        padPrint(w, "Type: ", pad + 1)
+        p.printNode(w, node.Type, pad + 2)
         if node.Value != nil {
-            padPrint("Value: ", pad + 1)
-            p.printNode(node.Value, pad + 2)
+            padPrint(w, "Value: ", pad + 1)
+            p.printNode(w, node.Value, pad + 2)
         }
     case *FuncTypeNode:
-        padPrint("[Func Type Node]", pad)
-        padPrint("Parameters: ", pad + 1)
+        padPrint(w, "[Func Type Node]", pad)
+        padPrint(w, "Parameters: ", pad + 1)
         for _, param := range node.Parameters {
-            p.printNode(param, pad + 2)
+            p.printNode(w, param, pad + 2)
         }
-        padPrint("Return: ", pad + 1)
-        p.printNode(node.Return, pad + 2)
+        padPrint(w, "Return: ", pad + 1)
+        p.printNode(w, node.Return, pad + 2)
     case *NamedTypeNode:
-        padPrint("[Named Type Node]", pad)
-        padPrint("Type: " + node.Name.Value, pad + 1)
+        padPrint(w, "[Named Type Node]", pad)
+        padPrint(w, "Type: " + node.Name.Value, pad + 1)
     case *ArrayTypeNode:
-        padPrint("[Array Type Node]", pad)
-        padPrint("Member Type: ", pad + 1)
-        p.printNode(node.MemberType, pad + 2)
+        padPrint(w, "[Array Type Node]", pad)
+        padPrint(w, "Member Type: ", pad + 1)
+        p.printNode(w, node.MemberType, pad + 2)
     case *CharLitNode:
-        padPrint("[Char Lit Node]", pad)
-        padPrint("Value: " + string(node.Value), pad + 1)
+        padPrint(w, "[Char Lit Node]", pad)
+        padPrint(w, "Value: " + string(node.Value), pad + 1)
     case *BoolLitNode:
-        padPrint("[Boolean Lit Node]", pad)
-        padPrint("Value: " + strconv.FormatBool(node.Value), pad + 1)
+        padPrint(w, "[Boolean Lit Node]", pad)
+        padPrint(w, "Value: " + strconv.FormatBool(node.Value), pad + 1)
     case *StringLitNode:
-        padPrint("[String Lit Node]", pad)
-        padPrint("Value: " + node.Value, pad + 1)
+        padPrint(w, "[String Lit Node]", pad)
+        padPrint(w, "Value: " + node.Value, pad + 1)
     case *NumLitNode:
-        padPrint("[Num Lit Node]", pad)
+        padPrint(w, "[Num Lit Node]", pad)
         if node.IsFloat {
-            padPrint("Value: " + strconv.FormatFloat(node.FloatValue, 'f', -1, 64), pad + 1)
+            padPrint(w, "Value: " + strconv.FormatFloat(node.FloatValue, 'f', -1, 64), pad + 1)
         } else {
-            padPrint("Value: " + strconv.Itoa(node.IntValue), pad + 1)
+            padPrint(w, "Value: " + strconv.Itoa(node.IntValue), pad + 1)
         }
     case *UnaryExprNode:
-        padPrint("[Unary Expr Node]", pad)
-        padPrint("Operator: " + node.Operator, pad + 1)
-        padPrint("Value: ", pad + 1)
-        p.printNode(node.Value, pad + 2)
+        padPrint(w, "[Unary Expr Node]", pad)
+        padPrint(w, "Operator: " + node.Operator, pad + 1)
+        padPrint(w, "Value: ", pad + 1)
+        p.printNode(w, node.Value, pad + 2)
     case *VarAccessNode:
-        padPrint("[Var Access Node]", pad)
-        padPrint("Name: " + node.Name.Value, pad + 1)
+        padPrint(w, "[Var Access Node]", pad)
+        padPrint(w, "Name: " + node.Name.Value, pad + 1)
     case *ObjectAccessNode:
-        padPrint("[Object Access Node]", pad)
-        padPrint("Object: ", pad + 1)
-        p.printNode(node.Object, pad + 2)
-        padPrint("Member: " + node.Member.Value, pad + 1)
+        padPrint(w, "[Object Access Node]", pad)
+        padPrint(w, "Object: ", pad + 1)
+        p.printNode(w, node.Object, pad + 2)
+        padPrint(w, "Member: " + node.Member.Value, pad + 1)
     case *ArrayAccessNode:
-        padPrint("[Array Access Node]", pad)
-        padPrint("Array: ", pad + 1)
-        p.printNode(node.Array, pad + 2)
-        padPrint("Index: ", pad + 1)
-        p.printNode(node.Index, pad + 2)
+        padPrint(w, "[Array Access Node]", pad)
+        padPrint(w, "Array: ", pad + 1)
+        p.printNode(w, node.Array, pad + 2)
+        padPrint(w, "Index: ", pad + 1)
+        p.printNode(w, node.Index, pad + 2)
     case *CallExprNode:
-        padPrint("[Call Expr Node]", pad)
-        padPrint("Function: ", pad + 1)
-        p.printNode(node.Function, pad + 2)
-        padPrint("Arguments: ", pad + 1)
+        padPrint(w, "[Call Expr Node]", pad)
+        padPrint(w, "Function: ", pad + 1)
+        p.printNode(w, node.Function, pad + 2)
+        padPrint(w, "Arguments: ", pad + 1)
         for _, arg := range node.Arguments {
-            p.printNode(arg, pad + 2)
+            p.printNode(w, arg, pad + 2)
         }
     case *MakeExprNode:
-        padPrint("[Make Expr Node]", pad)
-        padPrint("Template: " + node.Template.Value, pad + 1)
+        padPrint(w, "[Make Expr Node]", pad)
+        padPrint(w, "Template: " + node.Template.Value, pad + 1)
         for _, arg := range node.Arguments {
-            p.printNode(arg, pad + 2)
+            p.printNode(w, arg, pad + 2)
         }
     case *BinaryExprNode:
-        padPrint("[Binary Expr Node]", pad)
-        padPrint("Operator: " + node.Operator.Value, pad + 1)
-        padPrint("Left: ", pad + 1)
-        p.printNode(node.Left, pad + 2)
-        padPrint("Right: ", pad + 1)
-        p.printNode(node.Right, pad + 2)
+        padPrint(w, "[Binary Expr Node]", pad)
+        padPrint(w, "Operator: " + node.Operator.Value, pad + 1)
+        padPrint(w, "Left: ", pad + 1)
+        p.printNode(w, node.Left, pad + 2)
+        padPrint(w, "Right: ", pad + 1)
+        p.printNode(w, node.Right, pad + 2)
     case *FuncDeclNode:
-        padPrint("[Func Decl Node]", pad)
-        padPrint("Function: ", pad + 1)
-        p.printNode(node.Function, pad + 2)
+        padPrint(w, "[Func Decl Node]", pad)
+        if node.Export {
+            padPrint(w, "Export", pad + 1)
+        }
+        padPrint(w, "Function: ", pad + 1)
+        p.printNode(w, node.Function, pad + 2)
+    case *TestNode:
+        padPrint(w, "[Test Node]", pad)
+        if node.Bench {
+            padPrint(w, "Bench", pad + 1)
+        }
+        padPrint(w, "Name: " + node.Name.Value, pad + 1)
+        padPrint(w, "Body: ", pad + 1)
+        p.printNode(w, node.Body, pad + 1)
     case *FuncNode:
-        padPrint("[Func Node]", pad)
-        padPrint("Signature: ", pad + 1)
-        p.printNode(node.Signature, pad + 1)
-        padPrint("Body: ", pad + 1)
-        p.printNode(node.Body, pad + 1)
+        padPrint(w, "[Func Node]", pad)
+        padPrint(w, "Signature: ", pad + 1)
+        p.printNode(w, node.Signature, pad + 1)
+        padPrint(w, "Body: ", pad + 1)
+        p.printNode(w, node.Body, pad + 1)
     case *FuncSignatureNode:
-        padPrint("[Func Signature Node]", pad)
-        padPrint("Name: " + node.Name.Value, pad + 1)
-        padPrint("Parameters: ", pad + 1)
+        padPrint(w, "[Func Signature Node]", pad)
+        padPrint(w, "Name: " + node.Name.Value, pad + 1)
+        padPrint(w, "Parameters: ", pad + 1)
         for _, param := range node.Parameters {
-            p.printNode(param, pad + 2)
+            p.printNode(w, param, pad + 2)
         }
-        padPrint("Return: ", pad + 1)
-        p.printNode(node.Return, pad + 2)
+        padPrint(w, "Return: ", pad + 1)
+        p.printNode(w, node.Return, pad + 2)
     case *BlockNode:
-        padPrint("[Block Node]", pad)
-        padPrint("Nodes: ", pad + 1)
+        padPrint(w, "[Block Node]", pad)
+        padPrint(w, "Nodes: ", pad + 1)
         for _, node := range node.Nodes {
-            p.printNode(node, pad + 2)
+            p.printNode(w, node, pad + 2)
         }
     case *IfStmtNode:
-        padPrint("[If Stmt Node]", pad)
-        padPrint("Condition:", pad + 1)
-        p.printNode(node.Condition, pad + 2)
-        padPrint("Body: ", pad + 1)
-        p.printNode(node.Body, pad + 2)
-        padPrint("Else: ", pad + 1)
-        p.printNode(node.Else, pad + 2)
+        padPrint(w, "[If Stmt Node]", pad)
+        padPrint(w, "Condition:", pad + 1)
+        p.printNode(w, node.Condition, pad + 2)
+        padPrint(w, "Body: ", pad + 1)
+        p.printNode(w, node.Body, pad + 2)
+        padPrint(w, "Else: ", pad + 1)
+        p.printNode(w, node.Else, pad + 2)
     case *LoopStmtNode:
-        padPrint("[Loop Stmt Node]", pad)
+        padPrint(w, "[Loop Stmt Node]", pad)
         if node.Init != nil {
-            padPrint("Init: ", pad + 1)
-            p.printNode(node.Init, pad + 2)
+            padPrint(w, "Init: ", pad + 1)
+            p.printNode(w, node.Init, pad + 2)
         }
         if node.Cond != nil {
-            padPrint("Condition: ", pad + 1)
-            p.printNode(node.Cond, pad + 2)
+            padPrint(w, "Condition: ", pad + 1)
+            p.printNode(w, node.Cond, pad + 2)
         }
         if node.Post != nil {
-            padPrint("Post: ", pad + 1)
-            p.printNode(node.Post, pad + 2)
+            padPrint(w, "Post: ", pad + 1)
+            p.printNode(w, node.Post, pad + 2)
         }
-        padPrint("Body: ", pad + 1)
-        p.printNode(node.Body, pad + 2)
+        padPrint(w, "Body: ", pad + 1)
+        p.printNode(w, node.Body, pad + 2)
     case *CallStmtNode:
-        padPrint("[Call Stmt Node]", pad)
-        padPrint("Expr: ", pad + 1)
-        p.printNode(node.Call, pad + 2)
+        padPrint(w, "[Call Stmt Node]", pad)
+        padPrint(w, "Expr: ", pad + 1)
+        p.printNode(w, node.Call, pad + 2)
     case *ReturnStmtNode:
-        padPrint("[Return Stmt Node]", pad)
-        padPrint("Return: ", pad + 1)
-        p.printNode(node.Value, pad + 2)
+        padPrint(w, "[Return Stmt Node]", pad)
+        padPrint(w, "Return: ", pad + 1)
+        p.printNode(w, node.Value, pad + 2)
     case *AssignStmtNode:
-        padPrint("[Assign Stmt Node]", pad)
-        padPrint("Target: ", pad + 1)
-        p.printNode(node.Target, pad + 2)
-        padPrint("Value: ", pad + 1)
-        p.printNode(node.Value, pad + 2)
+        padPrint(w, "[Assign Stmt Node]", pad)
+        padPrint(w, "Target: ", pad + 1)
+        p.printNode(w, node.Target, pad + 2)
+        padPrint(w, "Value: ", pad + 1)
+        p.printNode(w, node.Value, pad + 2)
     case *FuncLitNode:
-        padPrint("[Func Lit Node]", pad)
-        padPrint("Function: ", pad + 1)
-        p.printNode(node.Function, pad + 2)
+        padPrint(w, "[Func Lit Node]", pad)
+        padPrint(w, "Function: ", pad + 1)
+        p.printNode(w, node.Function, pad + 2)
     case *TemplateNode:
-        padPrint("[Template Node]", pad)
-        padPrint("Name: " + node.Name.Value, pad + 1)
-        padPrint("Constructor: ", pad + 1)
-        p.printNode(node.Constructor, pad + 2)
-        padPrint("Variables: ", pad + 1)
+        padPrint(w, "[Template Node]", pad)
+        padPrint(w, "Name: " + node.Name.Value, pad + 1)
+        padPrint(w, "Constructor: ", pad + 1)
+        p.printNode(w, node.Constructor, pad + 2)
+        padPrint(w, "Variables: ", pad + 1)
         for _, vars := range node.Variables {
-            p.printNode(vars, pad + 2)
+            p.printNode(w, vars, pad + 2)
         }
-        padPrint("Methods: ", pad + 1)
+        padPrint(w, "Methods: ", pad + 1)
         for _, methods := range node.Methods {
-            p.printNode(methods, pad + 2)
+            p.printNode(w, methods, pad + 2)
         }
     case *ConstructorNode:
         if node == nil {
             return
         }
-        padPrint("[Constructor Node]", pad)
-        padPrint("Parameters: ", pad + 1)
+        padPrint(w, "[Constructor Node]", pad)
+        padPrint(w, "Parameters: ", pad + 1)
         for _, param := range node.Parameters {
-            p.printNode(param, pad + 2)
+            p.printNode(w, param, pad + 2)
         }
-        padPrint("Body: ", pad + 1)
-        p.printNode(node.Body, pad + 2)
+        padPrint(w, "Body: ", pad + 1)
+        p.printNode(w, node.Body, pad + 2)
     }
 }
 
-func padPrint(s string, pad int) {
+func padPrint(w io.Writer, s string, pad int) {
     padding := ""
     for ; pad != 0; pad-- {
         padding += "    ";
     }
 
-    log.Println(padding + s)
+    fmt.Fprintln(w, padding + s)
 }
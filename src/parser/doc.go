@@ -0,0 +1,82 @@
+package parser
+
+import (
+    "strings"
+
+    "github.com/k3v/lyca/src/lexer"
+)
+
+// attachDoc gives a template, function, constructor or variable
+// declaration the doc comment written right above its first token:
+// a run of /// lines or one /** */ block, with no blank line between
+// it and the declaration.
+func attachDoc(node Node, first *lexer.Token) {
+    if first == nil {
+        return
+    }
+
+    doc := docComment(first.Leading)
+    switch n := node.(type) {
+    case *TemplateNode:
+        n.Doc = doc
+    case *ConstructorNode:
+        n.Doc = doc
+    case *FuncDeclNode:
+        n.Doc = doc
+    case *VarDeclNode:
+        n.Doc = doc
+    }
+}
+
+func docComment(leading []lexer.Trivia) string {
+    var lines []string
+    newlines := 0
+    for i := len(leading) - 1; i >= 0; i-- {
+        trivia := leading[i]
+        switch {
+        case trivia.Type == lexer.TRIVIA_WHITESPACE:
+            continue
+        case trivia.Type == lexer.TRIVIA_NEWLINE:
+            if newlines++; newlines > 1 {
+                return strings.Join(lines, "\n")
+            }
+            continue
+        case isDocLine(trivia.Content) && (len(lines) == 0 || newlines == 1):
+            line := strings.TrimPrefix(trivia.Content, "///")
+            line = strings.TrimPrefix(line, " ")
+            lines = append([]string{strings.TrimRight(line, " \t\r")}, lines...)
+        case isDocBlock(trivia.Content) && len(lines) == 0:
+            return docBlock(trivia.Content)
+        default:
+            return strings.Join(lines, "\n")
+        }
+        newlines = 0
+    }
+
+    return strings.Join(lines, "\n")
+}
+
+func isDocLine(comment string) bool {
+    return strings.HasPrefix(comment, "///") && !strings.HasPrefix(comment, "////")
+}
+
+func isDocBlock(comment string) bool {
+    return strings.HasPrefix(comment, "/**") && !strings.HasPrefix(comment, "/***") && len(comment) > len("/**/")
+}
+
+// docBlock strips the delimiters of a /** */ comment and the column of
+// asterisks its lines may start with.
+func docBlock(comment string) string {
+    body := strings.TrimSuffix(strings.TrimPrefix(comment, "/**"), "*/")
+
+    var lines []string
+    for _, line := range strings.Split(body, "\n") {
+        line = strings.TrimSpace(line)
+        if strings.HasPrefix(line, "*") {
+            line = strings.TrimPrefix(strings.TrimPrefix(line, "*"), " ")
+        }
+        lines = append(lines, line)
+    }
+
+    return strings.TrimSpace(strings.Join(lines, "\n"))
+}
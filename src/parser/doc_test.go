@@ -0,0 +1,91 @@
+package parser
+
+import (
+    "testing"
+)
+
+const DOC_FUNC = "func (int x) > f > (int) {\n    return x;\n}\n"
+
+func TestDocAttachment(t *testing.T) {
+    tests := []struct {
+        name string
+        src  string
+        want string
+    }{
+        {"line comments", "/// Returns x.\n///\n///   Indented.\n" + DOC_FUNC, "Returns x.\n\n  Indented."},
+        {"block comment", "/**\n * Returns x.\n *\n * Really.\n */\n" + DOC_FUNC, "Returns x.\n\nReally."},
+        {"block on the same line", "/** Returns x. */ " + DOC_FUNC, "Returns x."},
+        {"blank line before the declaration", "/// Lost.\n\n" + DOC_FUNC, ""},
+        {"blank line inside a run", "/// Lost.\n\n/// Kept.\n" + DOC_FUNC, "Kept."},
+        {"four slashes", "//// Banner.\n" + DOC_FUNC, ""},
+        {"four slashes end a run", "/// Lost.\n//// Banner.\n/// Kept.\n" + DOC_FUNC, "Kept."},
+        {"three asterisks", "/*** Banner. ***/\n" + DOC_FUNC, ""},
+        {"empty block", "/**/\n" + DOC_FUNC, ""},
+        {"plain comment", "// Note.\n" + DOC_FUNC, ""},
+        {"plain comment above", "// Note.\n/// Kept.\n" + DOC_FUNC, "Kept."},
+        {"plain comment between", "/// Lost.\n// Note.\n" + DOC_FUNC, ""},
+        {"trailing comment of the previous line", "int y; /// About y.\n" + DOC_FUNC, ""},
+        {"block before a run", "/** Lost. */\n/// Kept.\n" + DOC_FUNC, "Kept."},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            tree, err := parse(t, test.src)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            fn := tree.Nodes[len(tree.Nodes) - 1].(*FuncDeclNode)
+            if fn.Doc != test.want {
+                t.Errorf("got %q, want %q", fn.Doc, test.want)
+            }
+            for _, node := range tree.Nodes[:len(tree.Nodes) - 1] {
+                if v, ok := node.(*VarDeclNode); ok && v.Doc != "" {
+                    t.Errorf("a trailing comment documents %s with %q", v.Name.Value, v.Doc)
+                }
+            }
+        })
+    }
+}
+
+// Template members take the comments above them, not the ones trailing
+// the member before.
+func TestDocMembers(t *testing.T) {
+    tree, err := parse(t, `/// A point.
+tmpl Point {
+    /// The x coordinate.
+    int x;
+    int y; /// Not about z.
+    int z;
+
+    /** Makes a point. */
+    constructor < () {
+    }
+
+    /// Norm of the point.
+    func () > norm > (int) {
+        return this.x;
+    }
+}`)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tmpl := tree.Nodes[0].(*TemplateNode)
+    got := []string{tmpl.Doc}
+    for _, v := range tmpl.Variables {
+        got = append(got, v.Doc)
+    }
+    got = append(got, tmpl.Constructor.Doc, tmpl.Methods[0].Doc)
+
+    want := []string{"A point.", "The x coordinate.", "", "", "Makes a point.", "Norm of the point."}
+    if len(got) != len(want) {
+        t.Fatalf("got %q, want %q", got, want)
+    }
+    for i := range want {
+        if got[i] != want[i] {
+            t.Errorf("got %q, want %q", got, want)
+            break
+        }
+    }
+}
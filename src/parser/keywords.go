@@ -8,4 +8,7 @@ const (
     KEYWORD_IF          string = "if"
     KEYWORD_ELSE        string = "else"
     KEYWORD_FOR         string = "for"
+    KEYWORD_EXPORT      string = "export"
+    KEYWORD_TEST        string = "test"
+    KEYWORD_BENCH       string = "bench"
 )
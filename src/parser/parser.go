@@ -1,7 +1,6 @@
 package parser
 
 import (
-    "log"
     "strings"
     "strconv"
     "github.com/k3v/lyca/src/lexer"
@@ -23,22 +22,55 @@ type parser struct {
     curr   int
 }
 
-func Parse(tokens []*lexer.Token) *AST {
-    p := &parser{
-        Tree: &AST{},
-        tokens: tokens,
-        curr: 0,
-    }
+func Parse(tokens []*lexer.Token) (tree *AST, err error) {
+    p := newParser(tokens)
+    defer lexer.Recover(&err)
 
     for p.peek(0) != nil {
         p.parse()
     }
 
-    return p.Tree
+    return p.Tree, nil
+}
+
+func ParseExpr(tokens []*lexer.Token) (expr Node, err error) {
+    p := newParser(tokens)
+    defer lexer.Recover(&err)
+
+    if expr = p.parseExpr(); expr == nil {
+        p.fail("Expected an expression")
+    }
+    p.expectEnd()
+
+    return expr, nil
+}
+
+func ParseStatements(tokens []*lexer.Token) (nodes []Node, err error) {
+    p := newParser(tokens)
+    defer lexer.Recover(&err)
+
+    for p.peek(0) != nil {
+        node := p.parseNode()
+        if node == nil {
+            p.fail("Unexpected token " + p.peek(0).Content)
+        }
+        nodes = append(nodes, node)
+    }
+
+    return nodes, nil
 }
 
+func newParser(tokens []*lexer.Token) *parser {
+    return &parser{
+        Tree: &AST{},
+        tokens: tokens,
+        curr: 0,
+    }
+}
+
+// peek returns nil past the last token, the same as for TOKEN_EOF.
 func (p *parser) peek(ahead int) *lexer.Token {
-    if p.curr + ahead >= len(p.tokens) {
+    if p.curr + ahead >= len(p.tokens) || p.tokens[p.curr + ahead].Type == lexer.TOKEN_EOF {
         return nil
     }
 
@@ -78,21 +110,55 @@ func (p *parser) matchTokens(tokens ...interface{}) bool {
 
 func (p *parser) expect(t lexer.TokenType, content string) *lexer.Token {
     if !p.matchToken(0, t, content) {
-        log.Fatal(p.peek(0).Location.Start.Line, ":", p.peek(0).Location.Start.Offset, " Unexpected token ", p.peek(0).Content, " Expected ", content)
+        expected := content
+        if expected == "" {
+            expected = strings.ToLower(lexer.TOKEN_NAMES[t])
+        }
+
+        if p.peek(0) == nil {
+            p.fail("Unexpected end of file Expected " + expected)
+        }
+        p.fail("Unexpected token " + p.peek(0).Content + " Expected " + expected)
     }
 
     return p.consume()
 }
 
+func (p *parser) expectEnd() {
+    if p.peek(0) != nil {
+        p.fail("Unexpected token " + p.peek(0).Content)
+    }
+}
+
+func (p *parser) fail(message string) {
+    var loc lexer.Span
+    if tok := p.peek(0); tok != nil {
+        loc = tok.Location
+    } else if len(p.tokens) > 0 {
+        end := p.tokens[len(p.tokens) - 1].Location.End
+        loc = lexer.Span{end, end}
+    }
+
+    lexer.Fail(loc, message)
+}
+
 func (p *parser) parse() {
-    if node := p.parseDecl(); node != nil {
-        p.Tree.AddNode(node)
+    node := p.parseDecl()
+    if node == nil {
+        p.fail("Unexpected token " + p.peek(0).Content)
     }
+
+    p.Tree.AddNode(node)
 }
 
 func (p *parser) parseDecl() (node Node) {
+    first := p.peek(0)
     if tmplNode := p.parseTemplateDecl(); tmplNode != nil {
         node =  tmplNode
+    } else if exportNode := p.parseExportDecl(); exportNode != nil {
+        node = exportNode
+    } else if testNode := p.parseTestDecl(); testNode != nil {
+        node = testNode
     } else if funcNode := p.parseFuncDecl(); funcNode != nil {
         node = funcNode
     } else if varNode := p.parseVarDecl(); varNode != nil {
@@ -100,14 +166,11 @@ func (p *parser) parseDecl() (node Node) {
         p.expect(lexer.TOKEN_SEPARATOR, ";")
     }
 
+    attachDoc(node, first)
     return node
 }
 
 func (p *parser) parseBlock() (res *BlockNode) {
-    if !p.matchToken(0, lexer.TOKEN_SEPARATOR, "{") {
-        return
-    }
-
     start := p.expect(lexer.TOKEN_SEPARATOR, "{")
     var nodes []Node
     for {
@@ -152,13 +215,19 @@ func (p *parser) parseTemplateDecl() (res *TemplateNode) {
             break
         }
 
+        first := p.peek(0)
         if construct := p.parseConstructor(); construct != nil {
             res.Constructor = construct
+            attachDoc(construct, first)
         } else if method := p.parseFuncDecl(); method != nil {
             res.Methods = append(res.Methods, method)
+            attachDoc(method, first)
         } else if variable := p.parseVarDecl(); variable != nil {
             res.Variables = append(res.Variables, variable)
+            attachDoc(variable, first)
             p.expect(lexer.TOKEN_SEPARATOR, ";")
+        } else {
+            p.expect(lexer.TOKEN_SEPARATOR, "}")
         }
     }
     end := p.expect(lexer.TOKEN_SEPARATOR, "}")
@@ -180,6 +249,9 @@ func (p *parser) parseConstructor() (res *ConstructorNode) {
         }
 
         decl := p.parseVarDecl()
+        if decl == nil {
+            p.fail("Expected a parameter declaration")
+        }
         params = append(params, decl)
 
         if !p.matchToken(0, lexer.TOKEN_SEPARATOR, ",") {
@@ -206,6 +278,36 @@ func (p *parser) parseFuncDecl() (res *FuncDeclNode) {
     return
 }
 
+func (p *parser) parseExportDecl() (res *FuncDeclNode) {
+    if !p.matchToken(0, lexer.TOKEN_IDENTIFIER, KEYWORD_EXPORT) {
+        return
+    }
+    start := p.consume()
+
+    res = p.parseFuncDecl()
+    if res == nil {
+        p.fail("Expected a function declaration after export")
+    }
+    res.Export = true
+    res.SetLoc(lexer.Span{start.Location.Start, res.Loc().End})
+    return
+}
+
+// parseTestDecl parses test "name" { ... } and bench "name" { ... }. test
+// and bench are only keywords when a string follows them.
+func (p *parser) parseTestDecl() (res *TestNode) {
+    if !p.matchToken(0, lexer.TOKEN_IDENTIFIER, KEYWORD_TEST, KEYWORD_BENCH) || !p.matchToken(1, lexer.TOKEN_STRING, "") {
+        return
+    }
+    start := p.consume()
+    name := NewIdentifier(p.consume())
+    body := p.parseBlock()
+
+    res = &TestNode{Bench: start.Content == KEYWORD_BENCH, Name: name, Body: body}
+    res.SetLoc(lexer.Span{start.Location.Start, body.Loc().End})
+    return
+}
+
 func (p *parser) parseFunc(anon bool) (res *FuncNode) {
     sig := p.parseFuncSignature(anon)
     if sig == nil {
@@ -247,6 +349,11 @@ func (p *parser) parseFuncSignature(anon bool) (res *FuncSignatureNode) {
     p.expect(lexer.TOKEN_SEPARATOR, ")")
     p.expect(lexer.TOKEN_OPERATOR, ">")
 
+    // without a name this is the type of a function variable
+    if !anon && !p.matchToken(0, lexer.TOKEN_IDENTIFIER, "") {
+        goto rollback
+    }
+
     res = &FuncSignatureNode{Parameters: params}
     if !anon {
         res.Name = NewIdentifier(p.expect(lexer.TOKEN_IDENTIFIER, ""))
@@ -255,7 +362,7 @@ func (p *parser) parseFuncSignature(anon bool) (res *FuncSignatureNode) {
 
     p.expect(lexer.TOKEN_SEPARATOR, "(")
     if !p.matchToken(0, lexer.TOKEN_SEPARATOR, ")") {
-        res.Return = p.parseTypeReference()
+        res.Return = p.expectType()
     }
     end = p.expect(lexer.TOKEN_SEPARATOR, ")")
 
@@ -293,7 +400,7 @@ func (p *parser) parseIfStmt() (res *IfStmtNode) {
 
     token := p.consume()
     p.expect(lexer.TOKEN_SEPARATOR, "(")
-    cond := p.parseExpr()
+    cond := p.expectExpr()
     p.expect(lexer.TOKEN_SEPARATOR, ")")
     body := p.parseBlock()
 
@@ -307,7 +414,7 @@ func (p *parser) parseIfStmt() (res *IfStmtNode) {
         p.consume()
         if p.matchToken(0, lexer.TOKEN_IDENTIFIER, KEYWORD_IF) {
             res.Else = p.parseIfStmt()
-        } else if p.matchToken(0, lexer.TOKEN_SEPARATOR, "{") {
+        } else {
             res.Else = p.parseBlock()
         }
         loc.End = res.Else.Loc().End
@@ -369,7 +476,7 @@ func (p *parser) parseReturnStmt() (res *ReturnStmtNode) {
     )
 
     if !p.matchToken(0, lexer.TOKEN_SEPARATOR, ";") {
-        expr = p.parseExpr()
+        expr = p.expectExpr()
         end  = expr.Loc().End
     }
 
@@ -388,7 +495,7 @@ func (p *parser) parseAssignStmt() (res *AssignStmtNode) {
     }
 
     p.consume()
-    value := p.parseExpr()
+    value := p.expectExpr()
 
     res = &AssignStmtNode{Target: target, Value: value}
     res.SetLoc(lexer.Span{target.Loc().Start, value.Loc().End})
@@ -396,8 +503,10 @@ func (p *parser) parseAssignStmt() (res *AssignStmtNode) {
 }
 
 func (p *parser) parseVarDecl() (res *VarDeclNode) {
+    rollback := p.curr
     t := p.parseTypeReference()
     if t == nil || !p.matchToken(0, lexer.TOKEN_IDENTIFIER, "") {
+        p.curr = rollback
         return
     }
     name := NewIdentifier(p.consume())
@@ -406,7 +515,7 @@ func (p *parser) parseVarDecl() (res *VarDeclNode) {
     if p.matchToken(0, lexer.TOKEN_OPERATOR, "=") {
         p.consume()
 
-        value = p.parseExpr()
+        value = p.expectExpr()
     }
 
     res = &VarDeclNode{
@@ -431,13 +540,22 @@ func (p *parser) parseTypeReference() (node Node) {
         node = p.parseFuncType()
     } else if p.matchToken(0, lexer.TOKEN_SEPARATOR, "[") {
         node = p.parseArrayType()
-    } else {
-        node = p.parseNamedType()
+    } else if named := p.parseNamedType(); named != nil {
+        node = named
     }
 
     return
 }
 
+func (p *parser) expectType() Node {
+    t := p.parseTypeReference()
+    if t == nil {
+        p.fail("Expected a type")
+    }
+
+    return t
+}
+
 func (p *parser) parseArrayType() (res *ArrayTypeNode) {
     if !p.matchToken(0, lexer.TOKEN_SEPARATOR, "[") {
         return
@@ -445,7 +563,7 @@ func (p *parser) parseArrayType() (res *ArrayTypeNode) {
     start := p.consume()
     p.expect(lexer.TOKEN_SEPARATOR, "]")
 
-    t := p.parseTypeReference()
+    t := p.expectType()
 
     res = &ArrayTypeNode{MemberType: t}
     res.SetLoc(lexer.Span{start.Location.Start, t.Loc().End})
@@ -463,7 +581,7 @@ func (p *parser) parseFuncType() (res *FuncTypeNode) {
     p.expect(lexer.TOKEN_SEPARATOR, "(")
     var ret Node
     if !p.matchToken(0, lexer.TOKEN_SEPARATOR, ")") {
-        ret = p.parseTypeReference()
+        ret = p.expectType()
     }
     end := p.expect(lexer.TOKEN_SEPARATOR, ")")
 
@@ -478,7 +596,7 @@ func (p *parser) parseTypes() (types []Node) {
             break
         }
 
-        types = append(types, p.parseTypeReference())
+        types = append(types, p.expectType())
 
         if !p.matchToken(0, lexer.TOKEN_SEPARATOR, ",") {
             break
@@ -513,6 +631,24 @@ func (p *parser) parseExpr() (res Node) {
     return
 }
 
+func (p *parser) expectExpr() Node {
+    expr := p.parseExpr()
+    if expr == nil {
+        p.fail("Expected an expression")
+    }
+
+    return expr
+}
+
+func (p *parser) expectPostfixExpr() Node {
+    expr := p.parsePostfixExpr()
+    if expr == nil {
+        p.fail("Expected an expression")
+    }
+
+    return expr
+}
+
 func (p *parser) parseBinaryExpr(expr Node, min int) Node {
     if !p.matchToken(0, lexer.TOKEN_OPERATOR, "") {
         return nil
@@ -543,16 +679,16 @@ func (p *parser) parseBinaryExpr(expr Node, min int) Node {
             }
         }
 
+        // every node folded to the left spans from the first operand
+        loc.End = right.Loc().End
         expr = &BinaryExprNode{
             Operator: NewIdentifier(operator),
             Left: expr,
             Right: right,
         }
-
-        loc.End = right.Loc().End
+        expr.SetLoc(loc)
     }
 
-    expr.SetLoc(loc)
     return expr
 
 rollback:
@@ -592,7 +728,7 @@ func (p *parser) parseObjectAccess(obj Node) (res *ObjectAccessNode) {
 
 func (p *parser) parseArrayAccess(arr Node) (res *ArrayAccessNode) {
     p.consume()
-    index := p.parseExpr()
+    index := p.expectExpr()
     end := p.expect(lexer.TOKEN_SEPARATOR, "]")
 
     res = &ArrayAccessNode{Array: arr, Index: index}
@@ -617,7 +753,7 @@ func (p *parser) parseArguments() (args []Node) {
             break
         }
 
-        args = append(args, p.parseExpr())
+        args = append(args, p.expectExpr())
 
         if !p.matchToken(0, lexer.TOKEN_SEPARATOR, ",") {
             break
@@ -631,7 +767,7 @@ func (p *parser) parseArguments() (args []Node) {
 func (p *parser) parsePrimaryExpr() (res Node) {
     if p.matchToken(0, lexer.TOKEN_SEPARATOR, "(") {
         p.consume()
-        res = p.parseExpr();
+        res = p.expectExpr();
         p.expect(lexer.TOKEN_SEPARATOR, ")")
     } else if makeExpr := p.parseMakeExpr(); makeExpr != nil {
         res = makeExpr
@@ -683,7 +819,7 @@ func (p *parser) parseUnaryExpr() (res *UnaryExprNode) {
         return
     }
     operator := p.consume()
-    value := p.parsePostfixExpr()
+    value := p.expectPostfixExpr()
 
     res = &UnaryExprNode{Value: value, Operator: operator.Content}
     res.SetLoc(lexer.Span{operator.Location.Start, value.Loc().End})
@@ -709,8 +845,6 @@ func (p *parser) parseFuncLit() (res *FuncLitNode) {
 
     res = &FuncLitNode{Function: function}
     res.SetLoc(function.Loc())
-    log.Println("Returning func lit")
-    log.Println(p.curr)
     return
 }
 
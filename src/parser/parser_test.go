@@ -0,0 +1,91 @@
+package parser
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/k3v/lyca/src/lexer"
+)
+
+func parse(t *testing.T, src string) (*AST, error) {
+    toks, err := lexer.Lex(lexer.NewFile([]byte(src)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return Parse(toks)
+}
+
+// Each of these used to stop the compiler with log.Fatal, loop forever
+// or crash on a nil node. Errors at a name that is not a declaration
+// point at the name, as parseVarDecl gives back what it read.
+func TestParseErrors(t *testing.T) {
+    tests := []struct {
+        name    string
+        src     string
+        message string
+        line    int
+        offset  int
+    }{
+        {"if without block", "func () > f > () { if (true) return; }",
+            "Unexpected token return Expected {", 1, 30},
+        {"else without block", "func () > f > () { if (true) {} else return; }",
+            "Unexpected token return Expected {", 1, 38},
+        {"missing expression", "func () > f > () { int x = ; }",
+            "Expected an expression", 1, 28},
+        {"missing condition", "func () > f > () { if () {} }",
+            "Expected an expression", 1, 24},
+        {"array without member type", "func () > f > ([]) {}",
+            "Expected a type", 1, 18},
+        {"statement in template", "tmpl A { 1; }",
+            "Unexpected token 1 Expected }", 1, 10},
+        {"missing parameter", "tmpl A { constructor < (1) {} }",
+            "Expected a parameter declaration", 1, 25},
+        {"statement at top level", "x = 1;",
+            "Unexpected token x", 1, 1},
+        {"expression statement", "func () > f > () { x; }",
+            "Unexpected token x Expected }", 1, 20},
+        {"assignment in template", "tmpl A { x = 1; }",
+            "Unexpected token x Expected }", 1, 10},
+        {"end of file", "func () > f > () {",
+            "Unexpected end of file Expected }", 1, 19},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            _, err := parse(t, test.src)
+            e, ok := err.(*lexer.Error)
+            if !ok {
+                t.Fatalf("got error %v, want a *lexer.Error", err)
+            }
+
+            start := e.Location.Start
+            if e.Message != test.message || start.Line != test.line || start.Offset != test.offset {
+                t.Errorf("got %d:%d %s, want %d:%d %s", start.Line, start.Offset, e.Message,
+                    test.line, test.offset, test.message)
+            }
+        })
+    }
+}
+
+// Every operator node spans its operands, including those folded to the
+// left of a chain.
+func TestBinaryExprSpans(t *testing.T) {
+    src := "int x = a - b * c - d + e / f;"
+    tree, err := parse(t, src)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var got []string
+    Inspect(tree.Nodes[0], func(node Node) bool {
+        if n, ok := node.(*BinaryExprNode); ok {
+            got = append(got, src[n.Loc().Start.Raw:n.Loc().End.Raw])
+        }
+        return true
+    })
+
+    want := []string{"a - b * c - d + e / f", "a - b * c - d", "a - b * c", "b * c", "e / f"}
+    if strings.Join(got, "|") != strings.Join(want, "|") {
+        t.Errorf("got spans %q, want %q", got, want)
+    }
+}
@@ -0,0 +1,309 @@
+package parser
+
+import (
+    "fmt"
+    "sort"
+)
+
+// A Visitor's Visit is called for every node Walk reaches. If it returns
+// a visitor w, Walk visits the children of node with w and then calls
+// w.Visit(nil).
+type Visitor interface {
+    Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree below node depth first, children in source
+// order. Absent optional children, such as a missing else branch, are
+// not visited.
+func Walk(v Visitor, node Node) {
+    if v = v.Visit(node); v == nil {
+        return
+    }
+
+    switch n := node.(type) {
+    case *AST:
+        walkList(v, n.Nodes)
+    case *TemplateNode:
+        walkList(v, n.Members())
+    case *ConstructorNode:
+        walkParams(v, n.Parameters)
+        Walk(v, n.Body)
+    case *FuncDeclNode:
+        Walk(v, n.Function)
+    case *TestNode:
+        Walk(v, n.Body)
+    case *FuncNode:
+        Walk(v, n.Signature)
+        Walk(v, n.Body)
+    case *FuncSignatureNode:
+        walkParams(v, n.Parameters)
+        walkOptional(v, n.Return)
+    case *VarDeclNode:
+        Walk(v, n.Type)
+        walkOptional(v, n.Value)
+
+    case *NamedTypeNode:
+    case *ArrayTypeNode:
+        Walk(v, n.MemberType)
+    case *FuncTypeNode:
+        walkList(v, n.Parameters)
+        walkOptional(v, n.Return)
+
+    case *BlockNode:
+        walkList(v, n.Nodes)
+    case *ReturnStmtNode:
+        walkOptional(v, n.Value)
+    case *CallStmtNode:
+        Walk(v, n.Call)
+    case *AssignStmtNode:
+        Walk(v, n.Target)
+        Walk(v, n.Value)
+    case *IfStmtNode:
+        Walk(v, n.Condition)
+        Walk(v, n.Body)
+        walkOptional(v, n.Else)
+    case *LoopStmtNode:
+        if n.Init != nil {
+            Walk(v, n.Init)
+        }
+        walkOptional(v, n.Cond)
+        walkOptional(v, n.Post)
+        Walk(v, n.Body)
+
+    case *BoolLitNode, *NumLitNode, *CharLitNode, *StringLitNode, *VarAccessNode:
+    case *FuncLitNode:
+        Walk(v, n.Function)
+    case *FuncExprNode:
+        Walk(v, n.Function)
+    case *UnaryExprNode:
+        Walk(v, n.Value)
+    case *BinaryExprNode:
+        Walk(v, n.Left)
+        Walk(v, n.Right)
+    case *ObjectAccessNode:
+        Walk(v, n.Object)
+    case *ArrayAccessNode:
+        Walk(v, n.Array)
+        Walk(v, n.Index)
+    case *CallExprNode:
+        Walk(v, n.Function)
+        walkList(v, n.Arguments)
+    case *MakeExprNode:
+        walkList(v, n.Arguments)
+
+    default:
+        panic(fmt.Sprintf("parser.Walk: unexpected node type %T", n))
+    }
+
+    v.Visit(nil)
+}
+
+func walkList(v Visitor, nodes []Node) {
+    for _, node := range nodes {
+        Walk(v, node)
+    }
+}
+
+func walkParams(v Visitor, params []*VarDeclNode) {
+    for _, param := range params {
+        Walk(v, param)
+    }
+}
+
+func walkOptional(v Visitor, node Node) {
+    if node != nil {
+        Walk(v, node)
+    }
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+    if f(node) {
+        return f
+    }
+    return nil
+}
+
+// Inspect calls f for every node below and including node, in the order
+// Walk visits them. The children of a node are skipped when f returns
+// false for it, otherwise f(nil) follows them.
+func Inspect(node Node, f func(Node) bool) {
+    Walk(inspector(f), node)
+}
+
+// Members returns the constructor, variables and methods of a template
+// in the order they were written.
+func (n *TemplateNode) Members() []Node {
+    var nodes []Node
+    if n.Constructor != nil {
+        nodes = append(nodes, n.Constructor)
+    }
+    for _, v := range n.Variables {
+        nodes = append(nodes, v)
+    }
+    for _, meth := range n.Methods {
+        nodes = append(nodes, meth)
+    }
+
+    sort.SliceStable(nodes, func(i, j int) bool {
+        return nodes[i].Loc().Start.Raw < nodes[j].Loc().Start.Raw
+    })
+    return nodes
+}
+
+// Rewrite replaces every node below and including node with what f
+// returns for it, children before their parents, and returns the new
+// root. The tree is changed in place. Returning the node unchanged
+// keeps it. Returning nil removes it from a list, such as the statements
+// of a block, or clears an optional child, such as an else branch; it
+// panics for a child that is required, such as the body of a function.
+// A replacement for a child whose field has a concrete node type must
+// have that type.
+func Rewrite(node Node, f func(Node) Node) Node {
+    return rewriter(f).node(node)
+}
+
+type rewriter func(Node) Node
+
+func (r rewriter) node(node Node) Node {
+    if node == nil {
+        return nil
+    }
+
+    switch n := node.(type) {
+    case *AST:
+        n.Nodes = r.list(n.Nodes)
+    case *TemplateNode:
+        if n.Constructor != nil {
+            if res := r.node(n.Constructor); res != nil {
+                n.Constructor = res.(*ConstructorNode)
+            } else {
+                n.Constructor = nil
+            }
+        }
+        n.Variables = r.params(n.Variables)
+
+        var methods []*FuncDeclNode
+        for _, meth := range n.Methods {
+            if res := r.node(meth); res != nil {
+                methods = append(methods, res.(*FuncDeclNode))
+            }
+        }
+        n.Methods = methods
+    case *ConstructorNode:
+        n.Parameters = r.params(n.Parameters)
+        n.Body = r.block(n, n.Body)
+    case *FuncDeclNode:
+        n.Function = r.required(n, n.Function).(*FuncNode)
+    case *TestNode:
+        n.Body = r.block(n, n.Body)
+    case *FuncNode:
+        n.Signature = r.required(n, n.Signature).(*FuncSignatureNode)
+        n.Body = r.block(n, n.Body)
+    case *FuncSignatureNode:
+        n.Parameters = r.params(n.Parameters)
+        n.Return = r.node(n.Return)
+    case *VarDeclNode:
+        n.Type = r.required(n, n.Type)
+        n.Value = r.node(n.Value)
+
+    case *NamedTypeNode:
+    case *ArrayTypeNode:
+        n.MemberType = r.required(n, n.MemberType)
+    case *FuncTypeNode:
+        n.Parameters = r.list(n.Parameters)
+        n.Return = r.node(n.Return)
+
+    case *BlockNode:
+        n.Nodes = r.list(n.Nodes)
+    case *ReturnStmtNode:
+        n.Value = r.node(n.Value)
+    case *CallStmtNode:
+        n.Call = r.required(n, n.Call).(*CallExprNode)
+    case *AssignStmtNode:
+        n.Target = r.required(n, n.Target)
+        n.Value = r.required(n, n.Value)
+    case *IfStmtNode:
+        n.Condition = r.required(n, n.Condition)
+        n.Body = r.block(n, n.Body)
+        n.Else = r.node(n.Else)
+    case *LoopStmtNode:
+        if n.Init != nil {
+            if res := r.node(n.Init); res != nil {
+                n.Init = res.(*VarDeclNode)
+            } else {
+                n.Init = nil
+            }
+        }
+        n.Cond = r.node(n.Cond)
+        n.Post = r.node(n.Post)
+        n.Body = r.block(n, n.Body)
+
+    case *BoolLitNode, *NumLitNode, *CharLitNode, *StringLitNode, *VarAccessNode:
+    case *FuncLitNode:
+        n.Function = r.required(n, n.Function)
+    case *FuncExprNode:
+        n.Function = r.required(n, n.Function)
+    case *UnaryExprNode:
+        n.Value = r.required(n, n.Value)
+    case *BinaryExprNode:
+        n.Left = r.required(n, n.Left)
+        n.Right = r.required(n, n.Right)
+    case *ObjectAccessNode:
+        n.Object = r.required(n, n.Object)
+    case *ArrayAccessNode:
+        n.Array = r.required(n, n.Array)
+        n.Index = r.required(n, n.Index)
+    case *CallExprNode:
+        n.Function = r.required(n, n.Function)
+        n.Arguments = r.list(n.Arguments)
+    case *MakeExprNode:
+        n.Arguments = r.list(n.Arguments)
+
+    default:
+        panic(fmt.Sprintf("parser.Rewrite: unexpected node type %T", n))
+    }
+
+    return r(node)
+}
+
+// required rewrites a child parent can't do without.
+func (r rewriter) required(parent, child Node) Node {
+    res := r.node(child)
+    if res == nil {
+        panic(fmt.Sprintf("parser.Rewrite: %T of a %T can't be removed", child, parent))
+    }
+
+    return res
+}
+
+func (r rewriter) list(nodes []Node) []Node {
+    var res []Node
+    for _, node := range nodes {
+        if node = r.node(node); node != nil {
+            res = append(res, node)
+        }
+    }
+
+    return res
+}
+
+func (r rewriter) params(params []*VarDeclNode) []*VarDeclNode {
+    var res []*VarDeclNode
+    for _, param := range params {
+        if node := r.node(param); node != nil {
+            res = append(res, node.(*VarDeclNode))
+        }
+    }
+
+    return res
+}
+
+func (r rewriter) block(parent Node, block *BlockNode) *BlockNode {
+    if block == nil {
+        return nil
+    }
+
+    return r.required(parent, block).(*BlockNode)
+}
@@ -0,0 +1,199 @@
+package parser
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+)
+
+const WALK_PROGRAM = `tmpl A {
+    int x;
+
+    func () > get > (int) {
+        return this.x;
+    }
+}
+
+func (int n) > f > (int) {
+    if (n > 1) {
+        printf("%d", n);
+    } else {
+        return -n;
+    }
+    return n * 2 + 1;
+}`
+
+func walkTree(t *testing.T) *AST {
+    tree, err := parse(t, WALK_PROGRAM)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return tree
+}
+
+// kind names a node by its type without the package and Node suffix.
+func kind(node Node) string {
+    return strings.TrimSuffix(strings.TrimPrefix(fmt.Sprintf("%T", node), "*parser."), "Node")
+}
+
+// trace lists the nodes Inspect visits, closing each with ) when its
+// children are done.
+func trace(node Node, prune func(Node) bool) string {
+    var parts []string
+    Inspect(node, func(n Node) bool {
+        if n == nil {
+            parts = append(parts, ")")
+            return false
+        }
+        parts = append(parts, kind(n))
+        return prune == nil || !prune(n)
+    })
+    return strings.Join(parts, " ")
+}
+
+func TestInspectOrder(t *testing.T) {
+    tree := walkTree(t)
+
+    want := "AST " +
+        "Template VarDecl NamedType ) ) " +
+        "FuncDecl Func FuncSignature NamedType ) ) " +
+        "Block ReturnStmt ObjectAccess VarAccess ) ) ) ) ) ) ) " +
+        "FuncDecl Func FuncSignature VarDecl NamedType ) ) NamedType ) ) " +
+        "Block " +
+        "IfStmt BinaryExpr VarAccess ) NumLit ) ) " +
+        "Block CallStmt CallExpr VarAccess ) StringLit ) VarAccess ) ) ) ) " +
+        "Block ReturnStmt UnaryExpr VarAccess ) ) ) ) ) " +
+        "ReturnStmt BinaryExpr BinaryExpr VarAccess ) NumLit ) ) NumLit ) ) ) " +
+        ") ) ) )"
+    if got := trace(tree, nil); got != want {
+        t.Errorf("got\n%s\nwant\n%s", got, want)
+    }
+}
+
+// A node whose children are skipped gets no closing call either.
+func TestInspectPrune(t *testing.T) {
+    tree := walkTree(t)
+
+    got := trace(tree, func(n Node) bool {
+        _, ok := n.(*BlockNode)
+        return ok
+    })
+    want := "AST " +
+        "Template VarDecl NamedType ) ) FuncDecl Func FuncSignature NamedType ) ) Block ) ) ) " +
+        "FuncDecl Func FuncSignature VarDecl NamedType ) ) NamedType ) ) Block ) ) )"
+    if got != want {
+        t.Errorf("got\n%s\nwant\n%s", got, want)
+    }
+}
+
+// Children are rewritten before their parents, so folding constants
+// folds a whole chain.
+func TestRewriteReplace(t *testing.T) {
+    tree := walkTree(t)
+    ret := tree.Nodes[1].(*FuncDeclNode).Function.Body.Nodes[1].(*ReturnStmtNode)
+    ret.Value.(*BinaryExprNode).Left.(*BinaryExprNode).Left = &NumLitNode{IntValue: 3}
+
+    var order []string
+    Rewrite(ret, func(n Node) Node {
+        order = append(order, kind(n))
+        bin, ok := n.(*BinaryExprNode)
+        if !ok {
+            return n
+        }
+
+        left, lok := bin.Left.(*NumLitNode)
+        right, rok := bin.Right.(*NumLitNode)
+        if !lok || !rok {
+            return n
+        }
+        switch bin.Operator.Value {
+        case "*":
+            return &NumLitNode{IntValue: left.IntValue * right.IntValue}
+        case "+":
+            return &NumLitNode{IntValue: left.IntValue + right.IntValue}
+        }
+        return n
+    })
+
+    if got := strings.Join(order, " "); got != "NumLit NumLit BinaryExpr NumLit BinaryExpr ReturnStmt" {
+        t.Errorf("got rewrite order %s", got)
+    }
+    if lit, ok := ret.Value.(*NumLitNode); !ok || lit.IntValue != 7 {
+        t.Errorf("got %#v, want the literal 7", ret.Value)
+    }
+}
+
+// Returning nil removes a node from a list or clears an optional child.
+func TestRewriteRemove(t *testing.T) {
+    tree := walkTree(t)
+    Rewrite(tree, func(n Node) Node {
+        switch n := n.(type) {
+        case *CallStmtNode:
+            return nil
+        case *IfStmtNode:
+            // drop the else branch
+            n.Else = nil
+        case *VarDeclNode:
+            if n.Name.Value == "x" {
+                return nil
+            }
+        }
+        return n
+    })
+
+    if vars := tree.Nodes[0].(*TemplateNode).Variables; len(vars) != 0 {
+        t.Errorf("template still has variables %v", vars)
+    }
+
+    body := tree.Nodes[1].(*FuncDeclNode).Function.Body
+    ifStmt := body.Nodes[0].(*IfStmtNode)
+    if len(ifStmt.Body.Nodes) != 0 || ifStmt.Else != nil {
+        t.Errorf("got if body %v and else %v, want both empty", ifStmt.Body.Nodes, ifStmt.Else)
+    }
+
+    want := "FuncDecl Func FuncSignature VarDecl NamedType ) ) NamedType ) ) " +
+        "Block IfStmt BinaryExpr VarAccess ) NumLit ) ) Block ) ) " +
+        "ReturnStmt BinaryExpr BinaryExpr VarAccess ) NumLit ) ) NumLit ) ) ) ) ) )"
+    if got := trace(tree.Nodes[1], nil); got != want {
+        t.Errorf("got\n%s\nwant\n%s", got, want)
+    }
+}
+
+// A required child can't be removed, as Walk and every backend expect
+// it.
+func TestRewriteRequired(t *testing.T) {
+    tests := []struct {
+        name   string
+        remove func(Node) bool
+        want   string
+    }{
+        {"function of a declaration", func(n Node) bool { _, ok := n.(*FuncNode); return ok },
+            "*parser.FuncNode of a *parser.FuncDeclNode"},
+        {"signature", func(n Node) bool { _, ok := n.(*FuncSignatureNode); return ok },
+            "*parser.FuncSignatureNode of a *parser.FuncNode"},
+        {"call of a statement", func(n Node) bool { _, ok := n.(*CallExprNode); return ok },
+            "*parser.CallExprNode of a *parser.CallStmtNode"},
+        {"function body", func(n Node) bool { b, ok := n.(*BlockNode); return ok && b.Loc().Start.Line == 9 },
+            "*parser.BlockNode of a *parser.FuncNode"},
+        {"operand", func(n Node) bool { l, ok := n.(*NumLitNode); return ok && l.IntValue == 2 },
+            "*parser.NumLitNode of a *parser.BinaryExprNode"},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            defer func() {
+                msg, _ := recover().(string)
+                if !strings.Contains(msg, test.want + " can't be removed") {
+                    t.Errorf("got panic %q, want one that %s can't be removed", msg, test.want)
+                }
+            }()
+
+            Rewrite(walkTree(t), func(n Node) Node {
+                if test.remove(n) {
+                    return nil
+                }
+                return n
+            })
+        })
+    }
+}
@@ -0,0 +1,334 @@
+// Package project reads lyca.toml manifests. A manifest describes one
+// package:
+//
+//     [package]
+//     name = "app"
+//     entry = "main.lyca"
+//     sources = ["src"]
+//     libs = ["m"]
+//     lib-dirs = ["/usr/local/lib"]
+//     output = "app"
+//     out-dir = "build"
+//
+//     [dependencies]
+//     list = { path = "../list" }
+//
+// Lyca has no namespaces, so a package and the packages it depends on
+// are compiled together as one program.
+package project
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+const MANIFEST = "lyca.toml"
+
+type Package struct {
+    // Dir is the directory holding the manifest; every path in the
+    // manifest is relative to it.
+    Dir string
+
+    Name string
+
+    // Entry is the file defining main. Only the root package needs one.
+    Entry string
+
+    // Sources are directories whose .lyca files belong to the package,
+    // the package directory by default.
+    Sources []string
+
+    // Libs are C libraries to link, passed to the linker as -l, and
+    // LibDirs the directories to search them in.
+    Libs    []string
+    LibDirs []string
+
+    // Output is the name of the binary, the package name by default,
+    // written to OutDir, "build" by default.
+    Output string
+    OutDir string
+
+    // Dependencies maps package names to their directories.
+    Dependencies map[string]string
+}
+
+// Load reads the manifest in dir.
+func Load(dir string) (*Package, error) {
+    path := filepath.Join(dir, MANIFEST)
+    src, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    tables, err := parseTOML(path, src)
+    if err != nil {
+        return nil, err
+    }
+
+    pkg := &Package{Dir: dir, Dependencies: map[string]string{}}
+    m := &manifest{path: path}
+
+    info := tables["package"]
+    if info == nil {
+        return nil, fmt.Errorf("%s: missing [package] table", path)
+    }
+    pkg.Name = m.str(info, "name", "")
+    pkg.Entry = m.str(info, "entry", "")
+    pkg.Sources = m.strs(info, "sources", []string{"."})
+    pkg.Libs = m.strs(info, "libs", nil)
+    pkg.LibDirs = m.strs(info, "lib-dirs", nil)
+    pkg.Output = m.str(info, "output", pkg.Name)
+    pkg.OutDir = m.str(info, "out-dir", "build")
+    m.unknown(info, "package", "name", "entry", "sources", "libs", "lib-dirs", "output", "out-dir")
+
+    for name, dep := range tables["dependencies"] {
+        inline, ok := dep.(map[string]string)
+        if !ok || inline["path"] == "" || len(inline) != 1 {
+            m.fail("dependency %s must be { path = \"...\" }", name)
+            continue
+        }
+        pkg.Dependencies[name] = pkg.path(inline["path"])
+    }
+
+    for name := range tables {
+        if name != "" && name != "package" && name != "dependencies" {
+            m.fail("unknown table [%s]", name)
+        }
+    }
+    if len(tables[""]) > 0 {
+        m.fail("keys must be inside a table")
+    }
+
+    if pkg.Name == "" {
+        m.fail("package name is required")
+    }
+
+    if m.err != nil {
+        return nil, m.err
+    }
+    return pkg, nil
+}
+
+// path resolves a path of the manifest against the package directory.
+func (pkg *Package) path(path string) string {
+    if filepath.IsAbs(path) {
+        return path
+    }
+    return filepath.Join(pkg.Dir, path)
+}
+
+// manifest keeps the first error found while reading a manifest's keys.
+type manifest struct {
+    path string
+    err  error
+}
+
+func (m *manifest) fail(format string, args ...interface{}) {
+    if m.err == nil {
+        m.err = fmt.Errorf("%s: %s", m.path, fmt.Sprintf(format, args...))
+    }
+}
+
+func (m *manifest) str(t table, key, def string) string {
+    v, ok := t[key]
+    if !ok {
+        return def
+    }
+
+    s, ok := v.(string)
+    if !ok {
+        m.fail("%s must be a string", key)
+    }
+    return s
+}
+
+func (m *manifest) strs(t table, key string, def []string) []string {
+    v, ok := t[key]
+    if !ok {
+        return def
+    }
+
+    arr, ok := v.([]string)
+    if !ok {
+        m.fail("%s must be an array of strings", key)
+    }
+    return arr
+}
+
+func (m *manifest) unknown(t table, name string, keys ...string) {
+    known := map[string]bool{}
+    for _, key := range keys {
+        known[key] = true
+    }
+
+    for key := range t {
+        if !known[key] {
+            m.fail("unknown key %s in [%s]", key, name)
+        }
+    }
+}
+
+// A Project is a root package and every package it depends on, directly
+// or not.
+type Project struct {
+    Root *Package
+
+    // Packages lists dependencies before their dependents, so the root
+    // package is last.
+    Packages []*Package
+}
+
+// Open loads the package in dir and resolves its dependencies.
+func Open(dir string) (*Project, error) {
+    r := &resolver{visiting: map[string]bool{}, loaded: map[string]*Package{}}
+    root, err := r.resolve(dir, "")
+    if err != nil {
+        return nil, err
+    }
+
+    if root.Entry == "" {
+        return nil, fmt.Errorf("%s: package %s has no entry", filepath.Join(dir, MANIFEST), root.Name)
+    }
+
+    return &Project{Root: root, Packages: r.order}, nil
+}
+
+type resolver struct {
+    visiting map[string]bool
+    loaded   map[string]*Package
+    order    []*Package
+}
+
+func (r *resolver) resolve(dir, name string) (*Package, error) {
+    abs, err := filepath.Abs(dir)
+    if err != nil {
+        return nil, err
+    }
+
+    if pkg, ok := r.loaded[abs]; ok {
+        return pkg, nil
+    }
+    if r.visiting[abs] {
+        return nil, fmt.Errorf("%s: dependency cycle through package %s", filepath.Join(dir, MANIFEST), name)
+    }
+    r.visiting[abs] = true
+
+    pkg, err := Load(dir)
+    if err != nil {
+        return nil, err
+    }
+    if name != "" && pkg.Name != name {
+        return nil, fmt.Errorf("%s: package is %s, not %s", filepath.Join(dir, MANIFEST), pkg.Name, name)
+    }
+
+    var deps []string
+    for dep := range pkg.Dependencies {
+        deps = append(deps, dep)
+    }
+    sort.Strings(deps)
+
+    for _, dep := range deps {
+        if _, err := r.resolve(pkg.Dependencies[dep], dep); err != nil {
+            return nil, err
+        }
+    }
+
+    r.loaded[abs] = pkg
+    r.order = append(r.order, pkg)
+    return pkg, nil
+}
+
+// Sources reads every source file of the project, keyed by path. Files
+// found twice, say through overlapping source directories, are read once.
+func (p *Project) Sources() (map[string][]byte, error) {
+    sources := map[string][]byte{}
+    add := func(path string) error {
+        path = filepath.Clean(path)
+        if _, ok := sources[path]; ok {
+            return nil
+        }
+
+        src, err := ioutil.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        sources[path] = src
+        return nil
+    }
+
+    for _, pkg := range p.Packages {
+        if pkg.Entry != "" {
+            if err := add(pkg.path(pkg.Entry)); err != nil {
+                return nil, err
+            }
+        }
+
+        for _, dir := range pkg.Sources {
+            root := pkg.path(dir)
+            err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+                if err != nil {
+                    return err
+                }
+                if info.IsDir() && path != root && isPackage(path) {
+                    return filepath.SkipDir
+                }
+                if info.IsDir() || !strings.HasSuffix(path, ".lyca") {
+                    return nil
+                }
+                return add(path)
+            })
+            if err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    return sources, nil
+}
+
+// isPackage reports whether dir holds a package of its own, whose files
+// only belong to the project if it is a dependency.
+func isPackage(dir string) bool {
+    _, err := os.Stat(filepath.Join(dir, MANIFEST))
+    return err == nil
+}
+
+// Libs lists the C libraries of every package, without duplicates.
+func (p *Project) Libs() []string {
+    return p.collect(func(pkg *Package) []string { return pkg.Libs })
+}
+
+// LibDirs lists the library directories of every package.
+func (p *Project) LibDirs() []string {
+    return p.collect(func(pkg *Package) []string {
+        var dirs []string
+        for _, dir := range pkg.LibDirs {
+            dirs = append(dirs, pkg.path(dir))
+        }
+        return dirs
+    })
+}
+
+func (p *Project) collect(list func(*Package) []string) []string {
+    var res []string
+    seen := map[string]bool{}
+    for i := len(p.Packages) - 1; i >= 0; i-- {
+        for _, item := range list(p.Packages[i]) {
+            if !seen[item] {
+                seen[item] = true
+                res = append(res, item)
+            }
+        }
+    }
+
+    return res
+}
+
+// Output is the path the binary is written to.
+func (p *Project) Output() string {
+    return filepath.Join(p.Root.path(p.Root.OutDir), p.Root.Output)
+}
@@ -0,0 +1,252 @@
+package project
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+    "testing"
+)
+
+// tree writes files, keyed by slash separated paths, under a new
+// temporary directory and returns it.
+func tree(t *testing.T, files map[string]string) string {
+    dir, err := ioutil.TempDir("", "lyca-project")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { os.RemoveAll(dir) })
+
+    for name, content := range files {
+        path := filepath.Join(dir, filepath.FromSlash(name))
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            t.Fatal(err)
+        }
+        if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return dir
+}
+
+func TestLoad(t *testing.T) {
+    dir := tree(t, map[string]string{"lyca.toml": `
+[package]
+name = "app"
+entry = "main.lyca"
+sources = [
+    "src",
+    "gen",
+]
+libs = ["m"]
+lib-dirs = ["/usr/local/lib"]
+output = "bin"
+out-dir = "out"
+
+[dependencies]
+list = { path = "../list" }
+`})
+
+    pkg, err := Load(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    want := &Package{
+        Dir: dir,
+        Name: "app",
+        Entry: "main.lyca",
+        Sources: []string{"src", "gen"},
+        Libs: []string{"m"},
+        LibDirs: []string{"/usr/local/lib"},
+        Output: "bin",
+        OutDir: "out",
+        Dependencies: map[string]string{"list": filepath.Join(dir, "../list")},
+    }
+    if !reflect.DeepEqual(pkg, want) {
+        t.Errorf("got %+v, want %+v", pkg, want)
+    }
+}
+
+func TestLoadDefaults(t *testing.T) {
+    dir := tree(t, map[string]string{"lyca.toml": "[package]\nname = \"app\"\n"})
+    pkg, err := Load(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !reflect.DeepEqual(pkg.Sources, []string{"."}) || pkg.Output != "app" || pkg.OutDir != "build" {
+        t.Errorf("got sources %v, output %s, out-dir %s", pkg.Sources, pkg.Output, pkg.OutDir)
+    }
+}
+
+func TestLoadErrors(t *testing.T) {
+    tests := []struct {
+        name     string
+        manifest string
+        err      string
+    }{
+        {"no package table", "[dependencies]\n", "missing [package] table"},
+        {"no name", "[package]\nentry = \"main.lyca\"\n", "package name is required"},
+        {"name not a string", "[package]\nname = [\"app\"]\n", "name must be a string"},
+        {"sources not an array", "[package]\nname = \"app\"\nsources = \"src\"\n", "sources must be an array of strings"},
+        {"unknown key", "[package]\nname = \"app\"\nversion = \"1\"\n", "unknown key version in [package]"},
+        {"unknown table", "[package]\nname = \"app\"\n[build]\n", "unknown table [build]"},
+        {"key outside a table", "name = \"app\"\n[package]\nname = \"app\"\n", "keys must be inside a table"},
+        {"dependency without path", "[package]\nname = \"app\"\n[dependencies]\nlist = \"../list\"\n",
+            "dependency list must be { path = \"...\" }"},
+        {"dependency with more keys", "[package]\nname = \"app\"\n[dependencies]\nlist = { path = \"../list\", version = \"1\" }\n",
+            "dependency list must be { path = \"...\" }"},
+        {"syntax error", "[package]\nname = \"app\n", "2: unterminated string"},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := tree(t, map[string]string{"lyca.toml": test.manifest})
+            _, err := Load(dir)
+            if err == nil || !strings.HasSuffix(err.Error(), test.err) {
+                t.Errorf("got error %v, want one ending in %s", err, test.err)
+            }
+        })
+    }
+}
+
+func packageTOML(name, entry string, deps ...string) string {
+    src := "[package]\nname = \"" + name + "\"\n"
+    if entry != "" {
+        src += "entry = \"" + entry + "\"\n"
+    }
+
+    src += "[dependencies]\n"
+    for _, dep := range deps {
+        src += dep + " = { path = \"../" + dep + "\" }\n"
+    }
+    return src
+}
+
+// Dependencies come before their dependents, and a package several
+// others depend on is loaded once.
+func TestOpen(t *testing.T) {
+    dir := tree(t, map[string]string{
+        "app/lyca.toml": packageTOML("app", "main.lyca", "list", "util"),
+        "list/lyca.toml": packageTOML("list", "", "util"),
+        "util/lyca.toml": packageTOML("util", ""),
+    })
+
+    proj, err := Open(filepath.Join(dir, "app"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var names []string
+    for _, pkg := range proj.Packages {
+        names = append(names, pkg.Name)
+    }
+    if got := strings.Join(names, " "); got != "util list app" {
+        t.Errorf("got packages %s, want util list app", got)
+    }
+    if proj.Root != proj.Packages[len(proj.Packages) - 1] {
+        t.Error("root package is not last")
+    }
+}
+
+func TestOpenErrors(t *testing.T) {
+    tests := []struct {
+        name  string
+        files map[string]string
+        err   string
+    }{
+        {"cycle", map[string]string{
+            "app/lyca.toml": packageTOML("app", "main.lyca", "a"),
+            "a/lyca.toml": packageTOML("a", "", "b"),
+            "b/lyca.toml": packageTOML("b", "", "a"),
+        }, "dependency cycle through package a"},
+        {"depends on itself", map[string]string{
+            "app/lyca.toml": "[package]\nname = \"app\"\nentry = \"main.lyca\"\n[dependencies]\napp = { path = \".\" }\n",
+        }, "dependency cycle through package app"},
+        {"name mismatch", map[string]string{
+            "app/lyca.toml": packageTOML("app", "main.lyca", "list"),
+            "list/lyca.toml": packageTOML("lists", ""),
+        }, "package is lists, not list"},
+        {"missing dependency", map[string]string{
+            "app/lyca.toml": packageTOML("app", "main.lyca", "list"),
+        }, "no such file or directory"},
+        {"no entry", map[string]string{
+            "app/lyca.toml": packageTOML("app", ""),
+        }, "package app has no entry"},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := tree(t, test.files)
+            _, err := Open(filepath.Join(dir, "app"))
+            if err == nil || !strings.Contains(err.Error(), test.err) {
+                t.Errorf("got error %v, want one with %s", err, test.err)
+            }
+        })
+    }
+}
+
+// Sources reads the .lyca files of every package, skipping directories
+// that are packages of their own unless they are dependencies.
+func TestSources(t *testing.T) {
+    dir := tree(t, map[string]string{
+        "app/lyca.toml": "[package]\nname = \"app\"\nentry = \"main.lyca\"\nsources = [\".\", \"src\"]\n" +
+            "[dependencies]\nlist = { path = \"vendor/list\" }\n",
+        "app/main.lyca": "",
+        "app/README.md": "",
+        "app/src/util.lyca": "",
+        "app/src/deep/more.lyca": "",
+        "app/vendor/list/lyca.toml": packageTOML("list", ""),
+        "app/vendor/list/list.lyca": "",
+        "app/examples/lyca.toml": packageTOML("examples", "demo.lyca"),
+        "app/examples/demo.lyca": "",
+    })
+
+    proj, err := Open(filepath.Join(dir, "app"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    sources, err := proj.Sources()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var got []string
+    for path := range sources {
+        rel, _ := filepath.Rel(dir, path)
+        got = append(got, filepath.ToSlash(rel))
+    }
+    sort.Strings(got)
+
+    want := []string{"app/main.lyca", "app/src/deep/more.lyca", "app/src/util.lyca", "app/vendor/list/list.lyca"}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("got sources %v, want %v", got, want)
+    }
+}
+
+func TestLibs(t *testing.T) {
+    dir := tree(t, map[string]string{
+        "app/lyca.toml": "[package]\nname = \"app\"\nentry = \"main.lyca\"\nlibs = [\"m\", \"z\"]\nlib-dirs = [\"lib\"]\n" +
+            "out-dir = \"bin\"\n[dependencies]\nlist = { path = \"../list\" }\n",
+        "list/lyca.toml": "[package]\nname = \"list\"\nlibs = [\"pthread\", \"m\"]\nlib-dirs = [\"lib\"]\n",
+    })
+
+    proj, err := Open(filepath.Join(dir, "app"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if got := proj.Libs(); !reflect.DeepEqual(got, []string{"m", "z", "pthread"}) {
+        t.Errorf("got libs %v", got)
+    }
+    wantDirs := []string{filepath.Join(dir, "app", "lib"), filepath.Join(dir, "list", "lib")}
+    if got := proj.LibDirs(); !reflect.DeepEqual(got, wantDirs) {
+        t.Errorf("got lib dirs %v, want %v", got, wantDirs)
+    }
+    if got, want := proj.Output(), filepath.Join(dir, "app", "bin", "app"); got != want {
+        t.Errorf("got output %s, want %s", got, want)
+    }
+}
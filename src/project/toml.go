@@ -0,0 +1,264 @@
+package project
+
+import (
+    "fmt"
+    "strings"
+)
+
+// A table maps keys to values, which are strings, string arrays or
+// inline tables of strings. That is all of TOML a manifest uses.
+type table map[string]interface{}
+
+// tomlParser reads a manifest a line at a time. text is what is left of
+// the current line, line its number.
+type tomlParser struct {
+    name  string
+    lines []string
+    line  int
+    text  string
+}
+
+// parseTOML parses the subset of TOML manifests are written in: [table]
+// headers, key = value pairs, basic strings, arrays of strings, which may
+// span lines, inline tables of strings and # comments.
+func parseTOML(name string, src []byte) (map[string]table, error) {
+    tables := map[string]table{"": table{}}
+    curr := tables[""]
+
+    p := &tomlParser{name: name, lines: strings.Split(string(src), "\n")}
+    for p.next() {
+        p.skipSpace()
+        if p.done() {
+            continue
+        }
+
+        if p.peek() == '[' {
+            p.text = p.text[1:]
+            key, err := p.key()
+            if err != nil {
+                return nil, err
+            }
+            if err := p.expect(']'); err != nil {
+                return nil, err
+            }
+            if _, ok := tables[key]; ok {
+                return nil, p.errorf("table [%s] defined twice", key)
+            }
+            curr = table{}
+            tables[key] = curr
+        } else {
+            key, err := p.key()
+            if err != nil {
+                return nil, err
+            }
+            if err := p.expect('='); err != nil {
+                return nil, err
+            }
+            val, err := p.value()
+            if err != nil {
+                return nil, err
+            }
+            if _, ok := curr[key]; ok {
+                return nil, p.errorf("key %s defined twice", key)
+            }
+            curr[key] = val
+        }
+
+        p.skipSpace()
+        if !p.done() {
+            return nil, p.errorf("unexpected %q", p.text)
+        }
+    }
+
+    return tables, nil
+}
+
+// next moves on to the next line, if there is one.
+func (p *tomlParser) next() bool {
+    if p.line >= len(p.lines) {
+        return false
+    }
+
+    p.text = p.lines[p.line]
+    p.line++
+    return true
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+    return fmt.Errorf("%s:%d: %s", p.name, p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) skipSpace() {
+    p.text = strings.TrimLeft(p.text, " \t\r")
+    if strings.HasPrefix(p.text, "#") {
+        p.text = ""
+    }
+}
+
+// skipLines skips space, comments and line breaks inside an array.
+func (p *tomlParser) skipLines() error {
+    for p.skipSpace(); p.done(); p.skipSpace() {
+        if !p.next() {
+            return p.errorf("unterminated array")
+        }
+    }
+    return nil
+}
+
+func (p *tomlParser) done() bool {
+    return p.text == ""
+}
+
+func (p *tomlParser) peek() byte {
+    if p.done() {
+        return 0
+    }
+    return p.text[0]
+}
+
+func (p *tomlParser) expect(c byte) error {
+    p.skipSpace()
+    if p.peek() != c {
+        return p.errorf("expected %q", c)
+    }
+    p.text = p.text[1:]
+    return nil
+}
+
+// key reads a bare or quoted key, with dots for nested tables.
+func (p *tomlParser) key() (string, error) {
+    var parts []string
+    for {
+        p.skipSpace()
+        if p.peek() == '"' {
+            s, err := p.str()
+            if err != nil {
+                return "", err
+            }
+            parts = append(parts, s)
+        } else {
+            end := 0
+            for end < len(p.text) && isBareKeyChar(p.text[end]) {
+                end++
+            }
+            if end == 0 {
+                return "", p.errorf("expected a key")
+            }
+            parts = append(parts, p.text[:end])
+            p.text = p.text[end:]
+        }
+
+        p.skipSpace()
+        if p.peek() != '.' {
+            return strings.Join(parts, "."), nil
+        }
+        p.text = p.text[1:]
+    }
+}
+
+func isBareKeyChar(c byte) bool {
+    return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) value() (interface{}, error) {
+    p.skipSpace()
+    switch p.peek() {
+    case '"':
+        return p.str()
+    case '[':
+        p.text = p.text[1:]
+        var arr []string
+        for {
+            if err := p.skipLines(); err != nil {
+                return nil, err
+            }
+            if p.peek() == ']' {
+                p.text = p.text[1:]
+                return arr, nil
+            }
+
+            s, err := p.str()
+            if err != nil {
+                return nil, err
+            }
+            arr = append(arr, s)
+
+            if err := p.skipLines(); err != nil {
+                return nil, err
+            }
+            if p.peek() == ',' {
+                p.text = p.text[1:]
+            } else if p.peek() != ']' {
+                return nil, p.errorf("expected , or ] in array")
+            }
+        }
+    case '{':
+        p.text = p.text[1:]
+        inline := map[string]string{}
+        for {
+            p.skipSpace()
+            if p.done() {
+                return nil, p.errorf("inline table must end on the line it starts")
+            }
+            if p.peek() == '}' {
+                p.text = p.text[1:]
+                return inline, nil
+            }
+
+            key, err := p.key()
+            if err != nil {
+                return nil, err
+            }
+            if err := p.expect('='); err != nil {
+                return nil, err
+            }
+            p.skipSpace()
+            s, err := p.str()
+            if err != nil {
+                return nil, err
+            }
+            inline[key] = s
+
+            p.skipSpace()
+            if p.peek() == ',' {
+                p.text = p.text[1:]
+            } else if p.peek() != '}' {
+                return nil, p.errorf("expected , or } in inline table")
+            }
+        }
+    }
+
+    return nil, p.errorf("expected a string, array or inline table")
+}
+
+func (p *tomlParser) str() (string, error) {
+    if p.peek() != '"' {
+        return "", p.errorf("expected a string")
+    }
+
+    var b strings.Builder
+    for i := 1; i < len(p.text); i++ {
+        c := p.text[i]
+        switch {
+        case c == '"':
+            p.text = p.text[i+1:]
+            return b.String(), nil
+        case c == '\\' && i+1 < len(p.text):
+            i++
+            switch p.text[i] {
+            case 'n':
+                b.WriteByte('\n')
+            case 't':
+                b.WriteByte('\t')
+            case '"', '\\':
+                b.WriteByte(p.text[i])
+            default:
+                return "", p.errorf("unknown escape \\%c", p.text[i])
+            }
+        default:
+            b.WriteByte(c)
+        }
+    }
+
+    return "", p.errorf("unterminated string")
+}
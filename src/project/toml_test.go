@@ -0,0 +1,86 @@
+package project
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestParseTOML(t *testing.T) {
+    tests := []struct {
+        name string
+        src  string
+        want map[string]table
+    }{
+        {"tables and strings", `
+# a comment
+[package]
+name = "app"   # trailing comment
+"quoted key" = "a \"b\" \\ c\n"
+`, map[string]table{"": {}, "package": {"name": "app", "quoted key": "a \"b\" \\ c\n"}}},
+        {"arrays", `
+[package]
+empty = []
+libs = ["m", "pthread",]
+`, map[string]table{"": {}, "package": {"empty": []string(nil), "libs": []string{"m", "pthread"}}}},
+        {"multi line array", `
+[package]
+sources = [
+    "src",   # the code
+    # "old",
+    "gen",
+]
+name = "app"
+`, map[string]table{"": {}, "package": {"sources": []string{"src", "gen"}, "name": "app"}}},
+        {"array closing on a value line", "[package]\nlibs = [\"m\",\n    \"z\"]\n",
+            map[string]table{"": {}, "package": {"libs": []string{"m", "z"}}}},
+        {"inline table", `
+[dependencies]
+list = { path = "../list" }
+`, map[string]table{"": {}, "dependencies": {"list": map[string]string{"path": "../list"}}}},
+        {"dotted keys and crlf", "[a.b]\r\nc.d = \"e\"\r\n",
+            map[string]table{"": {}, "a.b": {"c.d": "e"}}},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got, err := parseTOML("lyca.toml", []byte(test.src))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !reflect.DeepEqual(got, test.want) {
+                t.Errorf("got %#v, want %#v", got, test.want)
+            }
+        })
+    }
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+    tests := []struct {
+        name string
+        src  string
+        err  string
+    }{
+        {"table twice", "[a]\n[a]\n", "lyca.toml:2: table [a] defined twice"},
+        {"key twice", "a = \"1\"\na = \"2\"\n", "lyca.toml:2: key a defined twice"},
+        {"missing equals", "a \"1\"\n", "lyca.toml:1: expected '='"},
+        {"missing value", "a =\n", "lyca.toml:1: expected a string, array or inline table"},
+        {"number", "a = 1\n", "lyca.toml:1: expected a string, array or inline table"},
+        {"unterminated string", "a = \"abc\n", "lyca.toml:1: unterminated string"},
+        {"unknown escape", "a = \"\\q\"\n", "lyca.toml:1: unknown escape \\q"},
+        {"unterminated table header", "[a\n", "lyca.toml:1: expected ']'"},
+        {"trailing text", "a = \"1\" b\n", "lyca.toml:1: unexpected \"b\""},
+        {"array without comma", "a = [\"1\" \"2\"]\n", "lyca.toml:1: expected , or ] in array"},
+        {"unterminated array", "a = [\n    \"1\",\n", "lyca.toml:3: unterminated array"},
+        {"non string in array", "a = [\n    1,\n]\n", "lyca.toml:2: expected a string"},
+        {"multi line inline table", "a = { path = \"x\",\n}\n", "lyca.toml:1: inline table must end on the line it starts"},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            _, err := parseTOML("lyca.toml", []byte(test.src))
+            if err == nil || err.Error() != test.err {
+                t.Errorf("got error %v, want %s", err, test.err)
+            }
+        })
+    }
+}
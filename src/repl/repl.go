@@ -0,0 +1,96 @@
+package repl
+
+import (
+    "io"
+    "fmt"
+    "bufio"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/codegen"
+)
+
+const (
+    PROMPT   = "> "
+    CONTINUE = "... "
+)
+
+func Start(in io.Reader, out io.Writer, options codegen.Options) error {
+    session, err := codegen.NewSession(options)
+    if err != nil {
+        return err
+    }
+    defer session.Close()
+
+    fmt.Fprintln(out, "Statements end with ';', bare expressions print their value.")
+    fmt.Fprint(out, PROMPT)
+
+    scanner := bufio.NewScanner(in)
+    input := ""
+    for scanner.Scan() {
+        input += scanner.Text() + "\n"
+
+        toks, err := lexer.Lex(lexer.NewFile([]byte(input)))
+        if err == nil && !complete(toks) {
+            fmt.Fprint(out, CONTINUE)
+            continue
+        }
+
+        if err == nil {
+            err = eval(session, toks)
+        }
+        if err != nil {
+            fmt.Fprintln(out, "error:", err)
+        }
+
+        input = ""
+        fmt.Fprint(out, PROMPT)
+    }
+    fmt.Fprintln(out)
+
+    return scanner.Err()
+}
+
+// complete reports whether every bracket opened in the input has been
+// closed, otherwise more lines are read before evaluating.
+func complete(toks []*lexer.Token) bool {
+    depth := 0
+    for _, tok := range toks {
+        if tok.Type != lexer.TOKEN_SEPARATOR {
+            continue
+        }
+
+        switch tok.Content {
+        case "{", "(", "[":
+            depth++
+        case "}", ")", "]":
+            depth--
+        }
+    }
+
+    return depth <= 0
+}
+
+func eval(session *codegen.Session, toks []*lexer.Token) error {
+    // nothing but the TOKEN_EOF every input ends with
+    if len(toks) < 2 {
+        return nil
+    }
+
+    if tree, err := parser.Parse(toks); err == nil {
+        return session.Declare(tree.Nodes)
+    }
+
+    if last := toks[len(toks) - 2]; last.Content != ";" && last.Content != "}" {
+        if expr, err := parser.ParseExpr(toks); err == nil {
+            return session.Print(expr)
+        }
+    }
+
+    stmts, err := parser.ParseStatements(toks)
+    if err != nil {
+        return err
+    }
+
+    return session.Exec(stmts)
+}
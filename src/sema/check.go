@@ -0,0 +1,406 @@
+package sema
+
+import (
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+)
+
+type Info struct {
+    // Types holds the type of every expression and the declared type of
+    // every variable, parameter and field declaration.
+    Types map[parser.Node]Type
+
+    // Defs maps identifier uses (variable and member accesses, named
+    // types and make expressions) to the node that declares them.
+    Defs map[parser.Node]parser.Node
+
+    Templates map[string]*Template
+    Functions map[string]*Func
+    Globals   map[string]*parser.VarDeclNode
+
+    // Tests and Benches hold a function without parameters for every test
+    // and bench block, in source order, named after the block.
+    Tests   []*Func
+    Benches []*Func
+}
+
+func (info *Info) TypeOf(node parser.Node) Type {
+    if t, ok := info.Types[node]; ok {
+        return t
+    }
+
+    return Void
+}
+
+type local struct {
+    t    Type
+    decl parser.Node
+}
+
+type scope struct {
+    outer *scope
+    vars  map[string]*local
+}
+
+func (s *scope) lookup(name string) *local {
+    for ; s != nil; s = s.outer {
+        if v, ok := s.vars[name]; ok {
+            return v
+        }
+    }
+
+    return nil
+}
+
+type checker struct {
+    info  *Info
+    scope *scope
+    fn    *Func
+
+    // testing is set inside test and bench blocks, where assert can be
+    // called
+    testing bool
+}
+
+// Check type checks tree. Externs are functions without a Lyca body, such
+// as Go functions registered with the VM, callable like top-level ones.
+// Globals are initialized in the order they are declared, so the value of
+// a global can only use the globals before it, while function, method and
+// test bodies can use all of them. On error, info still holds everything
+// checked before it.
+func Check(tree *parser.AST, externs ...*Func) (info *Info, err error) {
+    c := newChecker()
+    info = c.info
+    defer lexer.Recover(&err)
+
+    for _, fn := range externs {
+        c.info.Functions[fn.Name] = fn
+    }
+    c.check(tree)
+
+    return c.info, nil
+}
+
+// CheckExpr type checks tree and then expr as the body of a function of
+// its own after tree, the way the REPL evaluates an expression it
+// prints, and returns the type of expr.
+func CheckExpr(tree *parser.AST, expr parser.Node) (t Type, err error) {
+    c := newChecker()
+    defer lexer.Recover(&err)
+
+    c.check(tree)
+    c.fn = &Func{Return: Void}
+    c.enterScope()
+    defer c.exitScope()
+
+    return c.expr(expr), nil
+}
+
+func newChecker() *checker {
+    return &checker{
+        info: &Info{
+            Types: map[parser.Node]Type{},
+            Defs: map[parser.Node]parser.Node{},
+            Templates: map[string]*Template{"string": String},
+            Functions: map[string]*Func{},
+            Globals: map[string]*parser.VarDeclNode{},
+        },
+        scope: &scope{vars: map[string]*local{}},
+    }
+}
+
+func (c *checker) check(tree *parser.AST) {
+    c.declare(tree)
+    for _, node := range tree.Nodes {
+        if n, ok := node.(*parser.VarDeclNode); ok {
+            c.checkVarDecl(n)
+            c.info.Globals[n.Name.Value] = n
+        }
+    }
+
+    for _, node := range tree.Nodes {
+        switch n := node.(type) {
+        case *parser.FuncDeclNode:
+            c.checkFunc(c.info.Functions[n.Function.Signature.Name.Value], nil)
+        case *parser.TemplateNode:
+            c.checkTemplate(c.info.Templates[n.Name.Value])
+        }
+    }
+
+    c.testing = true
+    for _, test := range append(c.info.Tests, c.info.Benches...) {
+        c.checkFunc(test, nil)
+    }
+    c.testing = false
+}
+
+func (c *checker) fail(node parser.Node, message string) {
+    lexer.Fail(node.Loc(), message)
+}
+
+func (c *checker) declare(tree *parser.AST) {
+    for _, node := range tree.Nodes {
+        if n, ok := node.(*parser.TemplateNode); ok {
+            if _, ok := c.info.Templates[n.Name.Value]; ok {
+                c.fail(n, n.Name.Value + " has already been declared")
+            }
+            c.info.Templates[n.Name.Value] = &Template{
+                Name: n.Name.Value,
+                Node: n,
+                Methods: map[string]*Func{},
+            }
+        }
+    }
+
+    for _, node := range tree.Nodes {
+        switch n := node.(type) {
+        case *parser.TemplateNode:
+            tmpl := c.info.Templates[n.Name.Value]
+            for _, v := range n.Variables {
+                if f, _ := tmpl.Field(v.Name.Value); f != nil {
+                    c.fail(v, v.Name.Value + " has already been declared")
+                }
+                field := &Field{v.Name.Value, c.resolve(v.Type), v}
+                tmpl.Fields = append(tmpl.Fields, field)
+                c.info.Types[v] = field.Type
+            }
+
+            if n.Constructor != nil {
+                fn := &parser.FuncNode{
+                    Signature: &parser.FuncSignatureNode{Parameters: n.Constructor.Parameters},
+                    Body: n.Constructor.Body,
+                }
+                fn.SetLoc(n.Constructor.Loc())
+                tmpl.Constructor = c.signature(fn)
+            }
+
+            for _, meth := range n.Methods {
+                name := meth.Function.Signature.Name.Value
+                if _, ok := tmpl.Methods[name]; ok {
+                    c.fail(meth, name + " has already been declared")
+                }
+                tmpl.Methods[name] = c.signature(meth.Function)
+            }
+        case *parser.FuncDeclNode:
+            name := n.Function.Signature.Name.Value
+            if _, ok := c.info.Functions[name]; ok || name == Printf.Name || name == Assert.Name {
+                c.fail(n, name + " has already been declared")
+            }
+            c.info.Functions[name] = c.signature(n.Function)
+        case *parser.TestNode:
+            list, keyword := &c.info.Tests, parser.KEYWORD_TEST
+            if n.Bench {
+                list, keyword = &c.info.Benches, parser.KEYWORD_BENCH
+            }
+            for _, test := range *list {
+                if test.Name == n.Name.Value {
+                    c.fail(n, keyword + " \"" + n.Name.Value + "\" has already been declared")
+                }
+            }
+
+            fn := &parser.FuncNode{Signature: &parser.FuncSignatureNode{Name: n.Name}, Body: n.Body}
+            fn.SetLoc(n.Loc())
+            *list = append(*list, &Func{Name: n.Name.Value, Return: Void, Node: fn})
+        }
+    }
+}
+
+func (c *checker) signature(fn *parser.FuncNode) *Func {
+    res := &Func{Name: fn.Signature.Name.Value, Return: Void, Node: fn}
+    for _, param := range fn.Signature.Parameters {
+        res.Params = append(res.Params, c.resolve(param.Type))
+    }
+
+    if fn.Signature.Return != nil {
+        res.Return = c.resolve(fn.Signature.Return)
+    }
+
+    return res
+}
+
+func (c *checker) resolve(node parser.Node) Type {
+    switch n := node.(type) {
+    case *parser.NamedTypeNode:
+        if prim, ok := PRIMITIVES[n.Name.Value]; ok {
+            return prim
+        }
+
+        if tmpl, ok := c.info.Templates[n.Name.Value]; ok {
+            if tmpl.Node != nil {
+                c.info.Defs[n] = tmpl.Node
+            }
+            return tmpl
+        }
+
+        c.fail(n, "Undefined type " + n.Name.Value)
+    case *parser.ArrayTypeNode:
+        return &Array{c.resolve(n.MemberType)}
+    case *parser.FuncTypeNode:
+        fn := &Func{Return: Void}
+        for _, param := range n.Parameters {
+            fn.Params = append(fn.Params, c.resolve(param))
+        }
+        if n.Return != nil {
+            fn.Return = c.resolve(n.Return)
+        }
+        return fn
+    }
+
+    c.fail(node, "Invalid type")
+    return nil
+}
+
+func (c *checker) enterScope() {
+    c.scope = &scope{outer: c.scope, vars: map[string]*local{}}
+}
+
+func (c *checker) exitScope() {
+    c.scope = c.scope.outer
+}
+
+func (c *checker) checkTemplate(tmpl *Template) {
+    if tmpl.Constructor != nil {
+        c.checkFunc(tmpl.Constructor, tmpl)
+    }
+
+    for _, meth := range tmpl.Node.Methods {
+        c.checkFunc(tmpl.Methods[meth.Function.Signature.Name.Value], tmpl)
+    }
+}
+
+func (c *checker) checkFunc(fn *Func, this *Template) {
+    outer := c.fn
+    c.fn = fn
+    c.enterScope()
+    defer func() {
+        c.exitScope()
+        c.fn = outer
+    }()
+
+    if this != nil {
+        c.scope.vars["this"] = &local{this, this.Node}
+    }
+
+    for i, param := range fn.Node.Signature.Parameters {
+        c.declareLocal(param, fn.Params[i])
+    }
+
+    if !c.checkBlock(fn.Node.Body) && fn.Return != Void {
+        c.fail(fn.Node, "Missing return at end of function")
+    }
+}
+
+func (c *checker) declareLocal(decl *parser.VarDeclNode, t Type) {
+    if _, ok := c.scope.vars[decl.Name.Value]; ok {
+        c.fail(decl, decl.Name.Value + " has already been declared")
+    }
+
+    c.scope.vars[decl.Name.Value] = &local{t, decl}
+    c.info.Types[decl] = t
+}
+
+// checkBlock reports whether the block always returns.
+func (c *checker) checkBlock(block *parser.BlockNode) (ret bool) {
+    c.enterScope()
+    defer c.exitScope()
+
+    for _, node := range block.Nodes {
+        if c.checkStmt(node) {
+            ret = true
+        }
+    }
+
+    return
+}
+
+func (c *checker) checkStmt(node parser.Node) bool {
+    switch n := node.(type) {
+    case *parser.VarDeclNode:
+        c.checkVarDecl(n)
+    case *parser.AssignStmtNode:
+        c.checkAssign(n)
+    case *parser.CallStmtNode:
+        c.expr(n.Call)
+    case *parser.ReturnStmtNode:
+        c.checkReturn(n)
+        return true
+    case *parser.IfStmtNode:
+        c.condition(n.Condition)
+        body := c.checkBlock(n.Body)
+        if n.Else == nil {
+            return false
+        }
+        return c.checkStmt(n.Else) && body
+    case *parser.LoopStmtNode:
+        c.enterScope()
+        if n.Init != nil {
+            c.checkVarDecl(n.Init)
+        }
+        if n.Cond != nil {
+            c.condition(n.Cond)
+        }
+        if n.Post != nil {
+            c.checkStmt(n.Post)
+        }
+        c.checkBlock(n.Body)
+        c.exitScope()
+    case *parser.BlockNode:
+        return c.checkBlock(n)
+    default:
+        c.fail(node, "Unsupported statement")
+    }
+
+    return false
+}
+
+func (c *checker) checkVarDecl(n *parser.VarDeclNode) {
+    t := c.resolve(n.Type)
+    if n.Value != nil {
+        c.assign(n.Value, t)
+    }
+
+    c.declareLocal(n, t)
+}
+
+func (c *checker) checkAssign(n *parser.AssignStmtNode) {
+    switch n.Target.(type) {
+    case *parser.VarAccessNode, *parser.ObjectAccessNode:
+    default:
+        c.fail(n.Target, "Cannot assign to expression")
+    }
+
+    t := c.expr(n.Target)
+    if _, ok := t.(*Func); ok && c.info.Defs[n.Target] != nil {
+        if _, ok := c.info.Defs[n.Target].(*parser.VarDeclNode); !ok {
+            c.fail(n.Target, "Cannot assign to a function")
+        }
+    }
+
+    c.assign(n.Value, t)
+}
+
+func (c *checker) checkReturn(n *parser.ReturnStmtNode) {
+    if n.Value == nil {
+        if c.fn.Return != Void {
+            c.fail(n, "Missing return value")
+        }
+        return
+    }
+
+    if c.fn.Return == Void {
+        c.fail(n.Value, "Unexpected return value")
+    }
+    c.assign(n.Value, c.fn.Return)
+}
+
+func (c *checker) condition(node parser.Node) {
+    if t := c.expr(node); t != Boolean {
+        c.fail(node, "Condition must be boolean, not " + t.String())
+    }
+}
+
+func (c *checker) assign(node parser.Node, to Type) {
+    if from := c.expr(node); !Assignable(from, to) {
+        c.fail(node, "Cannot use " + from.String() + " as " + to.String())
+    }
+}
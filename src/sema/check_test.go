@@ -0,0 +1,171 @@
+package sema
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+)
+
+// lex splits src into tokens, leaving out the newline after its opening
+// backquote.
+func lex(t *testing.T, src string) []*lexer.Token {
+    toks, err := lexer.Lex(lexer.NewFile([]byte(strings.TrimPrefix(src, "\n"))))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return toks
+}
+
+// check type checks src.
+func check(t *testing.T, src string) (*Info, error) {
+    tree, err := parser.Parse(lex(t, src))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return Check(tree)
+}
+
+func TestCheckErrors(t *testing.T) {
+    tests := []struct {
+        name    string
+        src     string
+        message string
+        line    int
+    }{
+        {"type mismatch", `
+func () > main > () {
+    int x = "one";
+}`, "Cannot use string as int", 2},
+        {"return type", `
+func () > f > (int) {
+    return 1.5;
+}`, "Cannot use float as int", 2},
+        {"operands inside a chain", `
+func (int x, string s) > f > (int) {
+    return x + s - 1;
+}`, "Invalid operands int and string for +", 2},
+        {"undefined variable", `
+func () > main > () {
+    int x = y;
+}`, "Undefined variable y", 2},
+        {"undefined type", `
+func () > main > () {
+    Shape s;
+}`, "Undefined type Shape", 2},
+        {"undefined member", `
+tmpl Point {
+    int x;
+}
+
+func (Point p) > f > (int) {
+    return p.z;
+}`, "Undefined member z of Point", 6},
+        {"global before its declaration", `
+int a = b;
+int b = 1;`, "Undefined variable b", 1},
+        {"global declared twice", `
+int a = 1;
+int a = 2;`, "a has already been declared", 2},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            _, err := check(t, test.src)
+            e, ok := err.(*lexer.Error)
+            if !ok {
+                t.Fatalf("got error %v, want a *lexer.Error", err)
+            }
+
+            if e.Message != test.message || e.Location.Start.Line != test.line {
+                t.Errorf("got %d: %s, want %d: %s", e.Location.Start.Line, e.Message, test.line, test.message)
+            }
+        })
+    }
+}
+
+// Functions run after every global is initialized, so they can use the
+// globals declared after them.
+func TestCheckGlobalAfterFunction(t *testing.T) {
+    info, err := check(t, `
+func () > get > (int) {
+    return count;
+}
+
+int count = 2;
+int twice = count * 2;`)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, name := range []string{"count", "twice"} {
+        if info.Globals[name] == nil {
+            t.Errorf("global %s missing from Info", name)
+        }
+    }
+}
+
+func TestCheckRecordsTypes(t *testing.T) {
+    info, err := check(t, `
+func () > main > () {
+    float f = 1.5 + 2.0;
+    string s = "a" + "b";
+}`)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    body := info.Functions["main"].Node.Body.Nodes
+    want := []Type{Float, String}
+    for i, node := range body {
+        decl := node.(*parser.VarDeclNode)
+        if got := info.TypeOf(decl.Value); got != want[i] {
+            t.Errorf("%s: got type %s, want %s", decl.Name.Value, got, want[i])
+        }
+    }
+}
+
+func TestCheckExpr(t *testing.T) {
+    tree, err := parser.Parse(lex(t, `
+tmpl P {
+    int x;
+}
+
+P p;
+int count = 2;
+
+func (int n) > twice > (int) {
+    return 2 * n;
+}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        src  string
+        want string
+    }{
+        {"twice(count) + p.x", "int"},
+        {"p", "P"},
+        {"p.y", "Undefined member y of P"},
+        {"twice(1.5)", "Cannot use float as int"},
+        {"missing", "Undefined variable missing"},
+    }
+
+    for _, test := range tests {
+        expr, err := parser.ParseExpr(lex(t, test.src))
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        got, err := CheckExpr(tree, expr)
+        if err != nil {
+            if e, ok := err.(*lexer.Error); !ok || e.Message != test.want {
+                t.Errorf("%s: got error %v, want %s", test.src, err, test.want)
+            }
+        } else if got.String() != test.want {
+            t.Errorf("%s: got type %s, want %s", test.src, got, test.want)
+        }
+    }
+}
@@ -0,0 +1,196 @@
+package sema
+
+import (
+    "github.com/k3v/lyca/src/parser"
+)
+
+func (c *checker) expr(node parser.Node) (t Type) {
+    t = c.typeOf(node)
+    c.info.Types[node] = t
+    return
+}
+
+func (c *checker) typeOf(node parser.Node) Type {
+    switch n := node.(type) {
+    case *parser.NumLitNode:
+        if n.IsFloat {
+            return Float
+        }
+        return Int
+    case *parser.BoolLitNode:
+        return Boolean
+    case *parser.CharLitNode:
+        return Char
+    case *parser.StringLitNode:
+        return String
+    case *parser.FuncLitNode:
+        fn := c.signature(n.Function.(*parser.FuncNode))
+        c.checkFunc(fn, nil)
+        return fn
+    case *parser.VarAccessNode:
+        return c.varAccess(n)
+    case *parser.ObjectAccessNode:
+        return c.objectAccess(n)
+    case *parser.CallExprNode:
+        return c.call(n)
+    case *parser.MakeExprNode:
+        return c.make(n)
+    case *parser.UnaryExprNode:
+        return c.unary(n)
+    case *parser.BinaryExprNode:
+        return c.binary(n)
+    case *parser.ArrayAccessNode:
+        arr, ok := c.expr(n.Array).(*Array)
+        if !ok {
+            c.fail(n.Array, "Expression is not an array")
+        }
+        c.assign(n.Index, Int)
+        return arr.Member
+    }
+
+    c.fail(node, "Unsupported expression")
+    return nil
+}
+
+func (c *checker) varAccess(n *parser.VarAccessNode) Type {
+    name := n.Name.Value
+    if v := c.scope.lookup(name); v != nil {
+        c.info.Defs[n] = v.decl
+        return v.t
+    }
+
+    if name == "null" {
+        return Null
+    }
+
+    if fn, ok := c.info.Functions[name]; ok {
+        if fn.Node != nil {
+            c.info.Defs[n] = fn.Node
+        }
+        return fn
+    }
+
+    if name == Printf.Name {
+        return Printf
+    }
+
+    if name == Assert.Name {
+        if !c.testing {
+            c.fail(n, "assert can only be used in test and bench blocks")
+        }
+        return Assert
+    }
+
+    c.fail(n, "Undefined variable " + name)
+    return nil
+}
+
+func (c *checker) objectAccess(n *parser.ObjectAccessNode) Type {
+    tmpl, ok := c.expr(n.Object).(*Template)
+    if !ok {
+        c.fail(n.Object, "Expression is not an object")
+    }
+
+    if field, _ := tmpl.Field(n.Member.Value); field != nil {
+        c.info.Defs[n] = field.Node
+        return field.Type
+    }
+
+    if meth, ok := tmpl.Methods[n.Member.Value]; ok {
+        if meth.Node != nil {
+            c.info.Defs[n] = meth.Node
+        }
+        return meth
+    }
+
+    c.fail(n, "Undefined member " + n.Member.Value + " of " + tmpl.Name)
+    return nil
+}
+
+func (c *checker) call(n *parser.CallExprNode) Type {
+    fn, ok := c.expr(n.Function).(*Func)
+    if !ok {
+        c.fail(n.Function, "Expression is not callable")
+    }
+
+    c.arguments(n, n.Arguments, fn)
+    return fn.Return
+}
+
+func (c *checker) arguments(site parser.Node, args []parser.Node, fn *Func) {
+    if len(args) < len(fn.Params) || (!fn.Variadic && len(args) > len(fn.Params)) {
+        c.fail(site, "Wrong number of arguments in call")
+    }
+
+    for i, arg := range args {
+        if i < len(fn.Params) {
+            c.assign(arg, fn.Params[i])
+        } else {
+            c.expr(arg)
+        }
+    }
+}
+
+func (c *checker) make(n *parser.MakeExprNode) Type {
+    tmpl, ok := c.info.Templates[n.Template.Value]
+    if !ok || tmpl.Node == nil {
+        c.fail(n, "Undefined template " + n.Template.Value)
+    }
+    c.info.Defs[n] = tmpl.Node
+
+    if tmpl.Constructor != nil {
+        c.arguments(n, n.Arguments, tmpl.Constructor)
+    } else if len(n.Arguments) > 0 {
+        c.fail(n, tmpl.Name + " has no constructor")
+    }
+
+    return tmpl
+}
+
+func (c *checker) unary(n *parser.UnaryExprNode) Type {
+    t := c.expr(n.Value)
+    switch {
+    case n.Operator == "!" && t == Boolean:
+        return Boolean
+    case n.Operator == "-" && IsNumeric(t):
+        return t
+    }
+
+    c.fail(n, "Invalid operand " + t.String() + " for " + n.Operator)
+    return nil
+}
+
+func (c *checker) binary(n *parser.BinaryExprNode) Type {
+    op := n.Operator.Value
+    left := c.expr(n.Left)
+    right := c.expr(n.Right)
+
+    switch op {
+    case "&&", "||":
+        if left == Boolean && right == Boolean {
+            return Boolean
+        }
+    case "==", "!=":
+        if Assignable(left, right) || Assignable(right, left) || (IsNumeric(left) && IsNumeric(right)) {
+            return Boolean
+        }
+    case ">", ">=", "<", "<=":
+        if IsNumeric(left) && IsNumeric(right) {
+            return Boolean
+        }
+    case "+", "-", "*", "/", "%":
+        if op == "+" && left == String && right == String {
+            return String
+        }
+
+        if IsNumeric(left) && IsNumeric(right) {
+            if left == Float || right == Float {
+                return Float
+            }
+            return left
+        }
+    }
+
+    c.fail(n, "Invalid operands " + left.String() + " and " + right.String() + " for " + op)
+    return nil
+}
@@ -0,0 +1,155 @@
+package sema
+
+import (
+    "strings"
+
+    "github.com/k3v/lyca/src/parser"
+)
+
+type Type interface {
+    String() string
+}
+
+type Basic struct {
+    name string
+}
+
+func (b *Basic) String() string {
+    return b.name
+}
+
+var (
+    Int     = &Basic{"int"}
+    Float   = &Basic{"float"}
+    Char    = &Basic{"char"}
+    Boolean = &Basic{"boolean"}
+    Void    = &Basic{"void"}
+    Null    = &Basic{"null"}
+)
+
+var PRIMITIVES = map[string]Type{
+    "int": Int, "float": Float, "char": Char, "boolean": Boolean,
+}
+
+type Template struct {
+    Name        string
+    Node        *parser.TemplateNode
+    Fields      []*Field
+    Methods     map[string]*Func
+    Constructor *Func
+}
+
+func (t *Template) String() string {
+    return t.Name
+}
+
+func (t *Template) Field(name string) (*Field, int) {
+    for i, f := range t.Fields {
+        if f.Name == name {
+            return f, i
+        }
+    }
+
+    return nil, -1
+}
+
+type Field struct {
+    Name string
+    Type Type
+    Node *parser.VarDeclNode
+}
+
+type Func struct {
+    Name     string
+    Params   []Type
+    Return   Type
+    Variadic bool
+    Node     *parser.FuncNode
+}
+
+func (f *Func) String() string {
+    var params []string
+    for _, p := range f.Params {
+        params = append(params, p.String())
+    }
+
+    ret := ""
+    if f.Return != Void {
+        ret = f.Return.String()
+    }
+
+    return "func (" + strings.Join(params, ", ") + ") > (" + ret + ")"
+}
+
+type Array struct {
+    Member Type
+}
+
+func (a *Array) String() string {
+    return "[]" + a.Member.String()
+}
+
+var String = &Template{
+    Name: "string",
+    Methods: map[string]*Func{
+        "len": &Func{Name: "len", Return: Int},
+    },
+}
+
+var Printf = &Func{Name: "printf", Params: []Type{String}, Return: Int, Variadic: true}
+
+// Assert fails the test it is called from when its condition is false.
+// It can only be used in test and bench blocks.
+var Assert = &Func{Name: "assert", Params: []Type{Boolean, String}, Return: Void}
+
+func IsNumeric(t Type) bool {
+    return t == Int || t == Float || t == Char
+}
+
+// IsReference reports whether null can be stored in a value of type t.
+func IsReference(t Type) bool {
+    switch t.(type) {
+    case *Template, *Func, *Array:
+        return true
+    }
+
+    return false
+}
+
+func Identical(a, b Type) bool {
+    if a == b {
+        return true
+    }
+
+    fa, ok := a.(*Func)
+    fb, ok2 := b.(*Func)
+    if !ok || !ok2 || len(fa.Params) != len(fb.Params) || !Identical(fa.Return, fb.Return) {
+        return false
+    }
+
+    for i := range fa.Params {
+        if !Identical(fa.Params[i], fb.Params[i]) {
+            return false
+        }
+    }
+
+    return true
+}
+
+// Assignable follows the implicit conversions the compiler inserts:
+// ints and chars widen to floats, chars to ints, and null converts to
+// any reference type.
+func Assignable(from, to Type) bool {
+    switch {
+    case Identical(from, to):
+        return true
+    case to == Float && (from == Int || from == Char):
+        return true
+    case to == Int && from == Char:
+        return true
+    case from == Null && IsReference(to):
+        return true
+    }
+
+    return false
+}
@@ -0,0 +1,388 @@
+package vm
+
+import (
+    "sort"
+    "strconv"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/sema"
+)
+
+type compiler struct {
+    prog *Program
+    info *sema.Info
+    fn   *funcState
+
+    constants map[interface{}]int
+    natives   map[string]int
+    functions map[*parser.FuncNode]int
+    methods   map[*sema.Func]int
+    templates map[*sema.Template]int
+    globals   map[parser.Node]int
+}
+
+// funcState tracks the function being compiled. Every declaration gets
+// its own slot in the call's env; outer links to the enclosing function
+// of a function literal.
+type funcState struct {
+    outer *funcState
+    fn    *Function
+    slots map[parser.Node]int
+}
+
+func compile(tree *parser.AST, natives map[string]*native) (prog *Program, err error) {
+    var names []string
+    for name := range natives {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    var externs []*sema.Func
+    for _, name := range names {
+        externs = append(externs, natives[name].signature)
+    }
+
+    info, err := sema.Check(tree, externs...)
+    if err != nil {
+        return nil, err
+    }
+
+    c := &compiler{
+        prog: &Program{},
+        info: info,
+
+        constants: map[interface{}]int{},
+        natives: map[string]int{},
+        functions: map[*parser.FuncNode]int{},
+        methods: map[*sema.Func]int{},
+        templates: map[*sema.Template]int{},
+        globals: map[parser.Node]int{},
+    }
+    defer lexer.Recover(&err)
+
+    c.declare(tree)
+    c.compileInit(tree)
+
+    for _, node := range tree.Nodes {
+        switch n := node.(type) {
+        case *parser.FuncDeclNode:
+            c.compileFunc(c.functions[n.Function], n.Function, nil)
+        case *parser.TemplateNode:
+            tmpl := info.Templates[n.Name.Value]
+            if tmpl.Constructor != nil {
+                c.compileFunc(c.methods[tmpl.Constructor], tmpl.Constructor.Node, n)
+            }
+            for _, meth := range n.Methods {
+                c.compileFunc(c.methods[tmpl.Methods[meth.Function.Signature.Name.Value]], meth.Function, n)
+            }
+        }
+    }
+
+    for i, test := range info.Tests {
+        c.compileFunc(c.prog.Tests[i], test.Node, nil)
+    }
+    for i, bench := range info.Benches {
+        c.compileFunc(c.prog.Benches[i], bench.Node, nil)
+    }
+
+    return c.prog, nil
+}
+
+func (c *compiler) fail(node parser.Node, message string) {
+    lexer.Fail(node.Loc(), message)
+}
+
+func (c *compiler) declare(tree *parser.AST) {
+    for _, node := range tree.Nodes {
+        switch n := node.(type) {
+        case *parser.TemplateNode:
+            tmpl := c.info.Templates[n.Name.Value]
+            t := &Template{Name: tmpl.Name}
+            for _, field := range tmpl.Fields {
+                t.Fields = append(t.Fields, field.Name)
+                t.Zero = append(t.Zero, zero(field.Type))
+            }
+            c.templates[tmpl] = len(c.prog.Templates)
+            c.prog.Templates = append(c.prog.Templates, t)
+
+            if tmpl.Constructor != nil {
+                c.methods[tmpl.Constructor] = c.addFunction(&Function{Name: "constructor", Template: t, Type: tmpl.Constructor})
+            }
+            for _, meth := range n.Methods {
+                fn := tmpl.Methods[meth.Function.Signature.Name.Value]
+                c.methods[fn] = c.addFunction(&Function{Name: fn.Name, Template: t, Type: fn})
+            }
+        case *parser.FuncDeclNode:
+            fn := c.info.Functions[n.Function.Signature.Name.Value]
+            c.functions[n.Function] = c.addFunction(&Function{Name: fn.Name, Type: fn})
+        case *parser.VarDeclNode:
+            c.globals[n] = len(c.prog.Globals)
+            c.prog.Globals = append(c.prog.Globals, n.Name.Value)
+        }
+    }
+
+    // test names are not Lyca identifiers, so Program.Function never
+    // finds a test
+    for _, test := range c.info.Tests {
+        index := c.addFunction(&Function{Name: "test " + strconv.Quote(test.Name), Type: test})
+        c.prog.Tests = append(c.prog.Tests, index)
+    }
+    for _, bench := range c.info.Benches {
+        index := c.addFunction(&Function{Name: "bench " + strconv.Quote(bench.Name), Type: bench})
+        c.prog.Benches = append(c.prog.Benches, index)
+    }
+}
+
+func (c *compiler) addFunction(fn *Function) int {
+    c.prog.Functions = append(c.prog.Functions, fn)
+    return len(c.prog.Functions) - 1
+}
+
+func (c *compiler) compileInit(tree *parser.AST) {
+    c.prog.Init = c.addFunction(&Function{Name: "<init>", Type: &sema.Func{Return: sema.Void}})
+    c.fn = &funcState{fn: c.prog.Functions[c.prog.Init], slots: map[parser.Node]int{}}
+    defer func() { c.fn = nil }()
+
+    for _, node := range tree.Nodes {
+        if n, ok := node.(*parser.VarDeclNode); ok {
+            c.initialize(n)
+            c.emit(n, OP_SET_GLOBAL, c.globals[n])
+        }
+    }
+    c.emit(nil, OP_NULL)
+    c.emit(nil, OP_RETURN)
+}
+
+// compileFunc compiles the body of a function, method or function
+// literal. Methods and constructors receive this in their first slot.
+func (c *compiler) compileFunc(index int, node *parser.FuncNode, this *parser.TemplateNode) {
+    outer := c.fn
+    fn := c.prog.Functions[index]
+    c.fn = &funcState{outer: outer, fn: fn, slots: map[parser.Node]int{}}
+    defer func() { c.fn = outer }()
+
+    if this != nil {
+        c.local(this)
+    }
+    for _, param := range node.Signature.Parameters {
+        c.local(param)
+    }
+    fn.Params = fn.Locals
+
+    c.block(node.Body)
+    c.emit(node, OP_NULL)
+    c.emit(node, OP_RETURN)
+}
+
+func (c *compiler) local(decl parser.Node) int {
+    slot := len(c.fn.slots)
+    if slot > 0xffff {
+        c.fail(decl, "Too many variables in function")
+    }
+
+    c.fn.slots[decl] = slot
+    c.fn.fn.Locals = slot + 1
+    return slot
+}
+
+// lookup finds the slot of a local declaration and how many functions
+// out it was declared.
+func (c *compiler) lookup(decl parser.Node) (depth, slot int, ok bool) {
+    for fs := c.fn; fs != nil; fs = fs.outer {
+        if slot, ok := fs.slots[decl]; ok {
+            return depth, slot, true
+        }
+        depth++
+    }
+
+    return 0, 0, false
+}
+
+func (c *compiler) constant(node parser.Node, value Value) int {
+    key := value
+    if str, ok := value.(*String); ok {
+        key = str.Value
+    }
+
+    if i, ok := c.constants[key]; ok {
+        return i
+    }
+
+    if len(c.prog.Constants) > 0xffff {
+        c.fail(node, "Too many constants in program")
+    }
+
+    c.constants[key] = len(c.prog.Constants)
+    c.prog.Constants = append(c.prog.Constants, value)
+    return len(c.prog.Constants) - 1
+}
+
+func (c *compiler) native(name string) int {
+    if i, ok := c.natives[name]; ok {
+        return i
+    }
+
+    c.natives[name] = len(c.prog.Natives)
+    c.prog.Natives = append(c.prog.Natives, name)
+    return len(c.prog.Natives) - 1
+}
+
+// emit appends an instruction, recording node as its source location,
+// and returns its offset.
+func (c *compiler) emit(node parser.Node, op Opcode, operands ...int) int {
+    fn := c.fn.fn
+    pc := len(fn.Code)
+
+    if node != nil {
+        span := node.Loc()
+        if len(fn.lines) == 0 || fn.lines[len(fn.lines) - 1].span != span {
+            fn.lines = append(fn.lines, line{pc, span})
+        }
+    }
+
+    fn.Code = append(fn.Code, byte(op))
+    for i, width := range OPCODES[op].operands {
+        if operands[i] >= 1 << uint(width * 8) {
+            c.fail(node, "Operand out of range for " + op.String())
+        }
+
+        for shift := (width - 1) * 8; shift >= 0; shift -= 8 {
+            fn.Code = append(fn.Code, byte(operands[i] >> uint(shift)))
+        }
+    }
+
+    if len(fn.Code) > 0xffff {
+        c.fail(node, "Function is too large")
+    }
+
+    return pc
+}
+
+func (c *compiler) pc() int {
+    return len(c.fn.fn.Code)
+}
+
+// patch points the jump at pc to the next instruction.
+func (c *compiler) patch(pc int) {
+    target := c.pc()
+    c.fn.fn.Code[pc + 1] = byte(target >> 8)
+    c.fn.fn.Code[pc + 2] = byte(target)
+}
+
+func (c *compiler) block(block *parser.BlockNode) {
+    for _, node := range block.Nodes {
+        c.stmt(node)
+    }
+}
+
+func (c *compiler) stmt(node parser.Node) {
+    switch n := node.(type) {
+    case *parser.VarDeclNode:
+        c.initialize(n)
+        c.emit(n, OP_SET_LOCAL, c.local(n))
+    case *parser.AssignStmtNode:
+        c.assign(n)
+    case *parser.CallStmtNode:
+        c.expr(n.Call)
+        c.emit(n, OP_POP)
+    case *parser.ReturnStmtNode:
+        if n.Value != nil {
+            c.value(n.Value, c.fn.fn.Type.Return)
+        } else {
+            c.emit(n, OP_NULL)
+        }
+        c.emit(n, OP_RETURN)
+    case *parser.IfStmtNode:
+        c.expr(n.Condition)
+        skip := c.emit(n, OP_JUMP_IF_FALSE, 0)
+        c.block(n.Body)
+        if n.Else == nil {
+            c.patch(skip)
+            return
+        }
+
+        end := c.emit(n, OP_JUMP, 0)
+        c.patch(skip)
+        c.stmt(n.Else)
+        c.patch(end)
+    case *parser.LoopStmtNode:
+        if n.Init != nil {
+            c.stmt(n.Init)
+        }
+
+        top := c.pc()
+        exit := -1
+        if n.Cond != nil {
+            c.expr(n.Cond)
+            exit = c.emit(n, OP_JUMP_IF_FALSE, 0)
+        }
+        c.block(n.Body)
+        if n.Post != nil {
+            c.stmt(n.Post)
+        }
+        c.emit(n, OP_JUMP, top)
+
+        if exit >= 0 {
+            c.patch(exit)
+        }
+    case *parser.BlockNode:
+        c.block(n)
+    default:
+        c.fail(node, "Unsupported statement")
+    }
+}
+
+// initialize pushes the initial value of a declaration.
+func (c *compiler) initialize(n *parser.VarDeclNode) {
+    t := c.info.TypeOf(n)
+    if n.Value != nil {
+        c.value(n.Value, t)
+        return
+    }
+
+    switch t {
+    case sema.Boolean:
+        c.emit(n, OP_FALSE)
+    case sema.Int, sema.Float, sema.Char:
+        c.emit(n, OP_CONST, c.constant(n, zero(t)))
+    default:
+        c.emit(n, OP_NULL)
+    }
+}
+
+func (c *compiler) assign(n *parser.AssignStmtNode) {
+    t := c.info.TypeOf(n.Target)
+    switch target := n.Target.(type) {
+    case *parser.VarAccessNode:
+        c.value(n.Value, t)
+        c.store(target)
+    case *parser.ObjectAccessNode:
+        tmpl := c.info.TypeOf(target.Object).(*sema.Template)
+        _, field := tmpl.Field(target.Member.Value)
+        c.expr(target.Object)
+        c.value(n.Value, t)
+        c.emit(n, OP_SET_FIELD, field)
+    default:
+        c.fail(n.Target, "Cannot assign to expression")
+    }
+}
+
+func (c *compiler) store(n *parser.VarAccessNode) {
+    decl := c.info.Defs[n]
+    if depth, slot, ok := c.lookup(decl); ok {
+        if depth == 0 {
+            c.emit(n, OP_SET_LOCAL, slot)
+        } else {
+            c.emit(n, OP_SET_OUTER, depth, slot)
+        }
+        return
+    }
+
+    if global, ok := c.globals[decl]; ok {
+        c.emit(n, OP_SET_GLOBAL, global)
+        return
+    }
+
+    c.fail(n, "Cannot assign to " + n.Name.Value)
+}
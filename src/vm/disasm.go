@@ -0,0 +1,81 @@
+package vm
+
+import (
+    "fmt"
+    "io"
+    "strconv"
+)
+
+// Disassemble writes a listing of every function in the program, one
+// instruction per line with its offset, source line and operands.
+func Disassemble(w io.Writer, prog *Program) {
+    for i, fn := range prog.Functions {
+        if i > 0 {
+            fmt.Fprintln(w)
+        }
+        DisassembleFunction(w, prog, fn)
+    }
+}
+
+func DisassembleFunction(w io.Writer, prog *Program, fn *Function) {
+    name := fn.Name
+    if fn.Template != nil {
+        name = fn.Template.Name + "." + name
+    }
+    fmt.Fprintf(w, "%s (params %d, locals %d)\n", name, fn.Params, fn.Locals)
+
+    for pc := 0; pc < len(fn.Code); {
+        op := Opcode(fn.Code[pc])
+        fmt.Fprintf(w, "  %04d %4d  %-22s", pc, fn.Span(pc).Start.Line, op)
+
+        next := pc + 1
+        var operands []int
+        for _, width := range OPCODES[op].operands {
+            var v int
+            v, next = read(fn.Code, next, width)
+            operands = append(operands, v)
+            fmt.Fprintf(w, " %d", v)
+        }
+
+        if comment := describe(prog, op, operands); comment != "" {
+            fmt.Fprintf(w, "  ; %s", comment)
+        }
+        fmt.Fprintln(w)
+
+        pc = next
+    }
+}
+
+func describe(prog *Program, op Opcode, operands []int) string {
+    switch op {
+    case OP_CONST, OP_STRING:
+        return formatValue(prog.Constants[operands[0]])
+    case OP_GET_GLOBAL, OP_SET_GLOBAL:
+        return prog.Globals[operands[0]]
+    case OP_NEW:
+        return prog.Templates[operands[0]].Name
+    case OP_FUNCTION, OP_CLOSURE, OP_METHOD, OP_CALL_FUNC:
+        fn := prog.Functions[operands[0]]
+        if fn.Template != nil {
+            return fn.Template.Name + "." + fn.Name
+        }
+        return fn.Name
+    case OP_CALL_NATIVE:
+        return prog.Natives[operands[0]]
+    }
+
+    return ""
+}
+
+func formatValue(v Value) string {
+    switch x := v.(type) {
+    case *String:
+        return strconv.Quote(x.Value)
+    case int8:
+        return strconv.QuoteRune(rune(x))
+    case float32:
+        return strconv.FormatFloat(float64(x), 'g', -1, 32)
+    }
+
+    return fmt.Sprint(v)
+}
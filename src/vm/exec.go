@@ -0,0 +1,303 @@
+package vm
+
+import (
+    "fmt"
+    "math"
+
+    "github.com/k3v/lyca/src/interp"
+)
+
+func (vm *VM) push(v Value) {
+    vm.stack = append(vm.stack, v)
+}
+
+func (vm *VM) pop() Value {
+    v := vm.stack[len(vm.stack) - 1]
+    vm.stack = vm.stack[:len(vm.stack) - 1]
+    return v
+}
+
+func (vm *VM) top() *Value {
+    return &vm.stack[len(vm.stack) - 1]
+}
+
+// popArgs removes the top n values, returning them in push order. The
+// slice aliases the stack and is only valid until the next push.
+func (vm *VM) popArgs(n int) []Value {
+    args := vm.stack[len(vm.stack) - n:]
+    vm.stack = vm.stack[:len(vm.stack) - n]
+    return args
+}
+
+func (vm *VM) execute(fn *Function, e *env) Value {
+    code := fn.Code
+    base := len(vm.stack)
+
+    for pc := 0; ; {
+        op := Opcode(code[pc])
+        start := pc
+        pc++
+
+        var a, b int
+        switch operands := OPCODES[op].operands; len(operands) {
+        case 2:
+            a, pc = read(code, pc, operands[0])
+            b, pc = read(code, pc, operands[1])
+        case 1:
+            a, pc = read(code, pc, operands[0])
+        }
+
+        switch op {
+        case OP_CONST:
+            vm.push(vm.prog.Constants[a])
+        case OP_STRING:
+            // every evaluation of a literal is a string of its own, as
+            // == compares strings by identity
+            vm.Allocs++
+            vm.push(&String{vm.prog.Constants[a].(*String).Value})
+        case OP_NULL:
+            vm.push(nil)
+        case OP_TRUE:
+            vm.push(true)
+        case OP_FALSE:
+            vm.push(false)
+        case OP_POP:
+            vm.pop()
+        case OP_DUP:
+            vm.push(*vm.top())
+
+        case OP_GET_LOCAL:
+            vm.push(e.locals[a])
+        case OP_SET_LOCAL:
+            e.locals[a] = vm.pop()
+        case OP_GET_OUTER:
+            vm.push(e.up(a).locals[b])
+        case OP_SET_OUTER:
+            e.up(a).locals[b] = vm.pop()
+        case OP_GET_GLOBAL:
+            vm.push(vm.globals[a])
+        case OP_SET_GLOBAL:
+            vm.globals[a] = vm.pop()
+        case OP_GET_FIELD:
+            obj := vm.object(fn, start, vm.pop())
+            vm.push(obj.Fields[a])
+        case OP_SET_FIELD:
+            value := vm.pop()
+            obj := vm.object(fn, start, vm.pop())
+            obj.Fields[a] = value
+
+        case OP_NEW:
+            tmpl := vm.prog.Templates[a]
+            vm.Allocs++
+            vm.push(&Object{tmpl, append([]Value(nil), tmpl.Zero...)})
+        case OP_FUNCTION:
+            vm.push(&Closure{Function: vm.prog.Functions[a]})
+        case OP_CLOSURE:
+            vm.Allocs++
+            vm.push(&Closure{Function: vm.prog.Functions[a], Env: e})
+        case OP_METHOD:
+            vm.Allocs++
+            vm.push(&Closure{Function: vm.prog.Functions[a], This: vm.pop(), Bound: true})
+
+        case OP_CALL:
+            args := vm.popArgs(a)
+            closure, _ := vm.pop().(*Closure)
+            if closure == nil {
+                vm.fail(fn, start, "Call of a null function")
+            }
+            vm.checkDepth(fn, start)
+            vm.push(vm.enter(closure.Function, closure.Env, args, closure))
+        case OP_CALL_FUNC:
+            vm.checkDepth(fn, start)
+            vm.push(vm.enter(vm.prog.Functions[a], nil, vm.popArgs(b), nil))
+        case OP_CALL_NATIVE:
+            ret, err := vm.bound[a].call(vm.popArgs(b))
+            if err != nil {
+                vm.fail(fn, start, err.Error())
+            }
+            if _, ok := ret.(*String); ok {
+                vm.Allocs++
+            }
+            vm.push(ret)
+        case OP_PRINTF:
+            vm.push(vm.printf(fn, start, vm.popArgs(a)))
+        case OP_STRLEN:
+            str, _ := vm.pop().(*String)
+            if str == nil {
+                vm.fail(fn, start, "Expression is not an object or is null")
+            }
+            vm.push(int32(len(str.Value)))
+        case OP_ASSERT:
+            msg, _ := vm.pop().(*String)
+            if vm.pop() != true {
+                text := ""
+                if msg != nil {
+                    text = msg.Value
+                }
+                vm.fail(fn, start, "assertion failed: " + text)
+            }
+            vm.push(nil)
+        case OP_RETURN:
+            ret := vm.pop()
+            vm.stack = vm.stack[:base]
+            return ret
+
+        case OP_JUMP:
+            pc = a
+        case OP_JUMP_IF_FALSE:
+            if !vm.pop().(bool) {
+                pc = a
+            }
+        case OP_JUMP_IF_FALSE_OR_POP:
+            if !(*vm.top()).(bool) {
+                pc = a
+            } else {
+                vm.pop()
+            }
+        case OP_JUMP_IF_TRUE_OR_POP:
+            if (*vm.top()).(bool) {
+                pc = a
+            } else {
+                vm.pop()
+            }
+
+        case OP_I2F:
+            *vm.top() = float32((*vm.top()).(int32))
+        case OP_C2I:
+            *vm.top() = int32((*vm.top()).(int8))
+        case OP_C2F:
+            *vm.top() = float32((*vm.top()).(int8))
+        case OP_I2C:
+            *vm.top() = int8((*vm.top()).(int32))
+
+        case OP_ADD_I, OP_SUB_I, OP_MUL_I, OP_DIV_I, OP_MOD_I,
+            OP_LT_I, OP_LE_I, OP_GT_I, OP_GE_I:
+            y := vm.pop().(int32)
+            x := vm.top()
+            *x = vm.intOp(fn, start, op, (*x).(int32), y)
+        case OP_NEG_I:
+            *vm.top() = -(*vm.top()).(int32)
+        case OP_ADD_F, OP_SUB_F, OP_MUL_F, OP_DIV_F, OP_MOD_F,
+            OP_LT_F, OP_LE_F, OP_GT_F, OP_GE_F:
+            y := vm.pop().(float32)
+            x := vm.top()
+            *x = floatOp(op, (*x).(float32), y)
+        case OP_NEG_F:
+            *vm.top() = -(*vm.top()).(float32)
+        case OP_CONCAT:
+            y, _ := vm.pop().(*String)
+            x, _ := (*vm.top()).(*String)
+            if x == nil || y == nil {
+                vm.fail(fn, start, "Concatenation of a null string")
+            }
+            vm.Allocs++
+            *vm.top() = &String{x.Value + y.Value}
+
+        case OP_EQ:
+            y := vm.pop()
+            *vm.top() = *vm.top() == y
+        case OP_NE:
+            y := vm.pop()
+            *vm.top() = *vm.top() != y
+        case OP_NOT:
+            *vm.top() = !(*vm.top()).(bool)
+
+        default:
+            vm.fail(fn, start, fmt.Sprintf("Invalid opcode %d", op))
+        }
+    }
+}
+
+func read(code []byte, pc, width int) (int, int) {
+    v := 0
+    for i := 0; i < width; i++ {
+        v = v << 8 | int(code[pc + i])
+    }
+
+    return v, pc + width
+}
+
+func (vm *VM) checkDepth(fn *Function, pc int) {
+    if vm.depth >= MAX_DEPTH {
+        vm.fail(fn, pc, "Stack overflow")
+    }
+}
+
+func (vm *VM) object(fn *Function, pc int, v Value) *Object {
+    obj, _ := v.(*Object)
+    if obj == nil {
+        vm.fail(fn, pc, "Expression is not an object or is null")
+    }
+
+    return obj
+}
+
+func (vm *VM) intOp(fn *Function, pc int, op Opcode, x, y int32) Value {
+    switch op {
+    case OP_ADD_I:
+        return x + y
+    case OP_SUB_I:
+        return x - y
+    case OP_MUL_I:
+        return x * y
+    case OP_DIV_I, OP_MOD_I:
+        if y == 0 {
+            vm.fail(fn, pc, "Integer division by zero")
+        }
+        if op == OP_DIV_I {
+            return x / y
+        }
+        return x % y
+    case OP_LT_I:
+        return x < y
+    case OP_LE_I:
+        return x <= y
+    case OP_GT_I:
+        return x > y
+    }
+
+    return x >= y
+}
+
+func floatOp(op Opcode, x, y float32) Value {
+    switch op {
+    case OP_ADD_F:
+        return x + y
+    case OP_SUB_F:
+        return x - y
+    case OP_MUL_F:
+        return x * y
+    case OP_DIV_F:
+        return x / y
+    case OP_MOD_F:
+        return float32(math.Mod(float64(x), float64(y)))
+    case OP_LT_F:
+        return x < y
+    case OP_LE_F:
+        return x <= y
+    case OP_GT_F:
+        return x > y
+    }
+
+    return x >= y
+}
+
+func (vm *VM) printf(fn *Function, pc int, args []Value) Value {
+    format, _ := args[0].(*String)
+    if format == nil {
+        vm.fail(fn, pc, "printf expects a format string")
+    }
+
+    values := make([]interface{}, len(args) - 1)
+    for i, arg := range args[1:] {
+        if str, ok := arg.(*String); ok {
+            values[i] = &interp.String{Value: str.Value}
+        } else {
+            values[i] = arg
+        }
+    }
+
+    out := interp.Sprintf(format.Value, values)
+    fmt.Fprint(vm.Stdout, out)
+    return int32(len(out))
+}
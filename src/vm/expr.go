@@ -0,0 +1,291 @@
+package vm
+
+import (
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/sema"
+)
+
+var INT_OPS = map[string]Opcode{
+    "+": OP_ADD_I, "-": OP_SUB_I, "*": OP_MUL_I, "/": OP_DIV_I, "%": OP_MOD_I,
+    "<": OP_LT_I, "<=": OP_LE_I, ">": OP_GT_I, ">=": OP_GE_I,
+}
+
+var FLOAT_OPS = map[string]Opcode{
+    "+": OP_ADD_F, "-": OP_SUB_F, "*": OP_MUL_F, "/": OP_DIV_F, "%": OP_MOD_F,
+    "<": OP_LT_F, "<=": OP_LE_F, ">": OP_GT_F, ">=": OP_GE_F,
+}
+
+// value pushes the result of node converted to t.
+func (c *compiler) value(node parser.Node, t sema.Type) {
+    c.expr(node)
+    c.convert(node, c.info.TypeOf(node), t)
+}
+
+// convert emits the implicit conversions sema.Assignable allows.
+func (c *compiler) convert(node parser.Node, from, to sema.Type) {
+    switch {
+    case to == sema.Float && from == sema.Int:
+        c.emit(node, OP_I2F)
+    case to == sema.Float && from == sema.Char:
+        c.emit(node, OP_C2F)
+    case to == sema.Int && from == sema.Char:
+        c.emit(node, OP_C2I)
+    }
+}
+
+func (c *compiler) expr(node parser.Node) {
+    switch n := node.(type) {
+    case *parser.NumLitNode:
+        if n.IsFloat {
+            c.emit(n, OP_CONST, c.constant(n, float32(n.FloatValue)))
+        } else {
+            c.emit(n, OP_CONST, c.constant(n, int32(n.IntValue)))
+        }
+    case *parser.CharLitNode:
+        c.emit(n, OP_CONST, c.constant(n, int8(n.Value)))
+    case *parser.BoolLitNode:
+        if n.Value {
+            c.emit(n, OP_TRUE)
+        } else {
+            c.emit(n, OP_FALSE)
+        }
+    case *parser.StringLitNode:
+        c.emit(n, OP_STRING, c.constant(n, &String{n.Value}))
+    case *parser.FuncLitNode:
+        fn := n.Function.(*parser.FuncNode)
+        index := c.addFunction(&Function{Name: "<func>", Type: c.info.TypeOf(n).(*sema.Func)})
+        c.compileFunc(index, fn, nil)
+        c.emit(n, OP_CLOSURE, index)
+    case *parser.VarAccessNode:
+        c.load(n)
+    case *parser.ObjectAccessNode:
+        c.objectAccess(n)
+    case *parser.CallExprNode:
+        c.call(n)
+    case *parser.MakeExprNode:
+        c.make(n)
+    case *parser.UnaryExprNode:
+        c.unary(n)
+    case *parser.BinaryExprNode:
+        c.binary(n)
+    default:
+        c.fail(node, "Unsupported expression")
+    }
+}
+
+func (c *compiler) load(n *parser.VarAccessNode) {
+    decl, ok := c.info.Defs[n]
+    if !ok {
+        if n.Name.Value == "null" {
+            c.emit(n, OP_NULL)
+            return
+        }
+
+        c.fail(n, n.Name.Value + " can only be called")
+    }
+
+    if depth, slot, ok := c.lookup(decl); ok {
+        if depth == 0 {
+            c.emit(n, OP_GET_LOCAL, slot)
+        } else {
+            c.emit(n, OP_GET_OUTER, depth, slot)
+        }
+        return
+    }
+
+    if global, ok := c.globals[decl]; ok {
+        c.emit(n, OP_GET_GLOBAL, global)
+        return
+    }
+
+    if fn, ok := decl.(*parser.FuncNode); ok {
+        c.emit(n, OP_FUNCTION, c.functions[fn])
+        return
+    }
+
+    c.fail(n, "Undefined variable " + n.Name.Value)
+}
+
+func (c *compiler) objectAccess(n *parser.ObjectAccessNode) {
+    tmpl := c.info.TypeOf(n.Object).(*sema.Template)
+    c.expr(n.Object)
+
+    if _, field := tmpl.Field(n.Member.Value); field >= 0 {
+        c.emit(n, OP_GET_FIELD, field)
+        return
+    }
+
+    if tmpl == sema.String {
+        c.fail(n, n.Member.Value + " can only be called")
+    }
+    c.emit(n, OP_METHOD, c.methods[tmpl.Methods[n.Member.Value]])
+}
+
+func (c *compiler) call(n *parser.CallExprNode) {
+    fn := c.info.TypeOf(n.Function).(*sema.Func)
+
+    switch callee := n.Function.(type) {
+    case *parser.VarAccessNode:
+        decl, ok := c.info.Defs[callee]
+        if !ok && fn == sema.Printf {
+            c.arguments(n.Arguments, fn)
+            c.emit(n, OP_PRINTF, len(n.Arguments))
+            return
+        }
+
+        if !ok && fn == sema.Assert {
+            c.arguments(n.Arguments, fn)
+            c.emit(n, OP_ASSERT)
+            return
+        }
+
+        if !ok {
+            c.arguments(n.Arguments, fn)
+            c.emit(n, OP_CALL_NATIVE, c.native(callee.Name.Value), len(n.Arguments))
+            return
+        }
+
+        if _, _, local := c.lookup(decl); !local {
+            if f, ok := decl.(*parser.FuncNode); ok {
+                c.arguments(n.Arguments, fn)
+                c.emit(n, OP_CALL_FUNC, c.functions[f], len(n.Arguments))
+                return
+            }
+        }
+    case *parser.ObjectAccessNode:
+        tmpl := c.info.TypeOf(callee.Object).(*sema.Template)
+        if field, _ := tmpl.Field(callee.Member.Value); field == nil {
+            c.expr(callee.Object)
+            if tmpl == sema.String {
+                c.emit(n, OP_STRLEN)
+                return
+            }
+
+            c.arguments(n.Arguments, fn)
+            c.emit(n, OP_CALL_FUNC, c.methods[tmpl.Methods[callee.Member.Value]], len(n.Arguments) + 1)
+            return
+        }
+    }
+
+    c.expr(n.Function)
+    c.arguments(n.Arguments, fn)
+    c.emit(n, OP_CALL, len(n.Arguments))
+}
+
+func (c *compiler) arguments(args []parser.Node, fn *sema.Func) {
+    for i, arg := range args {
+        if i < len(fn.Params) {
+            c.value(arg, fn.Params[i])
+        } else {
+            c.expr(arg)
+        }
+    }
+}
+
+func (c *compiler) make(n *parser.MakeExprNode) {
+    tmpl := c.info.Templates[n.Template.Value]
+    c.emit(n, OP_NEW, c.templates[tmpl])
+
+    if tmpl.Constructor != nil {
+        c.emit(n, OP_DUP)
+        c.arguments(n.Arguments, tmpl.Constructor)
+        c.emit(n, OP_CALL_FUNC, c.methods[tmpl.Constructor], len(n.Arguments) + 1)
+        c.emit(n, OP_POP)
+    }
+}
+
+func (c *compiler) unary(n *parser.UnaryExprNode) {
+    c.expr(n.Value)
+
+    if n.Operator == "!" {
+        c.emit(n, OP_NOT)
+        return
+    }
+
+    switch c.info.TypeOf(n) {
+    case sema.Float:
+        c.emit(n, OP_NEG_F)
+    case sema.Int:
+        c.emit(n, OP_NEG_I)
+    case sema.Char:
+        c.emit(n, OP_C2I)
+        c.emit(n, OP_NEG_I)
+        c.emit(n, OP_I2C)
+    }
+}
+
+func (c *compiler) binary(n *parser.BinaryExprNode) {
+    op := n.Operator.Value
+    left := c.info.TypeOf(n.Left)
+    right := c.info.TypeOf(n.Right)
+
+    switch op {
+    case "&&", "||":
+        c.expr(n.Left)
+        jump := OP_JUMP_IF_FALSE_OR_POP
+        if op == "||" {
+            jump = OP_JUMP_IF_TRUE_OR_POP
+        }
+        end := c.emit(n, jump, 0)
+        c.expr(n.Right)
+        c.patch(end)
+    case "==", "!=":
+        if sema.IsNumeric(left) && sema.IsNumeric(right) {
+            c.operands(n, widen(left, right))
+        } else {
+            c.expr(n.Left)
+            c.expr(n.Right)
+        }
+
+        if op == "==" {
+            c.emit(n, OP_EQ)
+        } else {
+            c.emit(n, OP_NE)
+        }
+    case "<", "<=", ">", ">=":
+        t := widen(widen(left, right), sema.Int)
+        c.operands(n, t)
+        c.arithmetic(n, t)
+    default:
+        t := c.info.TypeOf(n)
+        if t == sema.String {
+            c.expr(n.Left)
+            c.expr(n.Right)
+            c.emit(n, OP_CONCAT)
+            return
+        }
+
+        // char arithmetic is done on ints and truncated back
+        work := widen(t, sema.Int)
+        c.operands(n, work)
+        c.arithmetic(n, work)
+        if t == sema.Char {
+            c.emit(n, OP_I2C)
+        }
+    }
+}
+
+func (c *compiler) operands(n *parser.BinaryExprNode, t sema.Type) {
+    c.value(n.Left, t)
+    c.value(n.Right, t)
+}
+
+func (c *compiler) arithmetic(n *parser.BinaryExprNode, t sema.Type) {
+    if t == sema.Float {
+        c.emit(n, FLOAT_OPS[n.Operator.Value])
+    } else {
+        c.emit(n, INT_OPS[n.Operator.Value])
+    }
+}
+
+// widen returns the numeric type both operands convert to.
+func widen(a, b sema.Type) sema.Type {
+    switch {
+    case a == sema.Float || b == sema.Float:
+        return sema.Float
+    case a == sema.Int || b == sema.Int:
+        return sema.Int
+    }
+
+    return sema.Char
+}
@@ -0,0 +1,170 @@
+package vm
+
+import (
+    "errors"
+    "fmt"
+    "reflect"
+
+    "github.com/k3v/lyca/src/sema"
+)
+
+// native is a Go function registered with a VM. Lyca values are converted
+// to and from its parameter and result types with reflection.
+type native struct {
+    fn        reflect.Value
+    signature *sema.Func
+    errs      bool
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+func newNative(name string, fn interface{}) (*native, error) {
+    v := reflect.ValueOf(fn)
+    if v.Kind() != reflect.Func {
+        return nil, fmt.Errorf("%s is a %T, not a function", name, fn)
+    }
+
+    t := v.Type()
+    n := &native{fn: v, signature: &sema.Func{Name: name, Return: sema.Void}}
+    for i := 0; i < t.NumIn(); i++ {
+        param, ok := lycaType(t.In(i))
+        if !ok || t.IsVariadic() {
+            return nil, fmt.Errorf("%s: parameter %d has unsupported type %s", name, i, t.In(i))
+        }
+        n.signature.Params = append(n.signature.Params, param)
+    }
+
+    results := t.NumOut()
+    if results > 0 && t.Out(results - 1) == errorType {
+        n.errs = true
+        results--
+    }
+
+    switch results {
+    case 0:
+    case 1:
+        ret, ok := lycaType(t.Out(0))
+        if !ok {
+            return nil, fmt.Errorf("%s: result has unsupported type %s", name, t.Out(0))
+        }
+        n.signature.Return = ret
+    default:
+        return nil, fmt.Errorf("%s: functions may return at most one value and an error", name)
+    }
+
+    return n, nil
+}
+
+func lycaType(t reflect.Type) (sema.Type, bool) {
+    switch t.Kind() {
+    case reflect.Int, reflect.Int32, reflect.Int64:
+        return sema.Int, true
+    case reflect.Float32, reflect.Float64:
+        return sema.Float, true
+    case reflect.Int8, reflect.Uint8:
+        return sema.Char, true
+    case reflect.Bool:
+        return sema.Boolean, true
+    case reflect.String:
+        return sema.String, true
+    }
+
+    return nil, false
+}
+
+// call calls the Go function with args. A panic in the function is
+// returned as an error, like an error result.
+func (n *native) call(args []Value) (ret Value, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("Go function %s panicked: %v", n.signature.Name, r)
+        }
+    }()
+
+    t := n.fn.Type()
+    in := make([]reflect.Value, len(args))
+    for i, arg := range args {
+        v, err := toGo(arg, t.In(i))
+        if err != nil {
+            return nil, err
+        }
+        in[i] = v
+    }
+
+    out := n.fn.Call(in)
+    if n.errs {
+        if err, _ := out[len(out) - 1].Interface().(error); err != nil {
+            return nil, err
+        }
+        out = out[:len(out) - 1]
+    }
+
+    if len(out) == 0 {
+        return nil, nil
+    }
+    return fromGo(out[0].Interface())
+}
+
+func toGo(value Value, t reflect.Type) (reflect.Value, error) {
+    var v interface{}
+    switch x := value.(type) {
+    case int32:
+        v = x
+    case float32:
+        v = x
+    case int8:
+        v = x
+    case bool:
+        v = x
+    case *String:
+        v = x.Value
+    case nil:
+        if t.Kind() == reflect.String {
+            return reflect.Value{}, errors.New("null string passed to Go function")
+        }
+    }
+
+    rv := reflect.ValueOf(v)
+    if !rv.IsValid() || !rv.Type().ConvertibleTo(t) {
+        return reflect.Value{}, fmt.Errorf("cannot pass %T as %s", value, t)
+    }
+
+    return rv.Convert(t), nil
+}
+
+// fromGo converts a Go value to the Lyca value of the corresponding type.
+func fromGo(v interface{}) (Value, error) {
+    switch x := v.(type) {
+    case int:
+        return int32(x), nil
+    case int32:
+        return x, nil
+    case int64:
+        return int32(x), nil
+    case float32:
+        return x, nil
+    case float64:
+        return float32(x), nil
+    case int8:
+        return x, nil
+    case uint8:
+        return int8(x), nil
+    case bool:
+        return x, nil
+    case string:
+        return &String{x}, nil
+    case nil:
+        return nil, nil
+    }
+
+    return nil, fmt.Errorf("cannot convert %T to a Lyca value", v)
+}
+
+// toHost converts a Lyca value for callers of VM.Call.
+func toHost(v Value) interface{} {
+    if str, ok := v.(*String); ok {
+        return str.Value
+    }
+
+    return v
+}
@@ -0,0 +1,166 @@
+package vm
+
+// Instructions are a one byte opcode followed by its operands. Operands
+// are big endian, two bytes wide unless the opcode's table entry says
+// otherwise: argument counts and closure depths fit in one byte.
+
+type Opcode byte
+
+const (
+    OP_CONST Opcode = iota
+    OP_STRING
+    OP_NULL
+    OP_TRUE
+    OP_FALSE
+    OP_POP
+    OP_DUP
+
+    OP_GET_LOCAL
+    OP_SET_LOCAL
+    OP_GET_OUTER
+    OP_SET_OUTER
+    OP_GET_GLOBAL
+    OP_SET_GLOBAL
+    OP_GET_FIELD
+    OP_SET_FIELD
+
+    OP_NEW
+    OP_FUNCTION
+    OP_CLOSURE
+    OP_METHOD
+
+    OP_CALL
+    OP_CALL_FUNC
+    OP_CALL_NATIVE
+    OP_PRINTF
+    OP_STRLEN
+    OP_ASSERT
+    OP_RETURN
+
+    OP_JUMP
+    OP_JUMP_IF_FALSE
+    OP_JUMP_IF_FALSE_OR_POP
+    OP_JUMP_IF_TRUE_OR_POP
+
+    OP_I2F
+    OP_C2I
+    OP_C2F
+    OP_I2C
+
+    OP_ADD_I
+    OP_SUB_I
+    OP_MUL_I
+    OP_DIV_I
+    OP_MOD_I
+    OP_NEG_I
+    OP_ADD_F
+    OP_SUB_F
+    OP_MUL_F
+    OP_DIV_F
+    OP_MOD_F
+    OP_NEG_F
+    OP_CONCAT
+
+    OP_LT_I
+    OP_LE_I
+    OP_GT_I
+    OP_GE_I
+    OP_LT_F
+    OP_LE_F
+    OP_GT_F
+    OP_GE_F
+    OP_EQ
+    OP_NE
+    OP_NOT
+)
+
+type opcodeInfo struct {
+    name     string
+    operands []int
+}
+
+var OPCODES = []opcodeInfo{
+    OP_CONST:    {"CONST", []int{2}},
+    OP_STRING:   {"STRING", []int{2}},
+    OP_NULL:     {"NULL", nil},
+    OP_TRUE:     {"TRUE", nil},
+    OP_FALSE:    {"FALSE", nil},
+    OP_POP:      {"POP", nil},
+    OP_DUP:      {"DUP", nil},
+
+    OP_GET_LOCAL:  {"GET_LOCAL", []int{2}},
+    OP_SET_LOCAL:  {"SET_LOCAL", []int{2}},
+    OP_GET_OUTER:  {"GET_OUTER", []int{1, 2}},
+    OP_SET_OUTER:  {"SET_OUTER", []int{1, 2}},
+    OP_GET_GLOBAL: {"GET_GLOBAL", []int{2}},
+    OP_SET_GLOBAL: {"SET_GLOBAL", []int{2}},
+    OP_GET_FIELD:  {"GET_FIELD", []int{2}},
+    OP_SET_FIELD:  {"SET_FIELD", []int{2}},
+
+    OP_NEW:      {"NEW", []int{2}},
+    OP_FUNCTION: {"FUNCTION", []int{2}},
+    OP_CLOSURE:  {"CLOSURE", []int{2}},
+    OP_METHOD:   {"METHOD", []int{2}},
+
+    OP_CALL:        {"CALL", []int{1}},
+    OP_CALL_FUNC:   {"CALL_FUNC", []int{2, 1}},
+    OP_CALL_NATIVE: {"CALL_NATIVE", []int{2, 1}},
+    OP_PRINTF:      {"PRINTF", []int{1}},
+    OP_STRLEN:      {"STRLEN", nil},
+    OP_ASSERT:      {"ASSERT", nil},
+    OP_RETURN:      {"RETURN", nil},
+
+    OP_JUMP:                {"JUMP", []int{2}},
+    OP_JUMP_IF_FALSE:       {"JUMP_IF_FALSE", []int{2}},
+    OP_JUMP_IF_FALSE_OR_POP: {"JUMP_IF_FALSE_OR_POP", []int{2}},
+    OP_JUMP_IF_TRUE_OR_POP:  {"JUMP_IF_TRUE_OR_POP", []int{2}},
+
+    OP_I2F: {"I2F", nil},
+    OP_C2I: {"C2I", nil},
+    OP_C2F: {"C2F", nil},
+    OP_I2C: {"I2C", nil},
+
+    OP_ADD_I:  {"ADD_I", nil},
+    OP_SUB_I:  {"SUB_I", nil},
+    OP_MUL_I:  {"MUL_I", nil},
+    OP_DIV_I:  {"DIV_I", nil},
+    OP_MOD_I:  {"MOD_I", nil},
+    OP_NEG_I:  {"NEG_I", nil},
+    OP_ADD_F:  {"ADD_F", nil},
+    OP_SUB_F:  {"SUB_F", nil},
+    OP_MUL_F:  {"MUL_F", nil},
+    OP_DIV_F:  {"DIV_F", nil},
+    OP_MOD_F:  {"MOD_F", nil},
+    OP_NEG_F:  {"NEG_F", nil},
+    OP_CONCAT: {"CONCAT", nil},
+
+    OP_LT_I: {"LT_I", nil},
+    OP_LE_I: {"LE_I", nil},
+    OP_GT_I: {"GT_I", nil},
+    OP_GE_I: {"GE_I", nil},
+    OP_LT_F: {"LT_F", nil},
+    OP_LE_F: {"LE_F", nil},
+    OP_GT_F: {"GT_F", nil},
+    OP_GE_F: {"GE_F", nil},
+    OP_EQ:   {"EQ", nil},
+    OP_NE:   {"NE", nil},
+    OP_NOT:  {"NOT", nil},
+}
+
+func (op Opcode) String() string {
+    if int(op) < len(OPCODES) {
+        return OPCODES[op].name
+    }
+
+    return "UNKNOWN"
+}
+
+// Size is the encoded length of the instruction in bytes.
+func (op Opcode) Size() int {
+    size := 1
+    for _, width := range OPCODES[op].operands {
+        size += width
+    }
+
+    return size
+}
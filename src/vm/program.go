@@ -0,0 +1,75 @@
+package vm
+
+import (
+    "sort"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/sema"
+)
+
+// Program is the compiled form of a Lyca file. It holds no run time
+// state, so a single program can be loaded into several VMs.
+type Program struct {
+    Constants []Value
+    Functions []*Function
+    Templates []*Template
+    Globals   []string
+
+    // Natives names the Go functions the program calls, in the order of
+    // the CALL_NATIVE operands. They are bound by name when loading.
+    Natives []string
+
+    // Init evaluates the initializers of global variables.
+    Init int
+
+    // Tests and Benches are the functions of the test and bench blocks,
+    // in source order.
+    Tests   []int
+    Benches []int
+}
+
+func (p *Program) Function(name string) (*Function, int) {
+    for i, fn := range p.Functions {
+        if fn.Name == name && fn.Template == nil {
+            return fn, i
+        }
+    }
+
+    return nil, -1
+}
+
+type Function struct {
+    Name     string
+    Template *Template
+    Type     *sema.Func
+
+    // Params counts the receiver of methods and constructors as well.
+    Params int
+    Locals int
+    Code   []byte
+
+    lines []line
+}
+
+type line struct {
+    pc   int
+    span lexer.Span
+}
+
+// Span returns the source location of the instruction at pc.
+func (fn *Function) Span(pc int) lexer.Span {
+    i := sort.Search(len(fn.lines), func(i int) bool {
+        return fn.lines[i].pc > pc
+    })
+    if i == 0 {
+        return lexer.Span{}
+    }
+
+    return fn.lines[i - 1].span
+}
+
+type Template struct {
+    Name   string
+    Fields []string
+    Zero   []Value
+}
@@ -0,0 +1,58 @@
+package vm
+
+import (
+    "github.com/k3v/lyca/src/sema"
+)
+
+// Values use the same Go types as the interpreter: int32 (int), float32
+// (float), int8 (char), bool (boolean), *String, *Object, *Closure and
+// nil for null.
+type Value interface{}
+
+type String struct {
+    Value string
+}
+
+type Object struct {
+    Template *Template
+    Fields   []Value
+}
+
+type Closure struct {
+    Function *Function
+    Env      *env
+
+    // This is the receiver of a method value, passed as its first argument.
+    This  Value
+    Bound bool
+}
+
+// env holds the locals of one call. Function literals keep the env they
+// were created in, so the variables they capture outlive the call.
+type env struct {
+    locals []Value
+    outer  *env
+}
+
+func (e *env) up(depth int) *env {
+    for ; depth > 0; depth-- {
+        e = e.outer
+    }
+
+    return e
+}
+
+func zero(t sema.Type) Value {
+    switch t {
+    case sema.Int:
+        return int32(0)
+    case sema.Float:
+        return float32(0)
+    case sema.Char:
+        return int8(0)
+    case sema.Boolean:
+        return false
+    }
+
+    return nil
+}
@@ -0,0 +1,277 @@
+package vm
+
+import (
+    "fmt"
+    "io"
+    "strconv"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/sema"
+)
+
+const MAX_DEPTH = 10000
+
+// VM runs compiled programs. Register Go functions before compiling any
+// program that calls them, then Load a program before running it:
+//
+//     m := vm.New(os.Stdout)
+//     m.Register("now", func() int { return time.Now().Second() })
+//     prog, err := m.Compile(tree)
+//     err = m.Load(prog)
+//     code, err := m.Run(args)
+type VM struct {
+    Stdout io.Writer
+
+    // Allocs counts the objects, concatenated strings, closures and bound
+    // methods the program allocated, and strings returned by Go
+    // functions. Benchmarks reset and read it around their iterations.
+    Allocs int64
+
+    natives map[string]*native
+
+    prog    *Program
+    bound   []*native
+    globals []Value
+    stack   []Value
+    depth   int
+}
+
+func New(stdout io.Writer) *VM {
+    return &VM{
+        Stdout: stdout,
+        natives: map[string]*native{},
+    }
+}
+
+// Register makes fn callable from Lyca as name. Parameters and the result
+// may be Go integers, floats, bools, bytes and strings; a trailing error
+// result aborts the script with that error.
+func (vm *VM) Register(name string, fn interface{}) error {
+    if name == sema.Printf.Name || name == sema.Assert.Name || name == "main" {
+        return fmt.Errorf("%s is reserved", name)
+    }
+
+    n, err := newNative(name, fn)
+    if err != nil {
+        return err
+    }
+
+    vm.natives[name] = n
+    return nil
+}
+
+func (vm *VM) Compile(tree *parser.AST) (*Program, error) {
+    return compile(tree, vm.natives)
+}
+
+// Load binds the program's Go functions and initializes its globals.
+func (vm *VM) Load(prog *Program) (err error) {
+    defer lexer.Recover(&err)
+
+    vm.bound = nil
+    for _, name := range prog.Natives {
+        n, ok := vm.natives[name]
+        if !ok {
+            return &lexer.Error{Message: "Go function " + name + " is not registered"}
+        }
+        vm.bound = append(vm.bound, n)
+    }
+
+    vm.prog = prog
+    vm.globals = make([]Value, len(prog.Globals))
+    vm.reset()
+    vm.enter(prog.Functions[prog.Init], nil, nil, nil)
+
+    return nil
+}
+
+// Run executes main and returns its result as the exit code. args is a
+// command line: args[0] names the program and the rest are bound to
+// main's parameters, like the compiled entry point does.
+func (vm *VM) Run(args []string) (code int, err error) {
+    defer lexer.Recover(&err)
+
+    main, _ := vm.program().Function("main")
+    if main == nil {
+        return 0, &lexer.Error{Message: "no main function to run"}
+    }
+
+    if len(args) > 0 {
+        args = args[1:]
+    }
+
+    params := main.Type.Node.Signature.Parameters
+    if len(params) > len(args) {
+        return 0, &lexer.Error{Location: main.Type.Node.Loc(), Message: "main expects more arguments than were given"}
+    }
+
+    var values []Value
+    for i, param := range params {
+        values = append(values, argument(param, main.Type.Params[i], args[i]))
+    }
+
+    vm.reset()
+    if v, ok := vm.enter(main, nil, values, nil).(int32); ok {
+        code = int(v)
+    }
+
+    return code, nil
+}
+
+// Call runs the top-level function name with Go arguments, converting
+// them like the arguments of registered functions. Arguments of the
+// wrong number or type are an error.
+func (vm *VM) Call(name string, args ...interface{}) (result interface{}, err error) {
+    defer lexer.Recover(&err)
+
+    fn, _ := vm.program().Function(name)
+    if fn == nil || fn.Name == "<init>" {
+        return nil, &lexer.Error{Message: "undefined function " + name}
+    }
+
+    if len(args) != fn.Params {
+        return nil, &lexer.Error{Message: "wrong number of arguments in call to " + name}
+    }
+
+    var values []Value
+    for i, arg := range args {
+        v, err := fromGo(arg)
+        if err != nil {
+            return nil, err
+        }
+
+        param := fn.Type.Params[i]
+        if !assignable(v, param) {
+            return nil, &lexer.Error{Message: fmt.Sprintf("argument %d of %s must be %s, not %T", i + 1, name, param, arg)}
+        }
+        values = append(values, convert(v, param))
+    }
+
+    vm.reset()
+    return toHost(vm.enter(fn, nil, values, nil)), nil
+}
+
+// Test runs the i-th test block of the loaded program. A failed
+// assertion is returned as a *lexer.Error located at the call to assert.
+// Load the program again before each test to run it with fresh globals.
+func (vm *VM) Test(i int) (err error) {
+    defer lexer.Recover(&err)
+
+    prog := vm.program()
+    if i < 0 || i >= len(prog.Tests) {
+        return &lexer.Error{Message: "no test " + strconv.Itoa(i)}
+    }
+
+    vm.reset()
+    vm.enter(prog.Functions[prog.Tests[i]], nil, nil, nil)
+    return nil
+}
+
+// Bench runs the i-th bench block of the loaded program n times in a
+// row. Globals are not reset between the iterations.
+func (vm *VM) Bench(i, n int) (err error) {
+    defer lexer.Recover(&err)
+
+    prog := vm.program()
+    if i < 0 || i >= len(prog.Benches) {
+        return &lexer.Error{Message: "no bench " + strconv.Itoa(i)}
+    }
+
+    fn := prog.Functions[prog.Benches[i]]
+    for ; n > 0; n-- {
+        vm.reset()
+        vm.enter(fn, nil, nil, nil)
+    }
+    return nil
+}
+
+func (vm *VM) program() *Program {
+    if vm.prog == nil {
+        lexer.Fail(lexer.Span{}, "no program has been loaded")
+    }
+
+    return vm.prog
+}
+
+func (vm *VM) reset() {
+    vm.stack = vm.stack[:0]
+    vm.depth = 0
+}
+
+func argument(param *parser.VarDeclNode, t sema.Type, arg string) Value {
+    switch t {
+    case sema.Int:
+        v, _ := strconv.ParseInt(arg, 10, 32)
+        return int32(v)
+    case sema.Float:
+        v, _ := strconv.ParseFloat(arg, 32)
+        return float32(v)
+    case sema.String:
+        return &String{arg}
+    }
+
+    lexer.Fail(param.Loc(), "main parameters must be int, float or string")
+    return nil
+}
+
+// assignable reports whether a converted Go argument can be passed as a
+// parameter of type t, widening it like convert does.
+func assignable(v Value, t sema.Type) bool {
+    switch v.(type) {
+    case int32, int8:
+        return t == sema.Int || t == sema.Float || t == sema.Char
+    case float32:
+        return t == sema.Float
+    case bool:
+        return t == sema.Boolean
+    case *String:
+        return t == sema.String
+    case nil:
+        _, basic := t.(*sema.Basic)
+        return !basic
+    }
+
+    return false
+}
+
+// convert widens Go arguments the way the compiler widens Lyca ones.
+func convert(v Value, t sema.Type) Value {
+    switch x := v.(type) {
+    case int32:
+        if t == sema.Float {
+            return float32(x)
+        } else if t == sema.Char {
+            return int8(x)
+        }
+    case int8:
+        if t == sema.Float {
+            return float32(x)
+        } else if t == sema.Int {
+            return int32(x)
+        }
+    }
+
+    return v
+}
+
+// enter calls fn with its locals in a new env whose outer is the env of
+// the closure, if any. A bound receiver becomes the first argument.
+func (vm *VM) enter(fn *Function, outer *env, args []Value, this *Closure) Value {
+    vm.depth++
+    locals := make([]Value, fn.Locals)
+    if this != nil && this.Bound {
+        locals[0] = this.This
+        copy(locals[1:], args)
+    } else {
+        copy(locals, args)
+    }
+
+    ret := vm.execute(fn, &env{locals, outer})
+    vm.depth--
+    return ret
+}
+
+func (vm *VM) fail(fn *Function, pc int, message string) {
+    lexer.Fail(fn.Span(pc), message)
+}
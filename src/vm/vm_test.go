@@ -0,0 +1,296 @@
+package vm
+
+import (
+    "bytes"
+    "errors"
+    "strings"
+    "testing"
+
+    "github.com/k3v/lyca/src/lexer"
+    "github.com/k3v/lyca/src/parser"
+)
+
+func parse(t *testing.T, src string) *parser.AST {
+    toks, err := lexer.Lex(lexer.NewFile([]byte(strings.TrimPrefix(src, "\n"))))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tree, err := parser.Parse(toks)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return tree
+}
+
+// load compiles and loads src on m, with the functions m has registered.
+func load(t *testing.T, m *VM, src string) {
+    prog, err := m.Compile(parse(t, src))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := m.Load(prog); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestRegister(t *testing.T) {
+    var stdout bytes.Buffer
+    m := New(&stdout)
+    funcs := map[string]interface{}{
+        "double": func(x int) int { return 2 * x },
+        "half":   func(x float64) float64 { return x / 2 },
+        "shout":  func(s string) string { return strings.ToUpper(s) + "!" },
+        "even":   func(x int32) bool { return x % 2 == 0 },
+    }
+    for name, fn := range funcs {
+        if err := m.Register(name, fn); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    load(t, m, `
+func () > main > (int) {
+    printf("%s %d %f\n", shout("hi"), double(21), half(3.0));
+    if (even(4)) {
+        return 7;
+    }
+    return 0;
+}`)
+    code, err := m.Run(nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if want := "HI! 42 1.500000\n"; stdout.String() != want {
+        t.Errorf("got output %q, want %q", stdout.String(), want)
+    }
+    if code != 7 {
+        t.Errorf("got exit code %d, want 7", code)
+    }
+}
+
+func TestRegisterRejects(t *testing.T) {
+    tests := []struct {
+        name string
+        fn   interface{}
+    }{
+        {"printf", func() {}},
+        {"assert", func() {}},
+        {"main", func() {}},
+        {"value", 42},
+        {"slice", func(xs []int) {}},
+        {"variadic", func(xs ...int) {}},
+        {"pair", func() (int, int) { return 0, 0 }},
+        {"pointer", func() *int { return nil }},
+    }
+
+    for _, test := range tests {
+        if err := New(nil).Register(test.name, test.fn); err == nil {
+            t.Errorf("%s: registered %T", test.name, test.fn)
+        }
+    }
+}
+
+// An error result of a Go function stops the program at the call.
+func TestRegisterError(t *testing.T) {
+    m := New(nil)
+    m.Register("check", func(x int) (int, error) {
+        if x < 0 {
+            return 0, errors.New("negative")
+        }
+        return x, nil
+    })
+
+    load(t, m, `
+func () > main > (int) {
+    int x = check(1);
+    return check(-1);
+}`)
+    _, err := m.Run(nil)
+    expectError(t, err, "negative", 3, 13)
+}
+
+func TestLoadUnregistered(t *testing.T) {
+    m := New(nil)
+    m.Register("now", func() int { return 0 })
+    prog, err := m.Compile(parse(t, `
+func () > main > (int) {
+    return now();
+}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = New(nil).Load(prog)
+    if err == nil || err.Error() != "0:0 Go function now is not registered" {
+        t.Errorf("got error %v, want now to be unregistered", err)
+    }
+}
+
+func TestCall(t *testing.T) {
+    m := New(nil)
+    load(t, m, `
+func (int a, float b) > add > (float) {
+    return a + b;
+}
+
+func (string s) > greet > (string) {
+    return "hello " + s;
+}`)
+
+    if got, err := m.Call("add", 1, 2.5); err != nil || got != float32(3.5) {
+        t.Errorf("add(1, 2.5) = %v, %v, want 3.5", got, err)
+    }
+    if got, err := m.Call("greet", "you"); err != nil || got != "hello you" {
+        t.Errorf("greet(\"you\") = %v, %v, want hello you", got, err)
+    }
+    if _, err := m.Call("add", 1); err == nil {
+        t.Error("add(1) did not fail")
+    }
+
+    wrong := []struct {
+        name string
+        args []interface{}
+        err  string
+    }{
+        {"add", []interface{}{"x", 1.0}, "argument 1 of add must be int, not string"},
+        {"add", []interface{}{1, true}, "argument 2 of add must be float, not bool"},
+        {"add", []interface{}{1.5, 1.0}, "argument 1 of add must be int, not float64"},
+        {"greet", []interface{}{2}, "argument 1 of greet must be string, not int"},
+    }
+    for _, w := range wrong {
+        if _, err := m.Call(w.name, w.args...); err == nil || !strings.HasSuffix(err.Error(), w.err) {
+            t.Errorf("%s%v: got error %v, want %s", w.name, w.args, err, w.err)
+        }
+    }
+    if _, err := m.Call("missing"); err == nil {
+        t.Error("missing() did not fail")
+    }
+}
+
+// A panic in a Go function ends the script with an error located at
+// the call instead of crashing the host.
+func TestRegisterPanic(t *testing.T) {
+    m := New(nil)
+    if err := m.Register("boom", func(i int) int { return []int{}[i] }); err != nil {
+        t.Fatal(err)
+    }
+    load(t, m, `
+func () > main > (int) {
+    return boom(3);
+}`)
+
+    _, err := m.Run(nil)
+    e, ok := err.(*lexer.Error)
+    if !ok || !strings.HasPrefix(e.Message, "Go function boom panicked: ") || e.Location.Start.Line != 2 {
+        t.Errorf("got error %v, want a located panic of boom", err)
+    }
+}
+
+func TestStackOverflow(t *testing.T) {
+    m := New(nil)
+    load(t, m, `
+func (int n) > down > (int) {
+    return down(n + 1);
+}
+
+func () > main > (int) {
+    return down(0);
+}`)
+    _, err := m.Run(nil)
+    expectError(t, err, "Stack overflow", 2, 13)
+
+    // the VM can still run after an overflow
+    if _, err := m.Call("main"); err == nil || !strings.Contains(err.Error(), "Stack overflow") {
+        t.Errorf("got %v on the second run, want another overflow", err)
+    }
+}
+
+// Run time errors are located at the expression that failed.
+func TestRuntimeErrors(t *testing.T) {
+    tests := []struct {
+        name    string
+        src     string
+        message string
+        line    int
+        offset  int
+    }{
+        {"division by zero", `
+func (int d) > f > (int) {
+    return 10 / d;
+}
+
+func () > main > (int) {
+    return f(0);
+}`, "Integer division by zero", 2, 13},
+        {"null object", `
+tmpl Point {
+    int x;
+}
+
+func () > main > (int) {
+    Point p;
+    return p.x;
+}`, "Expression is not an object or is null", 7, 13},
+        {"null function", `
+tmpl Box {
+    func () > (int) f;
+}
+
+func () > main > (int) {
+    Box b = make Box < ();
+    return b.f();
+}`, "Call of a null function", 7, 13},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            m := New(nil)
+            load(t, m, test.src)
+            _, err := m.Run(nil)
+            expectError(t, err, test.message, test.line, test.offset)
+        })
+    }
+}
+
+// The first argument names the program, like argv[0] of the compiled
+// entry point.
+func TestRunArguments(t *testing.T) {
+    var stdout bytes.Buffer
+    m := New(&stdout)
+    load(t, m, `
+func (int n, string s) > main > (int) {
+    printf("%d %s\n", n, s);
+    return n;
+}`)
+
+    code, err := m.Run([]string{"f.lyca", "5", "hello"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stdout.String() != "5 hello\n" || code != 5 {
+        t.Errorf("got %q and exit code %d, want \"5 hello\\n\" and 5", stdout.String(), code)
+    }
+
+    _, err = m.Run([]string{"f.lyca", "5"})
+    if e, ok := err.(*lexer.Error); !ok || e.Message != "main expects more arguments than were given" {
+        t.Errorf("got error %v, want too few arguments", err)
+    }
+}
+
+// expectError checks err is a *lexer.Error with message at line and
+// offset. Past the first line, offsets count the newline before the line
+// as its first character, like every diagnostic does.
+func expectError(t *testing.T, err error, message string, line, offset int) {
+    t.Helper()
+    e, ok := err.(*lexer.Error)
+    if !ok {
+        t.Fatalf("got error %v, want a *lexer.Error", err)
+    }
+
+    start := e.Location.Start
+    if e.Message != message || start.Line != line || start.Offset != offset {
+        t.Errorf("got %d:%d %s, want %d:%d %s", start.Line, start.Offset, e.Message, line, offset, message)
+    }
+}
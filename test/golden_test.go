@@ -0,0 +1,307 @@
+// Package test checks the example programs in src and fail against
+// golden files next to them.
+//
+// Every program in src must compile. It is run with each backend that
+// needs no LLVM, and what main returns and prints must match the .out
+// file of the same name: a first line "exit N", then stdout verbatim.
+// When a C compiler is installed the programs are also built with the C
+// backend, except for those using what it does not support, and with
+// LLVM, node and wasm-ld for wasm32, run by runtime/wasm/host.js.
+// Every program in fail must not compile, and its .out file lists the
+// diagnostics in order. The programs in dump are printed with lyca dump
+// --tokens and --ast in every structured format, each into a golden
+// file named for both, like dump.tokens.json. The programs in doc are
+// rendered with lyca doc as Markdown and HTML, into doc.md and doc.html.
+//
+// After a change in output that is intended, rewrite the golden files
+// with
+//
+//     go test ./test -update
+package test
+
+import (
+    "bytes"
+    "flag"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/k3v/lyca/src/doc"
+    "github.com/k3v/lyca/src/interp"
+    "github.com/k3v/lyca/src/lyca"
+    "github.com/k3v/lyca/src/parser"
+    "github.com/k3v/lyca/src/vm"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current output")
+
+// BACKENDS run a program's main and return its exit code. The first one
+// writes the golden files on -update, the rest must agree with it.
+var BACKENDS = []struct {
+    name string
+    run  func(tree *parser.AST, stdout io.Writer) (int, error)
+}{
+    {"interp", func(tree *parser.AST, stdout io.Writer) (int, error) {
+        return interp.New(tree, stdout).Run([]string{"main"})
+    }},
+    {"vm", func(tree *parser.AST, stdout io.Writer) (int, error) {
+        m := vm.New(stdout)
+        prog, err := m.Compile(tree)
+        if err != nil {
+            return 0, err
+        }
+
+        if err := m.Load(prog); err != nil {
+            return 0, err
+        }
+        return m.Run([]string{"main"})
+    }},
+}
+
+func TestPrograms(t *testing.T) {
+    for _, path := range programs(t, "src") {
+        path := path
+        t.Run(strings.TrimSuffix(filepath.Base(path), ".lyca"), func(t *testing.T) {
+            sources := read(t, path)
+            if diags := lyca.Check(sources); len(diags) > 0 {
+                t.Fatalf("does not compile:\n%s", diagnostics(diags))
+            }
+
+            for i, backend := range BACKENDS {
+                // every backend gets a tree of its own
+                tree, _ := lyca.Parse(sources)
+
+                var stdout bytes.Buffer
+                code, err := backend.run(tree, &stdout)
+                if err != nil {
+                    t.Errorf("%s: %v", backend.name, err)
+                    continue
+                }
+
+                got := fmt.Sprintf("exit %d\n%s", code, stdout.String())
+                if i == 0 {
+                    golden(t, path, got)
+                } else if want := expected(t, path); got != want {
+                    t.Errorf("%s: output differs from %s\n%s", backend.name, outPath(path), diff(want, got))
+                }
+            }
+        })
+    }
+}
+
+// C_UNSUPPORTED are the programs the C backend rejects, with the
+// feature it names.
+var C_UNSUPPORTED = map[string]string{
+    "basic": "function values",
+}
+
+func TestProgramsC(t *testing.T) {
+    cc := os.Getenv("CC")
+    if cc == "" {
+        cc = "cc"
+    }
+    if _, err := exec.LookPath(cc); err != nil {
+        t.Skip("no C compiler")
+    }
+
+    dir, err := ioutil.TempDir("", "lyca-c")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    for _, path := range programs(t, "src") {
+        path := path
+        name := strings.TrimSuffix(filepath.Base(path), ".lyca")
+        t.Run(name, func(t *testing.T) {
+            res, diags := lyca.Compile(read(t, path), lyca.Options{Emit: lyca.EmitC})
+            if feature, ok := C_UNSUPPORTED[name]; ok {
+                want := "does not support " + feature
+                if len(diags) == 0 || !strings.Contains(diags[0].Message, want) {
+                    t.Errorf("got %v, want a diagnostic that the C backend %s", diags, want)
+                }
+                return
+            }
+            if len(diags) > 0 {
+                t.Fatalf("does not compile:\n%s", diagnostics(diags))
+            }
+
+            src, exe := filepath.Join(dir, name + ".c"), filepath.Join(dir, name)
+            if err := ioutil.WriteFile(src, res.Output, 0644); err != nil {
+                t.Fatal(err)
+            }
+            if out, err := exec.Command(cc, "-std=c99", "-fwrapv", src, "-o", exe, "-lm").CombinedOutput(); err != nil {
+                t.Fatalf("%v\n%s", err, out)
+            }
+
+            var stdout bytes.Buffer
+            cmd := exec.Command(exe)
+            cmd.Stdout = &stdout
+            code := 0
+            if err := cmd.Run(); err != nil {
+                exit, ok := err.(*exec.ExitError)
+                if !ok {
+                    t.Fatal(err)
+                }
+                code = exit.ExitCode()
+            }
+
+            got := fmt.Sprintf("exit %d\n%s", code, stdout.String())
+            if want := expected(t, path); got != want {
+                t.Errorf("output differs from %s\n%s", outPath(path), diff(want, got))
+            }
+        })
+    }
+}
+
+func TestFailures(t *testing.T) {
+    for _, path := range programs(t, "fail") {
+        path := path
+        t.Run(strings.TrimSuffix(filepath.Base(path), ".lyca"), func(t *testing.T) {
+            diags := lyca.Check(read(t, path))
+            if len(diags) == 0 {
+                t.Fatal("compiled without diagnostics")
+            }
+            golden(t, path, diagnostics(diags))
+        })
+    }
+}
+
+// DUMPS are what TestDump prints, by the golden file extension.
+var DUMPS = []struct {
+    ext    string
+    emit   lyca.Emit
+    format lyca.Format
+}{
+    {".tokens.json", lyca.EmitTokens, lyca.FormatJSON},
+    {".tokens.sexp", lyca.EmitTokens, lyca.FormatSexp},
+    {".ast.json", lyca.EmitAST, lyca.FormatJSON},
+    {".ast.sexp", lyca.EmitAST, lyca.FormatSexp},
+}
+
+func TestDump(t *testing.T) {
+    for _, path := range programs(t, "dump") {
+        path := path
+        t.Run(strings.TrimSuffix(filepath.Base(path), ".lyca"), func(t *testing.T) {
+            sources := read(t, path)
+            for _, d := range DUMPS {
+                res, diags := lyca.Compile(sources, lyca.Options{Emit: d.emit, Format: d.format})
+                if len(diags) > 0 {
+                    t.Fatalf("does not parse:\n%s", diagnostics(diags))
+                }
+                compare(t, strings.TrimSuffix(path, ".lyca") + d.ext, string(res.Output))
+            }
+        })
+    }
+}
+
+func TestDoc(t *testing.T) {
+    for _, path := range programs(t, "doc") {
+        path := path
+        t.Run(strings.TrimSuffix(filepath.Base(path), ".lyca"), func(t *testing.T) {
+            tree, diags := lyca.Parse(read(t, path))
+            if len(diags) > 0 {
+                t.Fatalf("does not parse:\n%s", diagnostics(diags))
+            }
+
+            pkg := doc.New("doc", tree)
+            renderers := []struct {
+                ext    string
+                render func(io.Writer) error
+            }{
+                {".md", pkg.Markdown},
+                {".html", pkg.HTML},
+            }
+            for _, r := range renderers {
+                var out bytes.Buffer
+                if err := r.render(&out); err != nil {
+                    t.Fatal(err)
+                }
+                compare(t, strings.TrimSuffix(path, ".lyca") + r.ext, out.String())
+            }
+        })
+    }
+}
+
+func programs(t *testing.T, dir string) []string {
+    paths, err := filepath.Glob(filepath.Join(dir, "*.lyca"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(paths) == 0 {
+        t.Fatalf("no programs in %s", dir)
+    }
+    return paths
+}
+
+// read loads a program keyed by its base name, which is what
+// diagnostics print.
+func read(t *testing.T, path string) map[string][]byte {
+    src, err := ioutil.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return map[string][]byte{filepath.Base(path): src}
+}
+
+func diagnostics(diags []lyca.Diagnostic) string {
+    var b strings.Builder
+    for _, d := range diags {
+        b.WriteString(d.String() + "\n")
+    }
+    return b.String()
+}
+
+// golden compares got with the .out file of the program at path, or
+// writes it there on -update.
+func golden(t *testing.T, path, got string) {
+    compare(t, outPath(path), got)
+}
+
+// compare checks got against the golden file at path, or writes it
+// there on -update.
+func compare(t *testing.T, path, got string) {
+    if *update {
+        if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+            t.Fatal(err)
+        }
+        return
+    }
+
+    if want := contents(t, path); got != want {
+        t.Errorf("output differs from %s\n%s", path, diff(want, got))
+    }
+}
+
+func outPath(path string) string {
+    return strings.TrimSuffix(path, ".lyca") + ".out"
+}
+
+func expected(t *testing.T, path string) string {
+    return contents(t, outPath(path))
+}
+
+func contents(t *testing.T, path string) string {
+    want, err := ioutil.ReadFile(path)
+    if err != nil {
+        t.Fatalf("%v, run go test -update to create it", err)
+    }
+    return string(want)
+}
+
+// diff lists the lines of want and got from the first one that differs.
+func diff(want, got string) string {
+    wl, gl := strings.SplitAfter(want, "\n"), strings.SplitAfter(got, "\n")
+    i := 0
+    for i < len(wl) && i < len(gl) && wl[i] == gl[i] {
+        i++
+    }
+
+    return fmt.Sprintf("from line %d\nwant:\n%s\ngot:\n%s", i + 1,
+        strings.Join(wl[i:], ""), strings.Join(gl[i:], ""))
+}
@@ -0,0 +1,71 @@
+// +build !nollvm
+
+package test
+
+import (
+    "bytes"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/k3v/lyca/src/lyca"
+)
+
+// HOST runs a wasm module's main with the runtime's printf.
+const HOST = "../runtime/wasm/host.js"
+
+// The example programs built for wasm32 and run by node print what the
+// other backends do.
+func TestProgramsWasm(t *testing.T) {
+    for _, tool := range []string{"node", "wasm-ld"} {
+        if _, err := exec.LookPath(tool); err != nil {
+            t.Skipf("no %s", tool)
+        }
+    }
+
+    dir, err := ioutil.TempDir("", "lyca-wasm")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    for _, path := range programs(t, "src") {
+        path := path
+        name := strings.TrimSuffix(filepath.Base(path), ".lyca")
+        t.Run(name, func(t *testing.T) {
+            res, diags := lyca.Compile(read(t, path), lyca.Options{Emit: lyca.EmitExecutable, Triple: "wasm32"})
+            if len(diags) > 0 {
+                t.Fatalf("does not compile:\n%s", diagnostics(diags))
+            }
+
+            module := filepath.Join(dir, name + ".wasm")
+            if err := ioutil.WriteFile(module, res.Output, 0644); err != nil {
+                t.Fatal(err)
+            }
+
+            var stdout, stderr bytes.Buffer
+            cmd := exec.Command("node", HOST, module)
+            cmd.Stdout, cmd.Stderr = &stdout, &stderr
+            code := 0
+            if err := cmd.Run(); err != nil {
+                exit, ok := err.(*exec.ExitError)
+                if !ok {
+                    t.Fatal(err)
+                }
+                code = exit.ExitCode()
+            }
+            if stderr.Len() > 0 {
+                t.Fatalf("host failed: %s", stderr.String())
+            }
+
+            got := fmt.Sprintf("exit %d\n%s", code, stdout.String())
+            if want := expected(t, path); got != want {
+                t.Errorf("output differs from %s\n%s", outPath(path), diff(want, got))
+            }
+        })
+    }
+}